@@ -0,0 +1,93 @@
+package acl
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	CommandConnect   = "CONNECT"
+	CommandAssociate = "ASSOCIATE"
+	CommandHTTP      = "HTTP"
+)
+
+var ErrDenied = errors.New("denied by policy")
+
+// Request 描述一次待放行的代理请求
+type Request struct {
+	User    string   // 认证后的用户名, 未认证与 SOCKS4 请求为空
+	Client  net.Addr // 客户端地址
+	Command string   // CommandConnect / CommandAssociate / CommandHTTP
+	Host    string   // 目标主机, IP 或域名
+	Port    int      // 目标端口
+	// Resolved 为 RuleSet.Allow 解析域名后检查过的地址, 未解析时为 nil
+	Resolved net.IP
+}
+
+// Policy 返回 false 时拒绝该请求, SOCKS5 回复 0x02, SOCKS4 回复 91, HTTP 回复 403
+type Policy func(*Request) bool
+
+// NewRequest 由目标地址 host:port 构造 Request
+func NewRequest(user string, client net.Addr, command, address string) (*Request, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{
+		User:    user,
+		Client:  client,
+		Command: command,
+		Host:    host,
+		Port:    portNum,
+	}, nil
+}
+
+func (r *Request) Address() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+}
+
+// DialAddress 把指向 Host 的地址换成 Resolved, 拨号时不再重新解析域名; 其他地址原样返回
+func (r *Request) DialAddress(address string) string {
+	if r.Resolved == nil {
+		return address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !strings.EqualFold(strings.TrimSuffix(host, "."), strings.TrimSuffix(r.Host, ".")) {
+		return address
+	}
+	return net.JoinHostPort(r.Resolved.String(), port)
+}
+
+// ClientIP 返回客户端 IP, 无法解析时返回 nil
+func (r *Request) ClientIP() net.IP {
+	if r.Client == nil {
+		return nil
+	}
+	switch addr := r.Client.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(r.Client.String())
+	if err != nil {
+		host = r.Client.String()
+	}
+	return net.ParseIP(host)
+}
+
+// ParseAddr 把 http.Request.RemoteAddr 之类的 host:port 字符串转换成 net.Addr
+func ParseAddr(address string) net.Addr {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	portNum, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: portNum}
+}
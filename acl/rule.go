@@ -0,0 +1,264 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type Action int
+
+const (
+	Deny Action = iota
+	Allow
+)
+
+type PortRange struct {
+	From, To int
+}
+
+func (p PortRange) Contains(port int) bool {
+	return port >= p.From && port <= p.To
+}
+
+// Rule 中每个非空字段都必须命中, 空字段表示不限制
+type Rule struct {
+	Action   Action
+	Users    []string
+	Sources  []*net.IPNet // 客户端地址
+	CIDRs    []*net.IPNet // 目标地址
+	Domains  []string     // 目标域名后缀, "example.com" 同时匹配其子域名
+	Ports    []PortRange
+	Commands []string
+}
+
+// RuleSet 按顺序匹配规则, 第一条命中的规则决定结果, 都未命中时使用 Default
+type RuleSet struct {
+	Rules   []Rule
+	Default Action
+	// 默认在域名目标遇到含 CIDRs 的规则时解析域名后再匹配, 防止用域名绕过内网网段限制。
+	// NoResolve 为 true 时不解析, 域名目标不会命中 CIDRs
+	NoResolve bool
+}
+
+// Allow 实现 Policy。为匹配 CIDRs 解析过域名且结果为放行时, 把检查过的地址记录在 r.Resolved,
+// 拨号时使用它而不是再次解析, 否则两次解析结果不同时可以绕过检查
+func (s *RuleSet) Allow(r *Request) bool {
+	var resolved []net.IP
+	resolve := func() []net.IP { return nil }
+	if !s.NoResolve && net.ParseIP(r.Host) == nil {
+		var done bool
+		resolve = func() []net.IP {
+			if !done {
+				resolved, _ = net.LookupIP(r.Host)
+				done = true
+			}
+			return resolved
+		}
+	}
+	action := s.Default
+	var matched *Rule
+	for i := range s.Rules {
+		if s.Rules[i].match(r, resolve) {
+			matched = &s.Rules[i]
+			action = matched.Action
+			break
+		}
+	}
+	if action == Allow && len(resolved) > 0 {
+		r.Resolved = checkedIP(matched, resolved)
+	}
+	return action == Allow
+}
+
+// checkedIP 优先返回命中规则 CIDRs 的地址, 其余情况下每个地址都已检查过, 返回第一个
+func checkedIP(rule *Rule, ips []net.IP) net.IP {
+	if rule != nil {
+		for _, ip := range ips {
+			if containsIP(rule.CIDRs, ip) {
+				return ip
+			}
+		}
+	}
+	return ips[0]
+}
+
+func (rule *Rule) match(r *Request, resolve func() []net.IP) bool {
+	if len(rule.Users) > 0 && !containsFold(rule.Users, r.User) {
+		return false
+	}
+	if len(rule.Commands) > 0 && !containsFold(rule.Commands, r.Command) {
+		return false
+	}
+	if len(rule.Sources) > 0 && !containsIP(rule.Sources, r.ClientIP()) {
+		return false
+	}
+	if len(rule.Ports) > 0 {
+		matched := false
+		for _, p := range rule.Ports {
+			if p.Contains(r.Port) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.CIDRs) == 0 && len(rule.Domains) == 0 {
+		return true
+	}
+	if ip := net.ParseIP(r.Host); ip != nil {
+		return containsIP(rule.CIDRs, ip)
+	}
+	if len(rule.CIDRs) > 0 {
+		for _, ip := range resolve() {
+			if containsIP(rule.CIDRs, ip) {
+				return true
+			}
+		}
+	}
+	host := strings.ToLower(strings.TrimSuffix(r.Host, "."))
+	for _, domain := range rule.Domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRule 解析一行文本规则, 格式为 "allow|deny key=v1,v2 ...", 例如:
+//
+//	deny user=guest cidr=10.0.0.0/8,192.168.0.0/16
+//	allow user=alice port=80,443,8000-8100 cmd=connect
+//	deny domain=internal.corp
+//
+// 支持的 key: user, src, cidr, domain, port, cmd。
+// 目标为域名时, cidr 按解析得到的地址匹配, 见 RuleSet.NoResolve
+func ParseRule(line string) (rule Rule, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return rule, fmt.Errorf("empty rule")
+	}
+	switch strings.ToLower(fields[0]) {
+	case "allow":
+		rule.Action = Allow
+	case "deny":
+		rule.Action = Deny
+	default:
+		return rule, fmt.Errorf("unknown action: %s", fields[0])
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return rule, fmt.Errorf("invalid rule field: %s", field)
+		}
+		values := strings.Split(kv[1], ",")
+		switch strings.ToLower(kv[0]) {
+		case "user":
+			rule.Users = append(rule.Users, values...)
+		case "cmd":
+			rule.Commands = append(rule.Commands, values...)
+		case "domain":
+			rule.Domains = append(rule.Domains, values...)
+		case "src", "cidr":
+			nets, err := parseCIDRs(values)
+			if err != nil {
+				return rule, err
+			}
+			if strings.ToLower(kv[0]) == "src" {
+				rule.Sources = append(rule.Sources, nets...)
+			} else {
+				rule.CIDRs = append(rule.CIDRs, nets...)
+			}
+		case "port":
+			for _, value := range values {
+				p, err := parsePortRange(value)
+				if err != nil {
+					return rule, err
+				}
+				rule.Ports = append(rule.Ports, p)
+			}
+		default:
+			return rule, fmt.Errorf("unknown rule field: %s", kv[0])
+		}
+	}
+	return rule, nil
+}
+
+// ParseRuleSet 逐行解析规则, 忽略空行与 # 开头的注释
+func ParseRuleSet(lines []string, defaultAction Action) (*RuleSet, error) {
+	set := &RuleSet{Default: defaultAction}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		set.Rules = append(set.Rules, rule)
+	}
+	return set, nil
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func parsePortRange(value string) (PortRange, error) {
+	parts := strings.SplitN(value, "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port: %s", value)
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(parts[1]); err != nil {
+			return PortRange{}, fmt.Errorf("invalid port: %s", value)
+		}
+	}
+	if from < 0 || to > 65535 || from > to {
+		return PortRange{}, fmt.Errorf("invalid port range: %s", value)
+	}
+	return PortRange{From: from, To: to}, nil
+}
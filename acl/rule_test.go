@@ -0,0 +1,101 @@
+package acl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRuleSet(t *testing.T) {
+	set, err := ParseRuleSet([]string{
+		"# 共享账号不能访问内网",
+		"deny user=guest cidr=10.0.0.0/8,192.168.0.0/16",
+		"deny domain=internal.corp",
+		"allow user=guest port=80,443 cmd=connect",
+		"allow user=admin",
+	}, Deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 50000}
+	tests := []struct {
+		user, command, address string
+		want                   bool
+	}{
+		{"guest", CommandConnect, "example.com:443", true},
+		{"guest", CommandConnect, "example.com:22", false},
+		{"guest", CommandAssociate, "example.com:443", false},
+		{"guest", CommandConnect, "10.1.2.3:443", false},
+		{"guest", CommandConnect, "192.168.1.1:80", false},
+		{"admin", CommandConnect, "10.1.2.3:22", true},
+		{"admin", CommandConnect, "db.internal.corp:5432", false},
+		{"admin", CommandConnect, "internal.corp:80", false},
+		{"admin", CommandConnect, "notinternal.corp:80", true},
+		{"", CommandHTTP, "example.com:80", false},
+	}
+	for _, tt := range tests {
+		r, err := NewRequest(tt.user, client, tt.command, tt.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := set.Allow(r); got != tt.want {
+			t.Errorf("Allow(%s %s %s) = %v, want %v", tt.user, tt.command, tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"permit user=a",
+		"allow user",
+		"allow port=70000",
+		"allow port=90-80",
+		"allow cidr=10.0.0.0/33",
+		"allow color=red",
+	} {
+		if _, err := ParseRule(line); err == nil {
+			t.Errorf("ParseRule(%q) expected error", line)
+		}
+	}
+}
+
+func TestRuleSetResolve(t *testing.T) {
+	set, err := ParseRuleSet([]string{"deny cidr=127.0.0.0/8,::1"}, Allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewRequest("", nil, CommandConnect, "localhost:22")
+	if set.Allow(r) {
+		t.Error("domain resolving into a denied cidr was allowed")
+	}
+	set.NoResolve = true
+	if !set.Allow(r) {
+		t.Error("domain was resolved with NoResolve")
+	}
+}
+
+func TestRuleSetResolved(t *testing.T) {
+	set, err := ParseRuleSet([]string{"allow cidr=127.0.0.0/8"}, Deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewRequest("", nil, CommandConnect, "localhost:22")
+	if !set.Allow(r) {
+		t.Fatal("domain resolving into an allowed cidr was denied")
+	}
+	if r.Resolved == nil || !r.Resolved.IsLoopback() || r.Resolved.To4() == nil {
+		t.Fatalf("resolved %v, want the checked 127.0.0.0/8 address", r.Resolved)
+	}
+	if got := r.DialAddress("localhost:22"); got != net.JoinHostPort(r.Resolved.String(), "22") {
+		t.Errorf("dial address %s", got)
+	}
+	if got := r.DialAddress("example.com:22"); got != "example.com:22" {
+		t.Errorf("unrelated address rewritten to %s", got)
+	}
+
+	ip, _ := NewRequest("", nil, CommandConnect, "127.0.0.1:22")
+	if !set.Allow(ip) || ip.Resolved != nil {
+		t.Errorf("ip target resolved to %v", ip.Resolved)
+	}
+}
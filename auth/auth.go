@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash 用于不存在的用户, 让其耗时与存在的用户一致, 避免通过时间差枚举用户名
+var dummyHash = []byte("$2a$10$dos.thZJDubhfar2XNaozeNVqxfj9w3lcHcZ83qOw1AUKfweu/u6O")
+
+// Equal 以常数时间比较两个字符串, 长度不同也不会提前返回
+func Equal(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// CheckHash 校验 htpasswd 格式的哈希, 支持 bcrypt ($2a$/$2b$/$2y$) 与 {SHA}
+func CheckHash(hash, password string) bool {
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return Equal(hash[len("{SHA}"):], base64.StdEncoding.EncodeToString(sum[:]))
+	default:
+		return false
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func checkMissing(password string) bool {
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	return false
+}
+
+// NewStatic 由 用户名 -> bcrypt 哈希 构造认证函数, 可直接赋值给 SOCKSConf.Auth 与 Handler.Auth
+func NewStatic(users map[string]string) (func(username, password string) bool, error) {
+	hashes := make(map[string]string, len(users))
+	for user, hash := range users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, err
+		}
+		hashes[user] = hash
+	}
+	return func(username, password string) bool {
+		hash, ok := hashes[username]
+		if !ok {
+			return checkMissing(password)
+		}
+		return CheckHash(hash, password)
+	}, nil
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func shaHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestHtpasswdReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "htpasswd")
+
+	content := "alice:" + bcryptHash(t, "wonderland") + "\n# comment\nbob:" + shaHash("builder") + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ReloadInterval = 0
+
+	if !h.Check("alice", "wonderland") || !h.Check("bob", "builder") {
+		t.Fatal("valid credentials rejected")
+	}
+	if h.Check("alice", "builder") || h.Check("carol", "wonderland") {
+		t.Fatal("invalid credentials accepted")
+	}
+
+	content = "carol:" + bcryptHash(t, "secret") + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if !h.Check("carol", "secret") {
+		t.Error("reloaded user rejected")
+	}
+	if h.Check("alice", "wonderland") {
+		t.Error("removed user still accepted")
+	}
+}
+
+func TestNewStatic(t *testing.T) {
+	check, err := NewStatic(map[string]string{"alice": bcryptHash(t, "wonderland")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check("alice", "wonderland") || check("alice", "x") || check("bob", "wonderland") {
+		t.Error("unexpected static auth result")
+	}
+	if _, err := NewStatic(map[string]string{"alice": "plaintext"}); err == nil {
+		t.Error("expected error for non-bcrypt hash")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(3, time.Minute, 50*time.Millisecond)
+	attacker := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1000}
+	other := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 1000}
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(attacker) {
+			t.Fatalf("blocked after %d failures", i)
+		}
+		limiter.Fail(attacker)
+	}
+	if limiter.Allow(&net.TCPAddr{IP: attacker.IP, Port: 2000}) {
+		t.Error("attacker not blocked")
+	}
+	if !limiter.Allow(other) {
+		t.Error("other client blocked")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !limiter.Allow(attacker) {
+		t.Error("attacker still blocked after block duration")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !Equal("secret", "secret") || Equal("secret", "secreT") || Equal("secret", "secret1") {
+		t.Error("unexpected Equal result")
+	}
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	limiter := NewLimiter(3, 20*time.Millisecond, 20*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		limiter.Fail(&net.TCPAddr{IP: net.IPv4(198, 51, 100, byte(i)), Port: 1000})
+	}
+	if n := len(limiter.clients); n != 100 {
+		t.Fatalf("%d records, want 100", n)
+	}
+	// 过期记录在 Window 之后的第一次失败时清理
+	time.Sleep(50 * time.Millisecond)
+	limiter.Fail(&net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1000})
+	if n := len(limiter.clients); n != 1 {
+		t.Errorf("%d records after cleanup, want 1", n)
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval 为两次检查 htpasswd 文件是否变化的最小间隔
+var DefaultReloadInterval = time.Second
+
+// Htpasswd 从 htpasswd 文件加载用户, 文件修改后自动重新加载。
+// 支持 bcrypt (htpasswd -B) 与 SHA (htpasswd -s) 两种格式。
+type Htpasswd struct {
+	Path           string
+	ReloadInterval time.Duration
+
+	mu        sync.RWMutex
+	users     map[string]string
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{
+		Path:           path,
+		ReloadInterval: DefaultReloadInterval,
+	}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Check 校验用户名密码, 可直接赋值给 SOCKSConf.Auth 与 Handler.Auth
+func (h *Htpasswd) Check(username, password string) bool {
+	h.reloadIfChanged()
+	h.mu.RLock()
+	hash, ok := h.users[username]
+	h.mu.RUnlock()
+	if !ok {
+		return checkMissing(password)
+	}
+	return CheckHash(hash, password)
+}
+
+// Reload 立即重新读取文件, 读取失败时保留旧的用户列表
+func (h *Htpasswd) Reload() error {
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(h.Path)
+	if err != nil {
+		return err
+	}
+	users := parseHtpasswd(content)
+	h.mu.Lock()
+	h.users = users
+	h.modTime = info.ModTime()
+	h.size = info.Size()
+	h.lastCheck = time.Now()
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Htpasswd) reloadIfChanged() {
+	h.mu.Lock()
+	if time.Since(h.lastCheck) < h.ReloadInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.lastCheck = time.Now()
+	modTime, size := h.modTime, h.size
+	h.mu.Unlock()
+
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return
+	}
+	if info.ModTime().Equal(modTime) && info.Size() == size {
+		return
+	}
+	_ = h.Reload()
+}
+
+func parseHtpasswd(content []byte) map[string]string {
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		users[parts[0]] = parts[1]
+	}
+	return users
+}
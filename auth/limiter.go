@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter 按客户端 IP 统计认证失败次数, 在 Window 内失败 MaxFailures 次后封禁 Block 时长
+type Limiter struct {
+	MaxFailures int
+	Window      time.Duration
+	Block       time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*failureRecord
+	// lastCleanup 为上次清理过期记录的时间, 每个 Window 最多清理一次
+	lastCleanup time.Time
+}
+
+type failureRecord struct {
+	count        int
+	firstFailure time.Time
+	blockedUntil time.Time
+}
+
+func NewLimiter(maxFailures int, window, block time.Duration) *Limiter {
+	return &Limiter{
+		MaxFailures: maxFailures,
+		Window:      window,
+		Block:       block,
+		clients:     make(map[string]*failureRecord),
+	}
+}
+
+// Allow 返回该客户端当前是否允许尝试认证
+func (l *Limiter) Allow(addr net.Addr) bool {
+	key := clientKey(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record, ok := l.clients[key]
+	if !ok {
+		return true
+	}
+	return time.Now().After(record.blockedUntil)
+}
+
+// Fail 记录一次认证失败
+func (l *Limiter) Fail(addr net.Addr) {
+	key := clientKey(addr)
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients == nil {
+		l.clients = make(map[string]*failureRecord)
+	}
+	if now.Sub(l.lastCleanup) >= l.Window {
+		l.cleanup(now)
+		l.lastCleanup = now
+	}
+	record, ok := l.clients[key]
+	if !ok || now.Sub(record.firstFailure) > l.Window {
+		record = &failureRecord{firstFailure: now}
+		l.clients[key] = record
+	}
+	record.count++
+	if record.count >= l.MaxFailures {
+		record.blockedUntil = now.Add(l.Block)
+		record.count = 0
+		record.firstFailure = now
+	}
+}
+
+// Success 认证成功后清除该客户端的失败记录
+func (l *Limiter) Success(addr net.Addr) {
+	key := clientKey(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, key)
+}
+
+// cleanup 删除已过期的记录, 需要遍历全部记录, 由 Fail 按 Window 间隔调用
+func (l *Limiter) cleanup(now time.Time) {
+	for key, record := range l.clients {
+		if now.After(record.blockedUntil) && now.Sub(record.firstFailure) > l.Window {
+			delete(l.clients, key)
+		}
+	}
+}
+
+func clientKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,346 @@
+package chisel
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+	"github.com/chainreactors/proxyclient/ws"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ProtocolVersion 为 chisel 的 WebSocket 子协议, 也用于 SSH 版本号
+	ProtocolVersion = "chisel-v3"
+	// channelType 为每个目标连接打开的 SSH channel 类型, ExtraData 为目标地址
+	channelType = "chisel"
+
+	// addrNetwork 为 LocalAddr/RemoteAddr 的 Network()
+	addrNetwork = "chisel"
+)
+
+var (
+	DefaultTimeout        = 10 * time.Second
+	DefaultKeepAlive      = 25 * time.Second
+	DefaultReadBufferSize = 32 * 1024
+	DefaultWriteQueueSize = 8
+)
+
+// ChiselClient 实现了Client接口, 所有 Dial 共享同一个 SSH 连接, 每次 Dial 打开一个 channel。
+// SSH 连接断开后, 下一次 Dial 重新建立
+type ChiselClient struct {
+	Proxy *url.URL
+	Conf  *ChiselConf
+
+	mu   sync.Mutex
+	conn ssh.Conn
+}
+
+// ChiselConf 配置结构
+type ChiselConf struct {
+	// Dial 用于建立到 chisel 服务端的连接, 可以替换为上游代理
+	Dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	TLSConfig *tls.Config
+	// Server 为 ws:// 或 wss:// 形式的服务端地址
+	Server  string
+	Headers http.Header
+
+	User     string
+	Password string
+	// Fingerprint 为服务端公钥的 SHA256 指纹 (base64), 兼容旧版本的 MD5 指纹 (aa:bb:..., 可以只写前缀)。
+	// 为空时不校验
+	Fingerprint string
+
+	Timeout   time.Duration
+	KeepAlive time.Duration
+	tunnel.ConnOptions
+}
+
+// NewConfFromURL 从URL中解析参数生成配置:
+// fingerprint, hostname (Host 头), header (可重复, "Name: value"), keepalive, timeout, 以及 tls-* 参数
+func NewConfFromURL(proxyURL *url.URL) (*ChiselConf, error) {
+	scheme := "ws"
+	switch strings.ToLower(proxyURL.Scheme) {
+	case "chisel":
+		scheme = "ws"
+	case "chisels":
+		scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", proxyURL.Scheme)
+	}
+
+	query := proxyURL.Query()
+	tlsConfig, err := tunnel.TLSConfigFromQuery(proxyURL.Hostname(), query)
+	if err != nil {
+		return nil, err
+	}
+	conf := &ChiselConf{
+		Dial:        (&net.Dialer{}).DialContext,
+		TLSConfig:   tlsConfig,
+		Server:      fmt.Sprintf("%s://%s%s", scheme, proxyURL.Host, proxyURL.Path),
+		Fingerprint: query.Get("fingerprint"),
+		Timeout:     DefaultTimeout,
+		KeepAlive:   DefaultKeepAlive,
+		ConnOptions: tunnel.ConnOptions{
+			ReadBufferSize: DefaultReadBufferSize,
+			WriteQueueSize: DefaultWriteQueueSize,
+		},
+	}
+	if proxyURL.User != nil {
+		conf.User = proxyURL.User.Username()
+		conf.Password, _ = proxyURL.User.Password()
+	}
+
+	var opts tunnel.HTTPOptions
+	if err := tunnel.ParseHTTPOptions(&opts, query); err != nil {
+		return nil, err
+	}
+	conf.Headers = opts.Headers
+	if v := query.Get("hostname"); v != "" {
+		if conf.Headers == nil {
+			conf.Headers = make(http.Header)
+		}
+		conf.Headers.Set("Host", v)
+	}
+	if v := query.Get("keepalive"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keepalive: %s", v)
+		}
+		conf.KeepAlive = d
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", v)
+		}
+		conf.Timeout = d
+	}
+	return conf, nil
+}
+
+// Fingerprint 返回 chisel 使用的公钥指纹, 即公钥 SHA256 的 base64 编码
+func Fingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// legacyFingerprint 返回旧版本 chisel 使用的 MD5 指纹
+func legacyFingerprint(key ssh.PublicKey) string {
+	sum := md5.Sum(key.Marshal())
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func (conf *ChiselConf) verifyServer(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	expect := conf.Fingerprint
+	if expect == "" {
+		return nil
+	}
+	if _, err := base64.StdEncoding.DecodeString(expect); err != nil {
+		if got := legacyFingerprint(key); !strings.HasPrefix(got, expect) {
+			return fmt.Errorf("invalid fingerprint (%s)", got)
+		}
+		return nil
+	}
+	if got := Fingerprint(key); got != expect {
+		return fmt.Errorf("invalid fingerprint (%s)", got)
+	}
+	return nil
+}
+
+func (c *ChiselClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext 在共享的 SSH 连接上打开一个 channel, 由 chisel 服务端连接 address
+func (c *ChiselClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := c.sshConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, reqs, err := openChannel(ctx, conn, address)
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) || ctx.Err() != nil {
+			return nil, err
+		}
+		// SSH 连接已经断开, 重新建立后再试一次
+		c.reset(conn)
+		if conn, err = c.sshConn(ctx); err != nil {
+			return nil, err
+		}
+		if ch, reqs, err = openChannel(ctx, conn, address); err != nil {
+			return nil, err
+		}
+	}
+	go ssh.DiscardRequests(reqs)
+	session := &channelSession{ch: ch, buf: make([]byte, 32*1024)}
+	return tunnel.NewConn(session, &c.Conf.ConnOptions,
+		tunnel.NewAddr(addrNetwork, c.Conf.Server), tunnel.NewAddr(addrNetwork, address)), nil
+}
+
+// openChannel 打开 channel, ctx 取消时立即返回, 稍后打开的 channel 在后台关闭
+func openChannel(ctx context.Context, conn ssh.Conn, address string) (ssh.Channel, <-chan *ssh.Request, error) {
+	type result struct {
+		ch   ssh.Channel
+		reqs <-chan *ssh.Request
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ch, reqs, err := conn.OpenChannel(channelType, []byte(address))
+		done <- result{ch, reqs, err}
+	}()
+	select {
+	case r := <-done:
+		return r.ch, r.reqs, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.ch != nil {
+				r.ch.Close()
+			}
+		}()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// sshConn 返回已建立的 SSH 连接, 没有时经由 WebSocket 建立并发送配置
+func (c *ChiselClient) sshConn(ctx context.Context) (ssh.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	conf := c.Conf
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+	}
+	wsConn, err := ws.Dial(ctx, conf.Dial, conf.Server, conf.Headers, conf.TLSConfig, []string{ProtocolVersion})
+	if err != nil {
+		return nil, err
+	}
+	netConn := ws.NewConn(wsConn)
+	// SSH 握手不支持 ctx, 使用截止时间限制
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, "", &ssh.ClientConfig{
+		User:            conf.User,
+		Auth:            []ssh.AuthMethod{ssh.Password(conf.Password)},
+		ClientVersion:   "SSH-" + ProtocolVersion + "-client",
+		HostKeyCallback: conf.verifyServer,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		// 不接受服务端打开的 channel (反向转发)
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "reverse remotes are not supported")
+		}
+	}()
+
+	// 服务端在握手后等待配置, 不使用 remotes 时发送空配置即可
+	payload, _ := json.Marshal(map[string]interface{}{"Version": ProtocolVersion, "Remotes": []interface{}{}})
+	ok, reply, err := sshConn.SendRequest("config", true, payload)
+	if err == nil && !ok {
+		err = fmt.Errorf("chisel config rejected: %s", reply)
+	}
+	if err != nil {
+		sshConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	if conf.KeepAlive > 0 {
+		go c.keepAlive(sshConn)
+	}
+	c.conn = sshConn
+	return sshConn, nil
+}
+
+// keepAlive 定时发送 ping, 失败时关闭连接, 下一次 Dial 重新建立
+func (c *ChiselClient) keepAlive(conn ssh.Conn) {
+	ticker := time.NewTicker(c.Conf.KeepAlive)
+	defer ticker.Stop()
+	closed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(closed)
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			if _, _, err := conn.SendRequest("ping", true, nil); err != nil {
+				c.reset(conn)
+				return
+			}
+		case <-closed:
+			c.reset(conn)
+			return
+		}
+	}
+}
+
+// reset 关闭 conn, 如果它仍是当前连接则清除
+func (c *ChiselClient) reset(conn ssh.Conn) {
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+	conn.Close()
+}
+
+// Close 关闭 SSH 连接, 已打开的连接随之关闭
+func (c *ChiselClient) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// channelSession 把 SSH channel 适配为 tunnel.Session
+type channelSession struct {
+	ch  ssh.Channel
+	buf []byte
+}
+
+func (s *channelSession) Read(ctx context.Context) ([]byte, error) {
+	n, err := s.ch.Read(s.buf)
+	return s.buf[:n], err
+}
+
+func (s *channelSession) Write(ctx context.Context, data []byte) error {
+	_, err := s.ch.Write(data)
+	return err
+}
+
+func (s *channelSession) Close() error {
+	return s.ch.Close()
+}
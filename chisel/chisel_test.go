@@ -0,0 +1,318 @@
+package chisel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/chainreactors/proxyclient/ws"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// 以下握手数据记录自 github.com/jpillora/chisel v1.10.1 (share.ProtocolVersion 与 settings.EncodeConfig),
+// 本地转发 3000:127.0.0.1:22 与反向转发 R:2222:127.0.0.1:22 的 config 请求
+const (
+	recordedSubprotocol   = "chisel-v3"
+	recordedClientVersion = "SSH-chisel-v3-client"
+	recordedServerVersion = "SSH-chisel-v3-server"
+	recordedChannelType   = "chisel"
+	recordedConfig        = `{"Version":"0.0.0-src","Remotes":[{"LocalHost":"0.0.0.0","LocalPort":"3000","LocalProto":"tcp","RemoteHost":"127.0.0.1","RemotePort":"22","RemoteProto":"tcp","Socks":false,"Reverse":false,"Stdio":false}]}`
+	recordedReverseConfig = `{"Version":"0.0.0-src","Remotes":[{"LocalHost":"0.0.0.0","LocalPort":"2222","LocalProto":"tcp","RemoteHost":"127.0.0.1","RemotePort":"22","RemoteProto":"tcp","Socks":false,"Reverse":true,"Stdio":false}]}`
+)
+
+// newTestServer 启动 Go 实现的 chisel 服务端和一个 TCP echo 服务, 返回服务端、其 URL 与 echo 地址
+func newTestServer(t *testing.T, tls bool) (*Server, *httptest.Server, string) {
+	echo := testutil.Echo(t)
+
+	server, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Users = map[string]string{"user": "pass"}
+	var hs *httptest.Server
+	if tls {
+		hs = httptest.NewTLSServer(server)
+	} else {
+		hs = httptest.NewServer(server)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		hs.Close()
+	})
+	return server, hs, echo
+}
+
+func newTestClient(t *testing.T, rawURL string) *ChiselClient {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &ChiselClient{Proxy: proxyURL, Conf: conf}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func testEcho(t *testing.T, conn net.Conn, payload []byte) {
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("echoed data mismatch")
+	}
+}
+
+func TestChiselClientDial(t *testing.T) {
+	server, hs, target := newTestServer(t, false)
+	host := strings.TrimPrefix(hs.URL, "http://")
+	client := newTestClient(t, "chisel://user:pass@"+host+"/?fingerprint="+url.QueryEscape(server.Fingerprint()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := client.Dial("tcp", target)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			testEcho(t, conn, bytes.Repeat([]byte{byte(i)}, 128*1024))
+		}(i)
+	}
+	wg.Wait()
+
+	// 所有连接共享同一个 SSH 连接
+	server.mu.Lock()
+	n := len(server.conns)
+	server.mu.Unlock()
+	if n != 1 {
+		t.Errorf("server has %d ssh connections, want 1", n)
+	}
+
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("Dial to closed port succeeded")
+	}
+}
+
+func TestChiselAuth(t *testing.T) {
+	server, hs, target := newTestServer(t, false)
+	host := strings.TrimPrefix(hs.URL, "http://")
+	other, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, raw := range []string{
+		"chisel://user:wrong@" + host,
+		"chisel://user:pass@" + host + "/?fingerprint=" + url.QueryEscape(other.Fingerprint()),
+		"chisel://user:pass@" + host + "/?fingerprint=00:11",
+	} {
+		client := newTestClient(t, raw)
+		if conn, err := client.Dial("tcp", target); err == nil {
+			conn.Close()
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+
+	// 兼容旧版本的 MD5 指纹前缀
+	client := newTestClient(t, "chisel://user:pass@"+host+"/?fingerprint="+legacyFingerprint(server.key)[:11])
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestChiselReconnect(t *testing.T) {
+	server, hs, target := newTestServer(t, false)
+	client := newTestClient(t, "chisel://user:pass@"+strings.TrimPrefix(hs.URL, "http://"))
+	var dials int32
+	upstream := client.Conf.Dial
+	client.Conf.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return upstream(ctx, network, address)
+	}
+
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, conn, []byte("first"))
+
+	// 服务端断开后, 已有连接结束, 新的 Dial 重新建立 SSH 连接
+	server.Close()
+	io.ReadAll(conn)
+	conn.Close()
+
+	conn, err = client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testEcho(t, conn, []byte("second"))
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Errorf("upstream dialed %d times, want 2", n)
+	}
+}
+
+func TestChiselTLS(t *testing.T) {
+	server, hs, target := newTestServer(t, true)
+	client := newTestClient(t, "chisels://user:pass@"+strings.TrimPrefix(hs.URL, "https://")+
+		"/?tls-insecure-skip-verify=true&fingerprint="+url.QueryEscape(server.Fingerprint()))
+
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.LocalAddr(); addr.Network() != "chisel" || !strings.HasPrefix(addr.String(), "wss://") {
+		t.Errorf("LocalAddr = %s %s", addr.Network(), addr)
+	}
+	testEcho(t, conn, []byte("over tls"))
+}
+
+// TestChiselServerRecorded 按 chisel client 记录的握手数据连接服务端
+func TestChiselServerRecorded(t *testing.T) {
+	_, hs, target := newTestServer(t, false)
+	connect := func(config string) (ssh.Conn, bool, []byte) {
+		wsConn, err := ws.Dial(context.Background(), (&net.Dialer{}).DialContext,
+			"ws"+strings.TrimPrefix(hs.URL, "http"), nil, nil, []string{recordedSubprotocol})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sshConn, chans, reqs, err := ssh.NewClientConn(ws.NewConn(wsConn), "", &ssh.ClientConfig{
+			User:            "user",
+			Auth:            []ssh.AuthMethod{ssh.Password("pass")},
+			ClientVersion:   recordedClientVersion,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for ch := range chans {
+				ch.Reject(ssh.Prohibited, "")
+			}
+		}()
+		if v := string(sshConn.ServerVersion()); v != recordedServerVersion {
+			t.Errorf("server version = %s, want %s", v, recordedServerVersion)
+		}
+		ok, reply, err := sshConn.SendRequest("config", true, []byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sshConn, ok, reply
+	}
+
+	sshConn, ok, reply := connect(recordedConfig)
+	defer sshConn.Close()
+	if !ok {
+		t.Fatalf("config rejected: %s", reply)
+	}
+	ch, reqs, err := sshConn.OpenChannel(recordedChannelType, []byte(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(reqs)
+	payload := []byte("recorded")
+	go ch.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(ch, received); err != nil || !bytes.Equal(received, payload) {
+		t.Fatalf("echo = %q, %v", received, err)
+	}
+	ch.Close()
+
+	reverse, ok, _ := connect(recordedReverseConfig)
+	defer reverse.Close()
+	if ok {
+		t.Error("reverse remote accepted")
+	}
+}
+
+// TestChiselClientRecorded 检查客户端发出的握手与 chisel server 期望的数据一致
+func TestChiselClientRecorded(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	config := &ssh.ServerConfig{
+		ServerVersion: recordedServerVersion,
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	type record struct {
+		subprotocol, version, request, channel, address string
+		payload                                         []byte
+	}
+	records := make(chan record, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{recordedSubprotocol}}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		sshConn, chans, reqs, err := ssh.NewServerConn(ws.NewConn(wsConn), config)
+		if err != nil {
+			return
+		}
+		defer sshConn.Close()
+		rec := record{subprotocol: wsConn.Subprotocol(), version: string(sshConn.ClientVersion())}
+		req := <-reqs
+		rec.request, rec.payload = req.Type, req.Payload
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+		newCh := <-chans
+		rec.channel, rec.address = newCh.ChannelType(), string(newCh.ExtraData())
+		newCh.Reject(ssh.ConnectionFailed, "recorded")
+		records <- rec
+	}))
+	defer hs.Close()
+
+	client := newTestClient(t, "chisel://user:pass@"+strings.TrimPrefix(hs.URL, "http://"))
+	if conn, err := client.Dial("tcp", "127.0.0.1:22"); err == nil {
+		conn.Close()
+	}
+	rec := <-records
+	if rec.subprotocol != recordedSubprotocol || rec.version != recordedClientVersion {
+		t.Errorf("subprotocol = %s, version = %s", rec.subprotocol, rec.version)
+	}
+	if rec.channel != recordedChannelType || rec.address != "127.0.0.1:22" {
+		t.Errorf("channel = %s %s", rec.channel, rec.address)
+	}
+	// chisel server 用 settings.DecodeConfig 解析 config, 只要求是同样结构的 JSON
+	var recorded, sent map[string]interface{}
+	json.Unmarshal([]byte(recordedConfig), &recorded)
+	if rec.request != "config" || json.Unmarshal(rec.payload, &sent) != nil {
+		t.Fatalf("config request = %s %s", rec.request, rec.payload)
+	}
+	for key := range sent {
+		if _, ok := recorded[key]; !ok {
+			t.Errorf("unexpected config field %s", key)
+		}
+	}
+	if _, ok := sent["Remotes"].([]interface{}); !ok {
+		t.Errorf("config Remotes = %v", sent["Remotes"])
+	}
+}
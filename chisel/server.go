@@ -0,0 +1,211 @@
+package chisel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/relay"
+	"github.com/chainreactors/proxyclient/ws"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultDialTimeout 为服务端连接目标的超时时间
+var DefaultDialTimeout = 5 * time.Second
+
+var errDeadline = errors.New("chisel: channel does not support deadlines")
+
+// Server 为 Go 实现的 chisel 服务端, 与 chisel server 使用相同的握手流程:
+// 升级为子协议 chisel-v3 的 WebSocket, 在其上进行 SSH 握手与密码认证, 收到 config 请求后
+// 为每个 chisel channel 连接 ExtraData 中的目标地址。
+// 只支持正向 TCP 转发, 不支持 socks、UDP 与反向转发; 其他请求交给 NotFound 处理。
+type Server struct {
+	// Users 为用户名与密码, 为空时不认证
+	Users map[string]string
+	Dial  func(ctx context.Context, network, address string) (net.Conn, error)
+
+	NotFound http.Handler
+
+	config   *ssh.ServerConfig
+	key      ssh.PublicKey
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[ssh.Conn]struct{}
+}
+
+// NewServer 使用随机生成的 ed25519 主机密钥创建服务端
+func NewServer() (*Server, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Dial:     (&net.Dialer{Timeout: DefaultDialTimeout}).DialContext,
+		NotFound: http.NotFoundHandler(),
+		key:      signer.PublicKey(),
+		upgrader: websocket.Upgrader{Subprotocols: []string{ProtocolVersion}},
+		conns:    make(map[ssh.Conn]struct{}),
+	}
+	s.config = &ssh.ServerConfig{
+		ServerVersion:    "SSH-" + ProtocolVersion + "-server",
+		PasswordCallback: s.authUser,
+	}
+	s.config.AddHostKey(signer)
+	return s, nil
+}
+
+// Fingerprint 返回主机密钥的指纹, 客户端通过 fingerprint 参数校验
+func (s *Server) Fingerprint() string {
+	return Fingerprint(s.key)
+}
+
+func (s *Server) authUser(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if len(s.Users) == 0 {
+		return nil, nil
+	}
+	if expect, ok := s.Users[meta.User()]; ok && expect == string(password) {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid authentication for username: %s", meta.User())
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		r.Header.Get("Sec-WebSocket-Protocol") != ProtocolVersion {
+		s.NotFound.ServeHTTP(w, r)
+		return
+	}
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.serve(ws.NewConn(wsConn))
+}
+
+func (s *Server) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(DefaultTimeout))
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+
+	// 客户端在握手后发送配置
+	var r *ssh.Request
+	select {
+	case r = <-reqs:
+	case <-time.After(DefaultTimeout):
+		return
+	}
+	if r == nil {
+		return
+	}
+	// chisel client 会在 Remotes 中带上本地转发的配置, 只拒绝反向转发
+	var config struct {
+		Version string
+		Remotes []struct {
+			Reverse bool
+		}
+	}
+	if r.Type != "config" {
+		r.Reply(false, []byte("expecting config request"))
+		return
+	}
+	if err := json.Unmarshal(r.Payload, &config); err != nil {
+		r.Reply(false, []byte("invalid config"))
+		return
+	}
+	for _, remote := range config.Remotes {
+		if remote.Reverse {
+			r.Reply(false, []byte("reverse remotes are not supported"))
+			return
+		}
+	}
+	r.Reply(true, nil)
+	conn.SetDeadline(time.Time{})
+
+	s.mu.Lock()
+	s.conns[sshConn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, sshConn)
+		s.mu.Unlock()
+	}()
+
+	go func() {
+		for req := range reqs {
+			if req.WantReply {
+				req.Reply(req.Type == "ping", nil)
+			}
+		}
+	}()
+	for ch := range chans {
+		go s.handleChannel(sshConn, ch)
+	}
+}
+
+func (s *Server) handleChannel(conn ssh.Conn, newCh ssh.NewChannel) {
+	if newCh.ChannelType() != channelType {
+		newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+		return
+	}
+	address := string(newCh.ExtraData())
+	if address == "socks" || strings.HasSuffix(address, "/udp") {
+		newCh.Reject(ssh.Prohibited, "only tcp remotes are supported")
+		return
+	}
+	address = strings.TrimSuffix(address, "/tcp")
+
+	target, err := s.Dial(context.Background(), "tcp", address)
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	relay.Relay(&channelConn{Channel: ch, conn: conn}, target, 0)
+}
+
+// channelConn 把 SSH channel 适配为 net.Conn, 供 relay.Relay 使用, 不支持截止时间
+type channelConn struct {
+	ssh.Channel
+	conn ssh.Conn
+}
+
+func (c *channelConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *channelConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *channelConn) SetDeadline(t time.Time) error      { return errDeadline }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return errDeadline }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return errDeadline }
+
+// Close 关闭所有客户端连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return nil
+}
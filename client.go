@@ -23,9 +23,14 @@ func init() {
 	RegisterScheme("SOCKS4", newSocksProxyClient)
 	RegisterScheme("SOCKS4A", newSocksProxyClient)
 	RegisterScheme("SOCKS5", newSocksProxyClient)
-	RegisterScheme("SOCKS5+TLS", newSocksProxyClient)
 	RegisterScheme("HTTP", newHTTPProxyClient)
 	RegisterScheme("HTTPS", newHTTPProxyClient)
+	RegisterScheme("SS", newShadowsocksProxyClient)
+	RegisterScheme("TROJAN", newTrojanProxyClient)
+	RegisterScheme("VLESS", newVLESSProxyClient)
+	RegisterScheme("VMESS", newVMessProxyClient)
+	RegisterScheme("WS", newWebSocketProxyClient)
+	RegisterScheme("WSS", newWebSocketProxyClient)
 }
 
 func NewClient(proxy *url.URL) (Dial, error) {
@@ -46,9 +51,13 @@ func NewClientWithDial(proxy *url.URL, upstreamDial Dial) (_ Dial, err error) {
 		return
 	}
 	proxy = normalizeLink(*proxy)
-	var scheme string
-	ss := strings.Split(proxy.Scheme, "+")
-	scheme = ss[0]
+	if _, ok := schemes[proxy.Scheme]; !ok {
+		proxy, upstreamDial, err = wrapTransports(proxy, upstreamDial)
+		if err != nil {
+			return
+		}
+	}
+	scheme := proxy.Scheme
 	if _, ok := schemes[scheme]; !ok {
 		err = errors.New("unsupported proxy client.")
 		return
@@ -0,0 +1,36 @@
+//go:build chisel
+// +build chisel
+
+package extend
+
+import (
+	"context"
+	"github.com/chainreactors/proxyclient"
+	"github.com/chainreactors/proxyclient/chisel"
+	"net"
+	"net/url"
+)
+
+func init() {
+	proxyclient.RegisterScheme("CHISEL", NewChiselClient)
+	proxyclient.RegisterScheme("CHISELS", NewChiselClient)
+}
+
+// NewChiselClient 创建 chisel 客户端, 同一个 URL 的所有连接共享一个 SSH 连接
+func NewChiselClient(proxy *url.URL, upstreamDial proxyclient.Dial) (dial proxyclient.Dial, err error) {
+	conf, err := chisel.NewConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if upstreamDial != nil {
+		conf.Dial = upstreamDial
+	}
+	client := &chisel.ChiselClient{
+		Proxy: proxy,
+		Conf:  conf,
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return client.DialContext(ctx, network, address)
+	}, nil
+}
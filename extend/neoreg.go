@@ -30,6 +30,6 @@ func NewNeoregClient(proxy *url.URL, upstreamDial proxyclient.Dial) (dial proxyc
 	}
 
 	return func(ctx context.Context, network, address string) (net.Conn, error) {
-		return client.Dial(network, address)
+		return client.DialContext(ctx, network, address)
 	}, nil
 }
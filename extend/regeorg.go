@@ -0,0 +1,36 @@
+//go:build regeorg
+// +build regeorg
+
+package extend
+
+import (
+	"context"
+	"github.com/chainreactors/proxyclient"
+	"github.com/chainreactors/proxyclient/regeorg"
+	"net"
+	"net/url"
+)
+
+func init() {
+	proxyclient.RegisterScheme("REGEORG", NewRegeorgClient)
+	proxyclient.RegisterScheme("REGEORGS", NewRegeorgClient)
+}
+
+// NewRegeorgClient 创建经典 reGeorg 协议的客户端
+func NewRegeorgClient(proxy *url.URL, upstreamDial proxyclient.Dial) (dial proxyclient.Dial, err error) {
+	conf, err := regeorg.NewConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if upstreamDial != nil {
+		conf.Dial = upstreamDial
+	}
+	client := &regeorg.RegeorgClient{
+		Proxy: proxy,
+		Conf:  conf,
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return client.DialContext(ctx, network, address)
+	}, nil
+}
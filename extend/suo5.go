@@ -23,7 +23,7 @@ func NewSuo5Client(proxy *url.URL, upstreamDial proxyclient.Dial) (dial proxycli
 		return nil, err
 	}
 	if upstreamDial != nil {
-		conf.ProxyClient = upstreamDial
+		conf.Dial = upstreamDial
 	}
 	c := &suo5.Suo5Client{
 		Proxy: proxy,
@@ -31,6 +31,6 @@ func NewSuo5Client(proxy *url.URL, upstreamDial proxyclient.Dial) (dial proxycli
 	}
 
 	return func(ctx context.Context, network, address string) (net.Conn, error) {
-		return c.Dial(network, address)
+		return c.DialContext(ctx, network, address)
 	}, nil
 }
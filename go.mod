@@ -1,12 +1,52 @@
 module github.com/chainreactors/proxyclient
 
-go 1.10
+go 1.21
 
 require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/refraction-networking/utls v1.6.4
 	github.com/shadowsocks/go-shadowsocks2 v0.1.5
 	github.com/things-go/go-socks5 v0.0.5
 	github.com/zema1/suo5 v1.3.2
 	golang.org/x/crypto v0.33.0
 )
 
+require (
+	github.com/BurntSushi/toml v1.3.2 // indirect
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/bwesterb/go-ristretto v1.2.3 // indirect
+	github.com/chainreactors/files v0.0.0-20231102192550-a652458cee26 // indirect
+	github.com/chainreactors/logs v0.0.0-20241115105204-6132e39f5261 // indirect
+	github.com/cloudflare/circl v1.3.8 // indirect
+	github.com/cpuguy83/go-md2man/v2 v2.0.4 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-gost/gosocks5 v0.3.0 // indirect
+	github.com/gobwas/glob v0.2.3 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/kataras/golog v0.1.8 // indirect
+	github.com/kataras/pio v0.0.11 // indirect
+	github.com/klauspost/compress v1.17.8 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/riobard/go-bloom v0.0.0-20200614022211-cdc8013cb5b3 // indirect
+	github.com/russross/blackfriday/v2 v2.1.0 // indirect
+	github.com/stretchr/objx v0.5.2 // indirect
+	github.com/stretchr/testify v1.10.0 // indirect
+	github.com/urfave/cli/v2 v2.27.4 // indirect
+	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
+	github.com/yuin/goldmark v1.4.13 // indirect
+	github.com/zema1/rawhttp v0.2.0 // indirect
+	golang.org/x/mod v0.17.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/telemetry v0.0.0-20240228155512-f48c80bd79b2 // indirect
+	golang.org/x/term v0.29.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	golang.org/x/xerrors v0.0.0-20190717185122-a985d3407aa7 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
 replace github.com/zema1/suo5 => github.com/M09Ic/suo5 v1.3.4
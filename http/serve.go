@@ -1,10 +1,17 @@
 package httpproxy
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
 	"net/http"
+	"time"
+
+	"github.com/chainreactors/proxyclient/acl"
+	"github.com/chainreactors/proxyclient/auth"
+	"github.com/chainreactors/proxyclient/proxyproto"
+	"github.com/chainreactors/proxyclient/relay"
 )
 
 var (
@@ -12,40 +19,80 @@ var (
 		StatusCode: 200,
 		Status:     "Connection Established",
 	}
-	responseUnauthorized = http.Response{
-		StatusCode: http.StatusUnauthorized,
-		Status:     http.StatusText(http.StatusUnauthorized),
-		Header:     http.Header{authenticate: []string{"Basic"}},
-	}
+
+	errProxyProtocolNotApplied = errors.New("ProxyProtocol requires ServeHandler")
 )
 
+// proxyProtocolKey 标记连接已由 ServeHandler 解析过 PROXY 协议头
+type proxyProtocolKey struct{}
+
 type Handler struct {
 	Auth        func(username, password string) bool
 	Dial        func(network, address string) (net.Conn, error)
 	HandleError func(error, *http.Request)
-	client      *http.Client
+	IdleTimeout time.Duration
+	Policy      acl.Policy
+	// Route 按认证用户与客户端地址选择上游, 返回 nil 时使用 Dial
+	Route func(*acl.Request) func(ctx context.Context, network, address string) (net.Conn, error)
+	// Limiter 按客户端 IP 限制认证失败频率
+	Limiter *auth.Limiter
+	// ProxyProtocol 为 true 时 ServeHandler 要求每个连接以 PROXY 协议头 (v1/v2) 开始。
+	// 只有 ServeHandler 会解析该协议头, 经由 http.Serve 等其他方式提供服务时所有请求都会被拒绝,
+	// 以免 ACL 使用负载均衡的地址
+	ProxyProtocol bool
+	client        *http.Client
 }
 
 func Serve(listener net.Listener, dial func(network, address string) (net.Conn, error)) {
 	http.Serve(listener, Handler{Dial: dial})
 }
 
+// ServeHandler 使用完整配置的 Handler 提供服务
+func ServeHandler(listener net.Listener, handler Handler) error {
+	if !handler.ProxyProtocol {
+		return http.Serve(listener, handler)
+	}
+	server := &http.Server{
+		Handler: handler,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, proxyProtocolKey{}, true)
+		},
+	}
+	return server.Serve(proxyproto.NewListener(listener))
+}
+
 func (h Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	if h.basicAuth(writer, request) {
+	if h.ProxyProtocol && request.Context().Value(proxyProtocolKey{}) == nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		if h.HandleError != nil {
+			go h.HandleError(errProxyProtocolNotApplied, request)
+		}
+		return
+	}
+	username, ok := h.basicAuth(writer, request)
+	if !ok {
+		return
+	}
+	r := h.aclRequest(username, request)
+	if !h.allow(r) {
+		writer.WriteHeader(http.StatusForbidden)
+		if h.HandleError != nil {
+			go h.HandleError(acl.ErrDenied, request)
+		}
 		return
 	}
 	var err error
 	if request.Method == http.MethodConnect {
-		err = h.handleConnect(writer, request)
+		err = h.handleConnect(writer, request, h.route(r))
 	} else {
-		err = h.handleNormal(writer, request)
+		err = h.handleNormal(writer, request, h.route(r))
 	}
 	if err != nil && h.HandleError != nil {
 		go h.HandleError(err, request)
 	}
 }
 
-func (h Handler) handleConnect(writer http.ResponseWriter, request *http.Request) error {
+func (h Handler) handleConnect(writer http.ResponseWriter, request *http.Request, dial dialFunc) error {
 	hijacker, ok := writer.(http.Hijacker)
 	if !ok {
 		return errors.New("can't cast to Hijacker")
@@ -60,17 +107,16 @@ func (h Handler) handleConnect(writer http.ResponseWriter, request *http.Request
 	if err := buffer.Flush(); err != nil {
 		return err
 	}
-	remoteConn, err := h.Dial("tcp", urlToRemoteAddress(request.URL))
+	remoteConn, err := dial(request.Context(), "tcp", urlToRemoteAddress(request.URL))
 	if err != nil {
 		return err
 	}
-	go io.Copy(localConn, remoteConn)
-	go io.Copy(remoteConn, localConn)
-	return nil
+	_, _, err = relay.Relay(localConn, remoteConn, h.IdleTimeout)
+	return err
 }
 
-func (h Handler) handleNormal(writer http.ResponseWriter, request *http.Request) error {
-	response, err := h.request(request)
+func (h Handler) handleNormal(writer http.ResponseWriter, request *http.Request, dial dialFunc) error {
+	response, err := h.request(request, dial)
 	if err != nil {
 		return err
 	}
@@ -85,26 +131,88 @@ func (h Handler) handleNormal(writer http.ResponseWriter, request *http.Request)
 	return nil
 }
 
-func (h Handler) request(request *http.Request) (*http.Response, error) {
-	if h.client == nil {
-		h.client = &http.Client{
-			Transport:     &http.Transport{Dial: h.Dial},
+func (h Handler) request(request *http.Request, dial dialFunc) (*http.Response, error) {
+	client := h.client
+	if client == nil {
+		client = &http.Client{
+			Transport:     &http.Transport{DialContext: dial},
 			CheckRedirect: func(_ *http.Request, _ []*http.Request) error { return nil },
 		}
+		if h.Route != nil {
+			// 不同用户可能走不同上游, 不能复用彼此的连接
+			client.Transport.(*http.Transport).DisableKeepAlives = true
+		}
 	}
 	request.RequestURI = ""
-	return h.client.Do(request)
+	return client.Do(request)
 }
 
-func (h Handler) basicAuth(writer http.ResponseWriter, request *http.Request) (ok bool) {
+// basicAuth 校验代理认证并返回用户名, 失败时已回复 407 或 429
+func (h Handler) basicAuth(writer http.ResponseWriter, request *http.Request) (username string, ok bool) {
 	if h.Auth == nil {
-		return
+		return "", true
 	}
-	auth := request.Header.Get(authorization)
-	if username, password, ok := decodeBasicAuth(auth); ok {
-		ok = h.Auth(username, password)
-	} else {
-		responseUnauthorized.Write(writer)
+	client := acl.ParseAddr(request.RemoteAddr)
+	if h.Limiter != nil && !h.Limiter.Allow(client) {
+		writer.WriteHeader(http.StatusTooManyRequests)
+		return "", false
+	}
+	username, password, decoded := decodeBasicAuth(request.Header.Get(authorization))
+	if decoded && h.Auth(username, password) {
+		if h.Limiter != nil {
+			h.Limiter.Success(client)
+		}
+		return username, true
+	}
+	if decoded && h.Limiter != nil {
+		h.Limiter.Fail(client)
+	}
+	writer.Header().Set(authenticate, "Basic")
+	writer.WriteHeader(http.StatusProxyAuthRequired)
+	return "", false
+}
+
+func (h Handler) aclRequest(username string, request *http.Request) *acl.Request {
+	command := acl.CommandHTTP
+	if request.Method == http.MethodConnect {
+		command = acl.CommandConnect
+	}
+	r, err := acl.NewRequest(username, acl.ParseAddr(request.RemoteAddr), command, urlToRemoteAddress(request.URL))
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+func (h Handler) allow(r *acl.Request) bool {
+	if h.Policy == nil {
+		return true
+	}
+	return r != nil && h.Policy(r)
+}
+
+type dialFunc = func(ctx context.Context, network, address string) (net.Conn, error)
+
+// route 返回本次请求使用的 dial, 调用时传入请求的 context, 客户端断开或请求结束时中断连接上游。
+// Policy 解析并检查过目标域名时拨号检查过的 IP, 请求中的 Host 保持不变
+func (h Handler) route(r *acl.Request) dialFunc {
+	var dial dialFunc
+	if h.Route != nil && r != nil {
+		dial = h.Route(r)
+	}
+	if dial == nil && h.Dial != nil {
+		dial = func(_ context.Context, network, address string) (net.Conn, error) {
+			return h.Dial(network, address)
+		}
+	}
+	if r == nil || r.Resolved == nil {
+		// dial 为 nil 时 http.Transport 使用默认的 dialer
+		return dial
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dial(ctx, network, r.DialAddress(address))
 	}
-	return
 }
@@ -24,7 +24,7 @@ func decodeBasicAuth(auth string) (username, password string, ok bool) {
 	}
 	auth = auth[len(prefix):]
 	if decoded, err := base64.StdEncoding.DecodeString(auth); err == nil {
-		splitted := strings.Split(string(decoded), ":")
+		splitted := strings.SplitN(string(decoded), ":", 2)
 		if len(splitted) == 2 {
 			return splitted[0], splitted[1], true
 		}
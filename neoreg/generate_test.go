@@ -0,0 +1,374 @@
+package neoreg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+)
+
+func TestGenerate(t *testing.T) {
+	key := "password"
+	scripts, err := Generate(key, &GenerateOptions{Page: "tomcat", StatusCode: 403})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != len(ScriptTypes) {
+		t.Fatalf("generated %d scripts, want %d", len(scripts), len(ScriptTypes))
+	}
+
+	// 字符集与偏移必须与 generateMaps 的推导顺序一致
+	rng := NewNeoregRand(key)
+	offset := rng.mt.GetRandBits(31).Int64()
+	chars := []rune(BASE64CHARS)
+	rng.Base64Chars(chars)
+
+	conf := NewConf(key)
+	page, _ := loadPage("tomcat")
+	pageRe := regexp.MustCompile(`neoDecode\("([^"]+)"\)|NeoDecode\("([^"]+)"\)|neo_decode\('([^']+)'\)`)
+	for name, script := range scripts {
+		if bytes.Contains(script, []byte("NEOREG_")) {
+			t.Errorf("%s: placeholder not replaced", name)
+		}
+		if !bytes.Contains(script, []byte(string(chars))) {
+			t.Errorf("%s: permuted charset missing", name)
+		}
+		if !bytes.Contains(script, []byte(strconv.FormatInt(offset, 10))) {
+			t.Errorf("%s: blv offset missing", name)
+		}
+		if !bytes.Contains(script, []byte("403")) {
+			t.Errorf("%s: status code missing", name)
+		}
+		match := pageRe.FindSubmatch(script)
+		if match == nil {
+			t.Errorf("%s: page not found", name)
+			continue
+		}
+		encoded := append(append(match[1], match[2]...), match[3]...)
+		if decoded, err := base64decode(encoded, conf.DecodeMap); err != nil || !bytes.Equal(decoded, page) {
+			t.Errorf("%s: page does not decode to template, %v", name, err)
+		}
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	if _, err := GenerateScript("password", "asp", nil); err == nil {
+		t.Error("unsupported script type accepted")
+	}
+	if _, err := GenerateScript("password", "php", &GenerateOptions{Page: "unknown"}); err == nil {
+		t.Error("unknown page template accepted")
+	}
+	if _, err := GenerateScript("password", "php", &GenerateOptions{Page: "<h1>gone</h1>"}); err != nil {
+		t.Error(err)
+	}
+	if len(PageTemplates()) == 0 {
+		t.Error("no builtin page templates")
+	}
+}
+
+func TestGenerateBodyWrap(t *testing.T) {
+	conf := NewConf("password")
+	opts := &GenerateOptions{BodyPrefix: "<!--", BodySuffix: "-->"}
+	scripts, err := Generate("password", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := base64encode([]byte(opts.BodyPrefix), conf.EncodeMap)
+	suffix := base64encode([]byte(opts.BodySuffix), conf.EncodeMap)
+	for name, script := range scripts {
+		if !bytes.Contains(script, prefix) || !bytes.Contains(script, suffix) {
+			t.Errorf("%s: body prefix/suffix missing", name)
+		}
+	}
+}
+
+// TestGeneratedPHP 用 php -S 运行生成的 tunnel.php, 本机没有 php 时跳过
+func TestGeneratedPHP(t *testing.T) {
+	php, err := exec.LookPath("php")
+	if err != nil {
+		t.Skip("php not found")
+	}
+	key := "password"
+	script, err := GenerateScript(key, "php", &GenerateOptions{BodyPrefix: "<!--", BodySuffix: "-->"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "tunnel.php"), script, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := freeAddr(t)
+	cmd := exec.Command(php, "-S", addr, "-t", dir)
+	cmd.Env = append(os.Environ(), "PHP_CLI_SERVER_WORKERS=4")
+	startScriptServer(t, cmd, addr)
+	testGeneratedScript(t, key, "neoreg://"+key+"@"+addr+"/tunnel.php?interval=5ms&body_prefix="+
+		url.QueryEscape("<!--")+"&body_suffix="+url.QueryEscape("-->"))
+}
+
+// TestGeneratedJava 把生成的 jsp 与 jspx 中的代码编译为类, 由 testdata/java 中基于
+// com.sun.net.httpserver 的 Host 与 servlet 接口桩运行, 本机没有 javac 时跳过
+func TestGeneratedJava(t *testing.T) {
+	javac, err := exec.LookPath("javac")
+	if err != nil {
+		t.Skip("javac not found")
+	}
+	java, err := exec.LookPath("java")
+	if err != nil {
+		t.Skip("java not found")
+	}
+	key := "password"
+	dir := t.TempDir()
+	copyDir(t, filepath.Join("testdata", "java"), dir)
+	for name, scriptType := range map[string]string{"TunnelJsp": "jsp", "TunnelJspx": "jspx"} {
+		script, err := GenerateScript(key, scriptType, &GenerateOptions{BodyPrefix: "<!--", BodySuffix: "-->"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := javaClass(name, scriptType, string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".java"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sources []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".java") {
+			sources = append(sources, path)
+		}
+		return err
+	})
+	out := filepath.Join(dir, "classes")
+	if output, err := exec.Command(javac, append([]string{"-nowarn", "-d", out}, sources...)...).CombinedOutput(); err != nil {
+		t.Fatalf("javac: %v\n%s", err, output)
+	}
+
+	addr := freeAddr(t)
+	_, port, _ := net.SplitHostPort(addr)
+	startScriptServer(t, exec.Command(java, "-cp", out, "Host", port), addr)
+	for _, path := range []string{"/tunnel.jsp", "/tunnel.jspx"} {
+		t.Run(strings.TrimPrefix(path, "/"), func(t *testing.T) {
+			testGeneratedScript(t, key, "neoreg://"+key+"@"+addr+path+"?interval=5ms&body_prefix="+
+				url.QueryEscape("<!--")+"&body_suffix="+url.QueryEscape("-->"))
+		})
+	}
+}
+
+// TestGeneratedCSharp 把生成的 ashx 与 aspx 中的代码与 testdata/dotnet 中的 HttpListener 宿主和
+// System.Web 桩一起编译运行, 本机没有 dotnet 时跳过
+func TestGeneratedCSharp(t *testing.T) {
+	dotnet, err := exec.LookPath("dotnet")
+	if err != nil {
+		t.Skip("dotnet not found")
+	}
+	version, err := exec.Command(dotnet, "--version").Output()
+	if err != nil {
+		t.Skip("no .NET SDK: ", err)
+	}
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimSpace(string(version)), ".", 2)[0])
+	if err != nil || major < 5 {
+		t.Skipf(".NET SDK %s not supported", bytes.TrimSpace(version))
+	}
+
+	key := "password"
+	dir := t.TempDir()
+	copyDir(t, filepath.Join("testdata", "dotnet"), dir)
+	for _, scriptType := range []string{"ashx", "aspx"} {
+		script, err := GenerateScript(key, scriptType, &GenerateOptions{BodyPrefix: "<!--", BodySuffix: "-->"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := csharpClass(scriptType, string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "Tunnel."+scriptType+".cs"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "out")
+	build := exec.Command(dotnet, "build", "-nologo", "-o", out, "-p:TargetFramework=net"+strconv.Itoa(major)+".0", dir)
+	build.Env = append(os.Environ(), "DOTNET_CLI_TELEMETRY_OPTOUT=1", "DOTNET_NOLOGO=1")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("dotnet build: %v\n%s", err, output)
+	}
+
+	addr := freeAddr(t)
+	_, port, _ := net.SplitHostPort(addr)
+	startScriptServer(t, exec.Command(dotnet, filepath.Join(out, "host.dll"), port), addr)
+	for _, path := range []string{"/tunnel.ashx", "/tunnel.aspx"} {
+		t.Run(strings.TrimPrefix(path, "/"), func(t *testing.T) {
+			testGeneratedScript(t, key, "neoreg://"+key+"@"+addr+path+"?interval=5ms&body_prefix="+
+				url.QueryEscape("<!--")+"&body_suffix="+url.QueryEscape("-->"))
+		})
+	}
+}
+
+// javaClass 取出生成的 jsp/jspx 中的声明与脚本片段, 组成一个可以由 Host 调用的类
+func javaClass(name, scriptType, script string) (string, error) {
+	var decl, scriptlet string
+	var ok bool
+	if scriptType == "jspx" {
+		decl, ok = between(script, "<jsp:declaration><![CDATA[", "]]></jsp:declaration>")
+		if ok {
+			scriptlet, ok = between(script, "<jsp:scriptlet><![CDATA[", "]]></jsp:scriptlet>")
+		}
+	} else {
+		// 声明在 page 指令之后的 <%! ... %> 中, 紧接着是 <% ... %>
+		if i := strings.Index(script, "<%!"); i >= 0 {
+			decl, ok = between(script[i:], "<%!", "%><%")
+			if ok {
+				scriptlet, ok = between(script[i+3+len(decl)+2:], "<%", "%>")
+			}
+		}
+	}
+	if !ok {
+		return "", fmt.Errorf("%s: code not found", scriptType)
+	}
+	var source strings.Builder
+	for _, pkg := range strings.Split(javaImports, ",") {
+		source.WriteString("import " + pkg + ";\n")
+	}
+	source.WriteString("import javax.servlet.*;\nimport javax.servlet.http.*;\n\npublic class " + name + " {\n" + decl +
+		"\npublic static void service(HttpServletRequest request, HttpServletResponse response, ServletContext application, Writer out) throws Exception {\n" +
+		scriptlet + "\n}\n}\n")
+	return source.String(), nil
+}
+
+// csharpClass 把生成的 ashx 去掉指令后直接使用, aspx 中的 script 与内联代码放入 AspxPage 类
+func csharpClass(scriptType, script string) (string, error) {
+	if scriptType == "ashx" {
+		i := strings.Index(script, "%>")
+		if i < 0 {
+			return "", fmt.Errorf("ashx: directive not found")
+		}
+		return script[i+2:], nil
+	}
+	members, ok := between(script, `<script runat="server">`, "</script>")
+	if !ok {
+		return "", fmt.Errorf("aspx: script block not found")
+	}
+	inline, ok := between(script[strings.Index(script, "</script>"):], "<%", "%>")
+	if !ok {
+		return "", fmt.Errorf("aspx: inline code not found")
+	}
+	var source strings.Builder
+	for _, ns := range strings.Split(csharpNamespaces, ",") {
+		source.WriteString("using " + ns + ";\n")
+	}
+	source.WriteString("\npublic class AspxPage\n{\n" + members + "\npublic void Render(HttpContext Context)\n{\n" + inline + "\n}\n}\n")
+	return source.String(), nil
+}
+
+func between(s, start, end string) (string, bool) {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return "", false
+	}
+	s = s[i+len(start):]
+	j := strings.Index(s, end)
+	if j < 0 {
+		return "", false
+	}
+	return s[:j], true
+}
+
+func copyDir(t *testing.T, src, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startScriptServer 启动 cmd 并等待 addr 可以连接, 测试结束时结束进程
+func startScriptServer(t *testing.T, cmd *exec.Cmd, addr string) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() && stderr.Len() > 0 {
+			t.Logf("%s stderr:\n%s", filepath.Base(cmd.Path), stderr.String())
+		}
+	})
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// testGeneratedScript 经由 rawURL 指向的脚本连接 TCP echo 服务并校验回显
+func testGeneratedScript(t *testing.T, key, rawURL string) {
+	echo := testutil.Echo(t)
+
+	proxyURL, _ := url.Parse(rawURL)
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &NeoregClient{Proxy: proxyURL, Conf: conf}
+	conn, err := client.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	payload := bytes.Repeat([]byte("restet"), 16*1024)
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil || !bytes.Equal(received, payload) {
+		t.Fatalf("echo mismatch, %v", err)
+	}
+
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("Dial to closed port succeeded")
+	}
+}
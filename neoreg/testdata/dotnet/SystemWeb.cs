@@ -0,0 +1,77 @@
+// 生成的 ashx/aspx 用到的 System.Web 类型, 只实现隧道脚本需要的成员
+using System.Collections.Generic;
+using System.IO;
+
+namespace System.Web
+{
+    public interface IHttpHandler
+    {
+        void ProcessRequest(HttpContext context);
+        bool IsReusable { get; }
+    }
+
+    public class HttpApplicationState
+    {
+        readonly Dictionary<string, object> items = new Dictionary<string, object>();
+
+        public object this[string name]
+        {
+            get
+            {
+                lock (items)
+                {
+                    object value;
+                    items.TryGetValue(name, out value);
+                    return value;
+                }
+            }
+            set
+            {
+                lock (items)
+                {
+                    items[name] = value;
+                }
+            }
+        }
+
+        public void Remove(string name)
+        {
+            lock (items)
+            {
+                items.Remove(name);
+            }
+        }
+    }
+
+    public class HttpRequest
+    {
+        public Stream InputStream { get; set; }
+        public string HttpMethod { get; set; }
+        public string ContentType { get; set; }
+    }
+
+    public class HttpResponse
+    {
+        public HttpResponse()
+        {
+            StatusCode = 200;
+            Body = new MemoryStream();
+        }
+
+        public int StatusCode { get; set; }
+        public string ContentType { get; set; }
+        public MemoryStream Body { get; private set; }
+
+        public void BinaryWrite(byte[] data)
+        {
+            Body.Write(data, 0, data.Length);
+        }
+    }
+
+    public class HttpContext
+    {
+        public HttpRequest Request { get; set; }
+        public HttpResponse Response { get; set; }
+        public HttpApplicationState Application { get; set; }
+    }
+}
@@ -0,0 +1,89 @@
+import com.sun.net.httpserver.HttpExchange;
+import com.sun.net.httpserver.HttpHandler;
+import com.sun.net.httpserver.HttpServer;
+
+import java.io.InputStream;
+import java.io.OutputStream;
+import java.io.StringWriter;
+import java.io.Writer;
+import java.net.InetSocketAddress;
+import java.util.concurrent.ConcurrentHashMap;
+import java.util.concurrent.Executors;
+
+import javax.servlet.ServletContext;
+import javax.servlet.http.HttpServletRequest;
+import javax.servlet.http.HttpServletResponse;
+
+// 在 127.0.0.1:<port> 上提供 /tunnel.jsp 与 /tunnel.jspx, 所有请求共享同一个 ServletContext
+public class Host {
+    static final ConcurrentHashMap<String, Object> attributes = new ConcurrentHashMap<String, Object>();
+
+    static final ServletContext application = new ServletContext() {
+        public Object getAttribute(String name) {
+            return attributes.get(name);
+        }
+
+        public void setAttribute(String name, Object value) {
+            attributes.put(name, value);
+        }
+
+        public void removeAttribute(String name) {
+            attributes.remove(name);
+        }
+    };
+
+    public static void main(String[] args) throws Exception {
+        HttpServer server = HttpServer.create(new InetSocketAddress("127.0.0.1", Integer.parseInt(args[0])), 0);
+        server.setExecutor(Executors.newCachedThreadPool());
+        server.createContext("/", new HttpHandler() {
+            public void handle(HttpExchange exchange) {
+                try {
+                    serve(exchange);
+                } catch (Exception e) {
+                    e.printStackTrace();
+                } finally {
+                    exchange.close();
+                }
+            }
+        });
+        server.start();
+        System.out.println("listening");
+    }
+
+    static void serve(final HttpExchange exchange) throws Exception {
+        final int[] status = {200};
+        HttpServletRequest request = new HttpServletRequest() {
+            public InputStream getInputStream() {
+                return exchange.getRequestBody();
+            }
+
+            public String getMethod() {
+                return exchange.getRequestMethod();
+            }
+
+            public String getContentType() {
+                return exchange.getRequestHeaders().getFirst("Content-Type");
+            }
+        };
+        HttpServletResponse response = new HttpServletResponse() {
+            public void setContentType(String type) {
+                exchange.getResponseHeaders().set("Content-Type", type);
+            }
+
+            public void setStatus(int code) {
+                status[0] = code;
+            }
+        };
+        Writer out = new StringWriter();
+        if (exchange.getRequestURI().getPath().endsWith(".jspx")) {
+            TunnelJspx.service(request, response, application, out);
+        } else {
+            TunnelJsp.service(request, response, application, out);
+        }
+        byte[] body = out.toString().getBytes("ISO-8859-1");
+        exchange.sendResponseHeaders(status[0], body.length == 0 ? -1 : body.length);
+        OutputStream os = exchange.getResponseBody();
+        os.write(body);
+        os.close();
+    }
+}
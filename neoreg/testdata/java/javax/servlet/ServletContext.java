@@ -0,0 +1,10 @@
+package javax.servlet;
+
+// 生成的 jsp/jspx 用到的 ServletContext 成员, 只用于测试
+public interface ServletContext {
+    Object getAttribute(String name);
+
+    void setAttribute(String name, Object value);
+
+    void removeAttribute(String name);
+}
@@ -0,0 +1,13 @@
+package javax.servlet.http;
+
+import java.io.IOException;
+import java.io.InputStream;
+
+// 生成的 jsp/jspx 用到的 HttpServletRequest 成员, 只用于测试
+public interface HttpServletRequest {
+    InputStream getInputStream() throws IOException;
+
+    String getMethod();
+
+    String getContentType();
+}
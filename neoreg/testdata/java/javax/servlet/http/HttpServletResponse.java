@@ -0,0 +1,8 @@
+package javax.servlet.http;
+
+// 生成的 jsp/jspx 用到的 HttpServletResponse 成员, 只用于测试
+public interface HttpServletResponse {
+    void setContentType(String type);
+
+    void setStatus(int code);
+}
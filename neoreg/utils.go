@@ -114,8 +114,8 @@ func blvDecode(data []byte, offset int32) map[int][]byte {
 			return nil
 		}
 
-		// 只保存有效的命令数据
-		if b > 0 && b < blvHeadLen {
+		// 只保存有效的命令数据, cmdSeq 为 Handler 扩展的字段
+		if b > 0 && b < blvHeadLen || b == cmdSeq {
 			info[b] = v
 		}
 	}
@@ -125,10 +125,21 @@ func blvDecode(data []byte, offset int32) map[int][]byte {
 
 func encodeBody(info map[int][]byte, conf *NeoregConf) []byte {
 	data := blvEncode(info, conf.blvOffset)
-	return base64encode(data, conf.EncodeMap)
+	encoded := base64encode(data, conf.EncodeMap)
+	if len(conf.BodyPrefix) == 0 && len(conf.BodySuffix) == 0 {
+		return encoded
+	}
+	body := make([]byte, 0, len(conf.BodyPrefix)+len(encoded)+len(conf.BodySuffix))
+	body = append(body, conf.BodyPrefix...)
+	body = append(body, encoded...)
+	return append(body, conf.BodySuffix...)
 }
 
 func decodeBody(data []byte, conf *NeoregConf) map[int][]byte {
-	rawdata, _ := base64decode(data, conf.DecodeMap)
+	// 服务端同样可能用模板包裹响应, 编码后的字符集中不含空白字符
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, conf.BodyPrefix)
+	data = bytes.TrimSuffix(data, conf.BodySuffix)
+	rawdata, _ := base64decode(bytes.TrimSpace(data), conf.DecodeMap)
 	return blvDecode(rawdata, conf.blvOffset)
 }
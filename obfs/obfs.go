@@ -0,0 +1,108 @@
+package obfs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrHandshake 为服务端没有返回 101 响应时的错误
+var ErrHandshake = errors.New("obfs: unexpected http response")
+
+// DefaultUserAgent 与 simple-obfs 的 obfs-local 一致
+var DefaultUserAgent = "curl/7.64.1"
+
+// Conf 为 simple-obfs http 模式的配置
+type Conf struct {
+	// Host 为伪装请求的 Host 头, 为空时使用代理地址
+	Host string
+	// URI 为伪装请求的路径, 默认 /
+	URI       string
+	UserAgent string
+}
+
+// NewConfFromURL 从URL中解析参数生成配置:
+// obfs (只支持 http), obfs-host, obfs-uri, obfs-ua
+func NewConfFromURL(proxyURL *url.URL) (*Conf, error) {
+	query := proxyURL.Query()
+	if mode := query.Get("obfs"); mode != "" && !strings.EqualFold(mode, "http") {
+		return nil, fmt.Errorf("unsupported obfs mode: %s", mode)
+	}
+	conf := &Conf{
+		Host:      query.Get("obfs-host"),
+		URI:       query.Get("obfs-uri"),
+		UserAgent: query.Get("obfs-ua"),
+	}
+	if conf.Host == "" {
+		conf.Host = proxyURL.Host
+	}
+	if conf.URI == "" {
+		conf.URI = "/"
+	}
+	if conf.UserAgent == "" {
+		conf.UserAgent = DefaultUserAgent
+	}
+	return conf, nil
+}
+
+// Conn 为 simple-obfs http 模式的客户端连接:
+// 第一次 Write 的数据作为 WebSocket 升级请求的请求体发送, 第一次 Read 时跳过服务端的 101 响应头,
+// 之后直接传输原始数据
+type Conn struct {
+	net.Conn
+	conf *Conf
+
+	writeMu   sync.Mutex
+	requested bool
+
+	readMu sync.Mutex
+	reader io.Reader
+}
+
+// NewConn 包装到服务端的连接
+func NewConn(conn net.Conn, conf *Conf) *Conn {
+	return &Conn{Conn: conn, conf: conf}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.requested {
+		return c.Conn.Write(b)
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	header := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nContent-Length: %d\r\n\r\n",
+		c.conf.URI, c.conf.Host, c.conf.UserAgent, base64.StdEncoding.EncodeToString(key), len(b))
+	if _, err := c.Conn.Write(append([]byte(header), b...)); err != nil {
+		return 0, err
+	}
+	c.requested = true
+	return len(b), nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.reader == nil {
+		br := bufio.NewReader(c.Conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			return 0, ErrHandshake
+		}
+		c.reader = br
+	}
+	return c.reader.Read(b)
+}
@@ -0,0 +1,67 @@
+package obfs
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServerConn 为 simple-obfs http 模式的服务端连接:
+// 第一次 Read 时解析升级请求并返回 101 响应, 请求体作为最先读到的数据
+type ServerConn struct {
+	net.Conn
+
+	once   sync.Once
+	reader io.Reader
+	err    error
+}
+
+// NewServerConn 包装客户端连接
+func NewServerConn(conn net.Conn) *ServerConn {
+	return &ServerConn{Conn: conn}
+}
+
+func (c *ServerConn) Read(b []byte) (int, error) {
+	c.once.Do(c.handshake)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *ServerConn) handshake() {
+	br := bufio.NewReader(c.Conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		c.err = err
+		return
+	}
+	h := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	resp := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nServer: nginx/1.18.0\r\nDate: %s\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		time.Now().UTC().Format(http.TimeFormat), base64.StdEncoding.EncodeToString(h[:]))
+	if _, err := c.Conn.Write([]byte(resp)); err != nil {
+		c.err = err
+		return
+	}
+	c.reader = io.MultiReader(req.Body, br)
+}
+
+// Listener 把接受的连接包装为 ServerConn, 用于在 socks5 等服务前接收 obfs 承载层连接
+type Listener struct {
+	net.Listener
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewServerConn(conn), nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	httpProxy "github.com/chainreactors/proxyclient/http"
+	"github.com/chainreactors/proxyclient/proxyproto"
 	socksProxy "github.com/chainreactors/proxyclient/socks"
 )
 
@@ -21,6 +22,13 @@ func newDirectProxyClient(proxy *url.URL, _ Dial) (dial Dial, err error) {
 	if timeout, _ := time.ParseDuration(proxy.Query().Get("timeout")); timeout != 0 {
 		dial = DialWithTimeout(timeout)
 	}
+	if v := proxy.Query().Get("proxy-protocol"); v != "" {
+		version, err := proxyproto.ParseVersion(v)
+		if err != nil {
+			return nil, err
+		}
+		dial = WithProxyProtocol(dial, version)
+	}
 	return
 }
 
@@ -53,9 +61,9 @@ func newHTTPProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, err error
 }
 
 func newSocksProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, err error) {
+	// TLS 由 tls 承载层处理, 例如 socks5+tls://
 	conf := &socksProxy.SOCKSConf{
-		TLSConfig: tlsConfigByProxyURL(proxy),
-		Dial:      upstreamDial,
+		Dial: upstreamDial,
 	}
 	client, err := socksProxy.NewClient(proxy, conf)
 	if err != nil {
@@ -6,22 +6,28 @@ import (
 	"net"
 	"net/url"
 	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
 )
 
 func newBlackholeProxyClient(_ *url.URL, _ Dial) (dial Dial, err error) {
 	dial = func(ctx context.Context, network, address string) (net.Conn, error) {
-		return blackholeConn{}, nil
+		return blackholeConn{remote: tunnel.NewAddr("blackhole", address)}, nil
 	}
 	return
 }
 
-type blackholeConn struct{}
+var blackholeLocalAddr = tunnel.NewAddr("blackhole", "blackhole")
+
+type blackholeConn struct {
+	remote net.Addr
+}
 
 func (blackholeConn) Read([]byte) (int, error)         { return 0, nil }
 func (blackholeConn) Write(buffer []byte) (int, error) { return len(buffer), nil }
 func (blackholeConn) Close() error                     { return nil }
-func (blackholeConn) LocalAddr() net.Addr              { return nil }
-func (blackholeConn) RemoteAddr() net.Addr             { return nil }
+func (blackholeConn) LocalAddr() net.Addr              { return blackholeLocalAddr }
+func (c blackholeConn) RemoteAddr() net.Addr           { return c.remote }
 func (blackholeConn) SetDeadline(time.Time) error      { return errors.New("unsupported") }
 func (blackholeConn) SetReadDeadline(time.Time) error  { return errors.New("unsupported") }
 func (blackholeConn) SetWriteDeadline(time.Time) error { return errors.New("unsupported") }
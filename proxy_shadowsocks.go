@@ -62,12 +62,11 @@ func newShadowsocksProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, er
 			return
 		}
 	}
-
-	conn, err := upstreamDial(context.Background(), "tcp", proxy.Host)
-	if err != nil {
-		return nil, err
+	if cipher == nil {
+		err = errors.New("password is not available")
+		return
 	}
-	conn = cipher.StreamConn(conn)
+	// 每次 Dial 建立新的加密连接, 可以使用 ss+tls:// 等承载层
 	dial = func(ctx context.Context, network, address string) (net.Conn, error) {
 		host, port, err := net.SplitHostPort(address)
 		if err != nil {
@@ -81,11 +80,17 @@ func newShadowsocksProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, er
 		if err != nil {
 			return nil, err
 		}
-		_, err = conn.Write(addr)
+		conn, err := upstreamDial(ctx, "tcp", proxy.Host)
 		if err != nil {
 			return nil, err
 		}
+		conn = cipher.StreamConn(conn)
+		if _, err = conn.Write(addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
 		return conn, nil
 	}
+	dial = dial.TCPOnly
 	return
 }
@@ -0,0 +1,95 @@
+package proxyclient
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	ss "github.com/shadowsocks/go-shadowsocks2/core"
+	ssSocks "github.com/shadowsocks/go-shadowsocks2/socks"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+)
+
+// newTestShadowsocksServer 启动 ShadowSocks 服务端, 把每个连接转发到请求头中的目标地址
+func newTestShadowsocksServer(t *testing.T, method, password string) string {
+	// 客户端与服务端在同一进程中共享 salt 过滤器, 关闭重放检测
+	os.Setenv("SHADOWSOCKS_SF_CAPACITY", "-1")
+	cipher, err := ss.PickCipher(method, nil, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn = cipher.StreamConn(conn)
+				addr, err := ssSocks.ReadAddr(conn)
+				if err != nil {
+					return
+				}
+				remote, err := net.Dial("tcp", addr.String())
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestShadowsocksDial 每次 Dial 使用独立的加密连接, 并发连接互不干扰
+func TestShadowsocksDial(t *testing.T) {
+	echo := testutil.Echo(t)
+
+	server := newTestShadowsocksServer(t, "AEAD_CHACHA20_POLY1305", "secret")
+	proxy, _ := url.Parse("ss://AEAD_CHACHA20_POLY1305:secret@" + server)
+	dial, err := NewClient(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := dial.Dial("tcp", echo)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			payload := []byte("shadowsocks-" + strconv.Itoa(i))
+			if _, err := conn.Write(payload); err != nil {
+				t.Error(err)
+				return
+			}
+			received := make([]byte, len(payload))
+			if _, err := io.ReadFull(conn, received); err != nil || string(received) != string(payload) {
+				t.Errorf("echo = %q, %v", received, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := dial.Dial("udp", echo); err == nil {
+		t.Error("udp dial accepted")
+	}
+}
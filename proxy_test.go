@@ -0,0 +1,157 @@
+package proxyclient
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/chainreactors/proxyclient/acl"
+	httpProxy "github.com/chainreactors/proxyclient/http"
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/chainreactors/proxyclient/proxyproto"
+)
+
+// spoofedConn 伪造 LocalAddr, 用来确认服务端读取的是 PROXY 协议头中的地址
+type spoofedConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c spoofedConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+var spoofedAddr = &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 4000}
+
+func spoofedDial(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return spoofedConn{Conn: conn, local: spoofedAddr}, nil
+}
+
+func TestProxyProtocolDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	pl := proxyproto.NewListener(listener)
+	// TCP 连接由支持 CloseWrite 的包装返回, 两种包装都提供 Header
+	accept := func() interface {
+		net.Conn
+		Header() error
+	} {
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := conn.(interface{ CloseWrite() error }); !ok {
+			t.Fatal("accepted TCP conn does not support CloseWrite")
+		}
+		return conn.(interface {
+			net.Conn
+			Header() error
+		})
+	}
+
+	for _, version := range []int{1, 2} {
+		conn, err := WithProxyProtocol(spoofedDial, version)(context.Background(), "tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("data"))
+		server := accept()
+		if err := server.Header(); err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if server.RemoteAddr().String() != spoofedAddr.String() {
+			t.Errorf("v%d: source = %s, want %s", version, server.RemoteAddr(), spoofedAddr)
+		}
+		data := make([]byte, 4)
+		if _, err := io.ReadFull(server, data); err != nil || string(data) != "data" {
+			t.Errorf("v%d: read %q, %v", version, data, err)
+		}
+		conn.Close()
+		server.Close()
+	}
+
+	for _, v := range []string{"v1", "v2"} {
+		proxy, _ := url.Parse("direct://?proxy-protocol=" + v)
+		dial, err := NewClient(proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := accept()
+		if err := server.Header(); err != nil {
+			t.Fatalf("%s: %v", v, err)
+		}
+		if server.RemoteAddr().String() != conn.LocalAddr().String() {
+			t.Errorf("%s: source = %s, want %s", v, server.RemoteAddr(), conn.LocalAddr())
+		}
+		conn.Close()
+		server.Close()
+	}
+
+	proxy, _ := url.Parse("direct://?proxy-protocol=v3")
+	if _, err := NewClient(proxy); err == nil {
+		t.Error("invalid proxy-protocol version accepted")
+	}
+}
+
+func TestHTTPProxyProtocol(t *testing.T) {
+	target := testutil.Echo(t)
+	clients := make(chan net.Addr, 1)
+	handler := httpProxy.Handler{
+		Dial:          net.Dial,
+		ProxyProtocol: true,
+		Policy: func(r *acl.Request) bool {
+			clients <- r.Client
+			return true
+		},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go httpProxy.ServeHandler(listener, handler)
+
+	proxy, _ := url.Parse("http://" + listener.Addr().String())
+	dial, err := DialFactory(newHTTPProxyClient)(proxy, WithProxyProtocol(spoofedDial, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if client := <-clients; client.String() != spoofedAddr.String() {
+		t.Errorf("policy saw client %s, want %s", client, spoofedAddr)
+	}
+
+	// 没有经过 ServeHandler 时拒绝请求, 而不是忽略 ProxyProtocol
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	go http.Serve(plain, handler)
+	response, err := http.Get("http://" + plain.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", response.StatusCode)
+	}
+}
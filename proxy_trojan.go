@@ -0,0 +1,19 @@
+package proxyclient
+
+import (
+	"net/url"
+
+	"github.com/chainreactors/proxyclient/trojan"
+)
+
+// newTrojanProxyClient 创建 trojan 客户端, TLS 握手经由上游 Dial, 支持 tcp 与 udp
+func newTrojanProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, err error) {
+	conf, err := trojan.NewConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	conf.Dial = upstreamDial
+	client := &trojan.TrojanClient{Proxy: proxy, Conf: conf}
+	dial = client.DialContext
+	return
+}
@@ -0,0 +1,47 @@
+package proxyclient
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	socksProxy "github.com/chainreactors/proxyclient/socks"
+	"github.com/chainreactors/proxyclient/trojan"
+)
+
+func TestTrojanScheme(t *testing.T) {
+	target := testutil.Echo(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go trojan.NewServer(newTestTLSConfig(t), "password").Serve(listener)
+
+	// 经由 socks5 上游连接 trojan 服务端
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go socksProxy.Serve(socks, &socksProxy.SOCKSConf{Dial: DefaultDial})
+	upstream, _ := url.Parse("socks5://" + socks.Addr().String())
+	proxy, _ := url.Parse("trojan://password@" + listener.Addr().String() + "?allowInsecure=1")
+	dial, err := NewClientChain([]*url.URL{upstream, proxy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("chain")); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, 5)
+	if _, err := io.ReadFull(conn, received); err != nil || string(received) != "chain" {
+		t.Fatalf("received %q, %v", received, err)
+	}
+}
@@ -0,0 +1,85 @@
+package proxyclient
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/chainreactors/proxyclient/v2ray"
+)
+
+// newVLESSProxyClient 创建 vless 客户端, 分享链接中的 type=ws 与 security=tls 转换为承载层
+func newVLESSProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, err error) {
+	conf, err := v2ray.NewVLESSConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Dial, err = withStreamSettings(proxy, conf.Server, &conf.Stream, upstreamDial); err != nil {
+		return nil, err
+	}
+	client := &v2ray.VLESSClient{Proxy: proxy, Conf: conf}
+	dial = client.DialContext
+	return
+}
+
+// newVMessProxyClient 创建 vmess 客户端, 分享链接中的 net=ws 与 tls=tls 转换为承载层
+func newVMessProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, err error) {
+	conf, err := v2ray.NewVMessConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Dial, err = withStreamSettings(proxy, conf.Server, &conf.Stream, upstreamDial); err != nil {
+		return nil, err
+	}
+	client := &v2ray.VMessClient{Proxy: proxy, Conf: conf}
+	dial = client.DialContext
+	return
+}
+
+// withStreamSettings 按分享链接的传输层参数包装 upstreamDial, 与 vless+ws+tls:// 等写法使用相同的承载层。
+// path 中的 ?ed=N 与 Xray 一致, 转换为 ws 的 early_data
+func withStreamSettings(proxy *url.URL, server string, settings *v2ray.StreamSettings, upstreamDial Dial) (Dial, error) {
+	scheme := proxy.Scheme
+	query := proxy.Query()
+	path := ""
+	if settings.Network == "ws" {
+		scheme += "+WS"
+		path = settings.Path
+		if i := strings.Index(path, "?"); i >= 0 {
+			if ed, err := url.ParseQuery(path[i+1:]); err == nil && ed.Get("ed") != "" {
+				query.Set("early_data", ed.Get("ed"))
+			}
+			path = path[:i]
+		}
+		if settings.Host != "" {
+			query.Set("host", settings.Host)
+		}
+	}
+	if settings.Security == "tls" {
+		scheme += "+TLS"
+		sni := settings.SNI
+		if sni == "" {
+			sni = settings.Host
+		}
+		if sni != "" {
+			query.Set("tls-domain", sni)
+		}
+		if settings.AllowInsecure {
+			query.Set("tls-insecure-skip-verify", "true")
+		}
+	}
+	if scheme == proxy.Scheme {
+		return upstreamDial, nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+	_, dial, err := wrapTransports(&url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(host, port),
+		Path:     path,
+		RawQuery: query.Encode(),
+	}, upstreamDial)
+	return dial, err
+}
@@ -0,0 +1,63 @@
+package proxyclient
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/chainreactors/proxyclient/v2ray"
+	"github.com/chainreactors/proxyclient/ws"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func TestV2rayScheme(t *testing.T) {
+	target := testutil.Echo(t)
+	id, _ := v2ray.ParseUUID(testUUID)
+	newServer := func(server *v2ray.Server) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		go server.Serve(listener)
+		return listener.Addr().String()
+	}
+	vless, vmess := newServer(v2ray.NewVLESSServer(id)), newServer(v2ray.NewVMessServer(id))
+
+	// 分享链接中的 type=ws 与 security=tls 使用 ws 与 tls 承载层
+	vlessHandler := ws.NewHandler()
+	vlessHandler.Backend = vless
+	vlessWSS := httptest.NewTLSServer(vlessHandler)
+	defer vlessWSS.Close()
+	vmessHandler := ws.NewHandler()
+	vmessHandler.Backend = vmess
+	vmessWS := httptest.NewServer(vmessHandler)
+	defer vmessWS.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", newTestTLSConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go v2ray.NewVLESSServer(id).Serve(listener)
+
+	vmessWSAddr := strings.TrimPrefix(vmessWS.URL, "http://")
+	host, port, _ := net.SplitHostPort(vmessWSAddr)
+	link := `{"v":"2","add":"` + host + `","port":"` + port + `","id":"` + testUUID + `","aid":"0","net":"ws","path":"/ray?ed=2048"}`
+
+	for _, rawURL := range []string{
+		"vless://" + testUUID + "@" + vless + "?encryption=none",
+		"vless://" + testUUID + "@" + strings.TrimPrefix(vlessWSS.URL, "https://") + "?encryption=none&security=tls&type=ws&path=%2Fws&allowInsecure=1",
+		"vless+tls://" + testUUID + "@" + listener.Addr().String() + "?tls-insecure-skip-verify=true",
+		"vmess://" + testUUID + "@" + vmess + "?encryption=chacha20-poly1305",
+		"vmess+ws://" + testUUID + "@" + vmessWSAddr + "/ws",
+		"vmess://" + base64.StdEncoding.EncodeToString([]byte(link)),
+	} {
+		testDialEcho(t, rawURL, target)
+	}
+}
@@ -0,0 +1,39 @@
+package proxyclient
+
+import (
+	"net/url"
+
+	"github.com/chainreactors/proxyclient/ws"
+)
+
+// newWebSocketProxyClient 创建 ws/wss 客户端, 由服务端连接目标地址
+func newWebSocketProxyClient(proxy *url.URL, upstreamDial Dial) (dial Dial, err error) {
+	conf, err := ws.NewConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	conf.Dial = upstreamDial
+	client := &ws.WSClient{Proxy: proxy, Conf: conf}
+	dial = Dial(client.DialContext).TCPOnly
+	return
+}
+
+// newWebSocketTransport 创建 ws/wss 承载层, 例如 socks5+ws://:
+// 到代理服务器的连接经由同一地址与路径的 WebSocket 建立, 参数与 ws 协议相同
+func newWebSocketTransport(scheme string) TransportFactory {
+	return func(proxy *url.URL, upstreamDial Dial) (Dial, error) {
+		conf, err := ws.NewConfFromURL(&url.URL{
+			Scheme:   scheme,
+			Host:     proxy.Host,
+			Path:     proxy.Path,
+			RawPath:  proxy.RawPath,
+			RawQuery: proxy.RawQuery,
+		})
+		if err != nil {
+			return nil, err
+		}
+		conf.Dial = upstreamDial
+		client := &ws.WSClient{Proxy: proxy, Conf: conf}
+		return client.DialContext, nil
+	}
+}
@@ -0,0 +1,34 @@
+package proxyclient
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	socksProxy "github.com/chainreactors/proxyclient/socks"
+	"github.com/chainreactors/proxyclient/ws"
+)
+
+func TestWebSocketCarrier(t *testing.T) {
+	target := testutil.Echo(t)
+
+	// 作为承载层时 ws 服务端把连接转发给 socks5 服务
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go socksProxy.Serve(socks, &socksProxy.SOCKSConf{Dial: DefaultDial})
+	carrier := ws.NewHandler()
+	carrier.Backend = socks.Addr().String()
+	hs := httptest.NewServer(carrier)
+	defer hs.Close()
+
+	// 单独使用时由 ws 服务端连接目标地址
+	standalone := httptest.NewServer(ws.NewHandler())
+	defer standalone.Close()
+
+	testDialEcho(t, "socks5+ws://"+strings.TrimPrefix(hs.URL, "http://")+"/ws", target)
+	testDialEcho(t, "ws://"+strings.TrimPrefix(standalone.URL, "http://")+"/ws", target)
+}
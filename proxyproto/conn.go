@@ -0,0 +1,106 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout 为等待 PROXY 协议头的最长时间
+var DefaultHeaderTimeout = 5 * time.Second
+
+// Conn 在第一次 Read/RemoteAddr/LocalAddr 时读取 PROXY 协议头,
+// 之后 RemoteAddr 与 LocalAddr 返回协议头中记录的真实客户端地址与目的地址。
+// 协议头缺失或格式错误时, Read 返回错误。
+// Conn 不提供 CloseWrite, 底层连接支持半关闭时 NewConn 返回带 CloseWrite 的 halfCloseConn。
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	src    net.Addr
+	dst    net.Addr
+	err    error
+}
+
+// NewConn 返回 *Conn, 底层连接支持 CloseWrite 时返回同样支持 CloseWrite 的包装,
+// 以免 relay 误以为可以半关闭
+func NewConn(conn net.Conn) net.Conn {
+	c := &Conn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	if _, ok := conn.(closeWriter); ok {
+		return &halfCloseConn{c}
+	}
+	return c
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(DefaultHeaderTimeout))
+		c.src, c.dst, c.err = ReadHeader(c.reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Header 立即读取协议头并返回解析错误
+func (c *Conn) Header() error {
+	c.readHeader()
+	return c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// halfCloseConn 为底层连接支持 CloseWrite 的 Conn, CloseWrite 透传给底层连接, 便于 relay 进行半关闭
+type halfCloseConn struct {
+	*Conn
+}
+
+func (c *halfCloseConn) CloseWrite() error {
+	return c.Conn.Conn.(closeWriter).CloseWrite()
+}
+
+// Listener 为每个接受的连接解析 PROXY 协议头, 解析在连接自身的 goroutine 中惰性进行,
+// 不会阻塞 Accept
+type Listener struct {
+	net.Listener
+}
+
+func NewListener(listener net.Listener) *Listener {
+	return &Listener{listener}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
@@ -0,0 +1,208 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	V1 = 1
+	V2 = 2
+
+	v1MaxLength = 107
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	ErrNoHeader      = errors.New("proxy protocol header not found")
+	ErrInvalidHeader = errors.New("invalid proxy protocol header")
+)
+
+// ParseVersion 解析 "v1"/"v2"/"1"/"2"
+func ParseVersion(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "v1", "1":
+		return V1, nil
+	case "v2", "2":
+		return V2, nil
+	default:
+		return 0, fmt.Errorf("unsupported proxy protocol version: %s", s)
+	}
+}
+
+// WriteHeader 写入 PROXY 协议头, src/dst 不是 TCP/UDP 地址时写入 UNKNOWN (v1) 或 UNSPEC (v2)
+func WriteHeader(w io.Writer, version int, src, dst net.Addr) error {
+	var header []byte
+	switch version {
+	case V1:
+		header = encodeV1(src, dst)
+	case V2:
+		header = encodeV2(src, dst)
+	default:
+		return fmt.Errorf("unsupported proxy protocol version: %d", version)
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// ReadHeader 读取 v1 或 v2 协议头并返回其中的源地址和目的地址,
+// 对于 UNKNOWN/LOCAL 头返回 nil 地址
+func ReadHeader(reader *bufio.Reader) (src, dst net.Addr, err error) {
+	peek, err := reader.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(peek, v1Prefix) {
+		return readV1(reader)
+	}
+	peek, err = reader.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(peek, v2Signature) {
+		return readV2(reader)
+	}
+	return nil, nil, ErrNoHeader
+}
+
+func splitAddr(addr net.Addr) (ip net.IP, port int, udp bool, ok bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, false, a.IP != nil
+	case *net.UDPAddr:
+		return a.IP, a.Port, true, a.IP != nil
+	}
+	return nil, 0, false, false
+}
+
+func encodeV1(src, dst net.Addr) []byte {
+	srcIP, srcPort, _, ok1 := splitAddr(src)
+	dstIP, dstPort, _, ok2 := splitAddr(dst)
+	if !ok1 || !ok2 {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP4"
+	if srcIP.To4() == nil || dstIP.To4() == nil {
+		family = "TCP6"
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcPort, dstPort))
+}
+
+func encodeV2(src, dst net.Addr) []byte {
+	header := append([]byte{}, v2Signature...)
+	// 版本 2, PROXY 命令
+	header = append(header, 0x21)
+
+	srcIP, srcPort, udp, ok1 := splitAddr(src)
+	dstIP, dstPort, _, ok2 := splitAddr(dst)
+	if !ok1 || !ok2 {
+		return append(header, 0x00, 0x00, 0x00)
+	}
+	transport := byte(0x01)
+	if udp {
+		transport = 0x02
+	}
+	var addrs []byte
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		header = append(header, 0x10|transport)
+		addrs = append(append(addrs, src4...), dst4...)
+	} else {
+		header = append(header, 0x20|transport)
+		addrs = append(append(addrs, srcIP.To16()...), dstIP.To16()...)
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dstPort))
+	addrs = append(addrs, ports...)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addrs)))
+	header = append(header, length...)
+	return append(header, addrs...)
+}
+
+func readV1(reader *bufio.Reader) (src, dst net.Addr, err error) {
+	line := make([]byte, 0, v1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, nil, ErrInvalidHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidHeader
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readV2(reader *bufio.Reader) (src, dst net.Addr, err error) {
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:]))
+	if verCmd>>4 != 2 {
+		return nil, nil, ErrInvalidHeader
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return nil, nil, err
+	}
+	// LOCAL 命令表示健康检查等由代理自身发起的连接
+	if verCmd&0x0F == 0x00 {
+		return nil, nil, nil
+	}
+	if verCmd&0x0F != 0x01 {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		// AF_UNSPEC 与 AF_UNIX 没有可用的 IP 地址
+		return nil, nil, nil
+	}
+	if len(payload) < ipLen*2+4 {
+		return nil, nil, ErrInvalidHeader
+	}
+	srcIP := net.IP(append([]byte{}, payload[:ipLen]...))
+	dstIP := net.IP(append([]byte{}, payload[ipLen:ipLen*2]...))
+	srcPort := int(binary.BigEndian.Uint16(payload[ipLen*2:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[ipLen*2+2:]))
+	if family&0x0F == 0x02 {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}, nil
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+}
@@ -0,0 +1,113 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+	}{
+		{"tcp4", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51000}, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1080}},
+		{"tcp6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51000}, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+	}
+	for _, tt := range tests {
+		for _, version := range []int{V1, V2} {
+			buf := &bytes.Buffer{}
+			if err := WriteHeader(buf, version, tt.src, tt.dst); err != nil {
+				t.Fatal(err)
+			}
+			buf.WriteString("payload")
+			reader := bufio.NewReader(buf)
+			src, dst, err := ReadHeader(reader)
+			if err != nil {
+				t.Fatalf("%s v%d: %v", tt.name, version, err)
+			}
+			if src.String() != tt.src.String() || dst.String() != tt.dst.String() {
+				t.Errorf("%s v%d: got %s -> %s", tt.name, version, src, dst)
+			}
+			rest, _ := ioutil.ReadAll(reader)
+			if string(rest) != "payload" {
+				t.Errorf("%s v%d: payload = %q", tt.name, version, rest)
+			}
+		}
+	}
+}
+
+func TestUnknownAndMissingHeader(t *testing.T) {
+	for _, version := range []int{V1, V2} {
+		buf := &bytes.Buffer{}
+		WriteHeader(buf, version, nil, nil)
+		src, dst, err := ReadHeader(bufio.NewReader(buf))
+		if err != nil || src != nil || dst != nil {
+			t.Errorf("v%d unknown header: %v %v %v", version, src, dst, err)
+		}
+	}
+	if _, _, err := ReadHeader(bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n\r\n"))); err != ErrNoHeader {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+}
+
+func TestConnRemoteAddr(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	real := &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 40000}
+	go func() {
+		WriteHeader(client, V2, real, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80})
+		client.Write([]byte("hello"))
+	}()
+	conn := NewConn(server)
+	if conn.RemoteAddr().String() != real.String() {
+		t.Errorf("RemoteAddr = %s, want %s", conn.RemoteAddr(), real)
+	}
+	buf := make([]byte, 5)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read = %q, %v", buf, err)
+	}
+}
+
+func TestConnCloseWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	if _, ok := NewConn(server).(interface{ CloseWrite() error }); ok {
+		t.Error("CloseWrite exposed for a conn without half-close")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		WriteHeader(conn, V1, conn.LocalAddr(), conn.RemoteAddr())
+		// 服务端半关闭后客户端仍然可以发送数据
+		ioutil.ReadAll(conn)
+		conn.Write([]byte("after"))
+	}()
+	accepted, err := NewListener(listener).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	cw, ok := accepted.(interface{ CloseWrite() error })
+	if !ok {
+		t.Fatal("CloseWrite missing for a TCP conn")
+	}
+	if err := cw.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(accepted)
+	if err != nil || string(buf) != "after" {
+		t.Errorf("Read after CloseWrite = %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,211 @@
+package regeorg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+var (
+	// DefaultReadWait 为 READ 请求等待目标数据的最长时间
+	DefaultReadWait = 10 * time.Millisecond
+	// DefaultMaxReadSize 为单个 READ 响应携带的最大数据量
+	DefaultMaxReadSize = 512 * 1024
+	// DefaultCookieName 为保存会话的 cookie 名称, 与 tunnel.php 的 PHP session 一致
+	DefaultCookieName = "PHPSESSID"
+
+	errSessionNotFound = errors.New("session not found")
+)
+
+// Handler 为 Go 实现的 reGeorg 服务端, 与 tunnel.(php|jsp|aspx) 行为一致:
+// 命令取自 X-CMD 请求头或 cmd 参数, CONNECT 时通过 Set-Cookie 下发会话, 结果写入 X-STATUS/X-ERROR。
+// 没有命令的请求返回 reGeorg 的默认页面。
+type Handler struct {
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	ReadWait    time.Duration
+	MaxReadSize int
+	CookieName  string
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	conn   net.Conn
+	readMu sync.Mutex
+	buf    []byte
+}
+
+// NewHandler 创建 reGeorg 服务端
+func NewHandler() *Handler {
+	return &Handler{
+		Dial:        (&net.Dialer{Timeout: DefaultTimeout}).DialContext,
+		ReadWait:    DefaultReadWait,
+		MaxReadSize: DefaultMaxReadSize,
+		CookieName:  DefaultCookieName,
+		sessions:    make(map[string]*session),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cmd := strings.ToUpper(r.Header.Get(headerCmd))
+	if cmd == "" {
+		cmd = strings.ToUpper(r.URL.Query().Get("cmd"))
+	}
+	if cmd == "" || r.Method == http.MethodGet {
+		w.Write([]byte("Georg says, 'All seems fine'"))
+		return
+	}
+
+	var id string
+	if cookie, err := r.Cookie(h.cookieName()); err == nil {
+		id = cookie.Value
+	}
+	switch cmd {
+	case cmdConnect:
+		if id == "" {
+			id = tunnel.NewID()
+			http.SetCookie(w, &http.Cookie{Name: h.cookieName(), Value: id, Path: "/"})
+		}
+		target, port := r.Header.Get(headerTarget), r.Header.Get(headerPort)
+		if target == "" {
+			target, port = r.URL.Query().Get("target"), r.URL.Query().Get("port")
+		}
+		h.reply(w, h.connect(r.Context(), id, net.JoinHostPort(target, port)))
+	case cmdForward:
+		data, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = h.forward(id, data)
+		}
+		h.reply(w, err)
+	case cmdRead:
+		data, err := h.read(id)
+		h.reply(w, err)
+		w.Write(data)
+	case cmdDisconnect:
+		h.disconnect(id)
+		h.reply(w, nil)
+	default:
+		h.reply(w, errors.New("unknown command"))
+	}
+}
+
+// reply 写入 X-STATUS, io.EOF 表示目标已关闭, 返回不带 X-ERROR 的 FAIL
+func (h *Handler) reply(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.Header().Set(headerStatus, statusOK)
+	case err == io.EOF:
+		w.Header().Set(headerStatus, statusFail)
+	default:
+		w.Header().Set(headerStatus, statusFail)
+		w.Header().Set(headerError, err.Error())
+	}
+}
+
+// Close 关闭所有目标连接
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		s.conn.Close()
+		delete(h.sessions, id)
+	}
+	return nil
+}
+
+func (h *Handler) cookieName() string {
+	if h.CookieName == "" {
+		return DefaultCookieName
+	}
+	return h.CookieName
+}
+
+func (h *Handler) session(id string) *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+func (h *Handler) connect(ctx context.Context, id, address string) error {
+	conn, err := h.Dial(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*session)
+	}
+	if old := h.sessions[id]; old != nil {
+		old.conn.Close()
+	}
+	h.sessions[id] = &session{conn: conn}
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Handler) forward(id string, data []byte) error {
+	s := h.session(id)
+	if s == nil {
+		return errSessionNotFound
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		h.disconnect(id)
+		return err
+	}
+	return nil
+}
+
+// read 在 ReadWait 内读取目标数据, 没有数据时返回空, 目标关闭时返回 io.EOF
+func (h *Handler) read(id string) ([]byte, error) {
+	s := h.session(id)
+	if s == nil {
+		return nil, errSessionNotFound
+	}
+
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	size := h.MaxReadSize
+	if size <= 0 {
+		size = DefaultMaxReadSize
+	}
+	if len(s.buf) != size {
+		s.buf = make([]byte, size)
+	}
+
+	wait := h.ReadWait
+	if wait <= 0 {
+		wait = DefaultReadWait
+	}
+	s.conn.SetReadDeadline(time.Now().Add(wait))
+	n, err := s.conn.Read(s.buf)
+	if n > 0 {
+		return append([]byte{}, s.buf[:n]...), nil
+	}
+	if err != nil && !os.IsTimeout(err) {
+		h.disconnect(id)
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (h *Handler) disconnect(id string) {
+	h.mu.Lock()
+	s := h.sessions[id]
+	delete(h.sessions, id)
+	h.mu.Unlock()
+	if s != nil {
+		s.conn.Close()
+	}
+}
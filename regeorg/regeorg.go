@@ -0,0 +1,189 @@
+package regeorg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+// reGeorg 经典协议: 命令同时放在 cmd 参数与 X-CMD 请求头中, 结果由 X-STATUS/X-ERROR 响应头返回,
+// 服务端按 cookie 中的会话区分连接
+const (
+	headerCmd    = "X-CMD"
+	headerTarget = "X-TARGET"
+	headerPort   = "X-PORT"
+	headerStatus = "X-STATUS"
+	headerError  = "X-ERROR"
+
+	cmdConnect    = "CONNECT"
+	cmdDisconnect = "DISCONNECT"
+	cmdForward    = "FORWARD"
+	cmdRead       = "READ"
+	statusOK      = "OK"
+	statusFail    = "FAIL"
+
+	// addrNetwork 为 LocalAddr/RemoteAddr 的 Network()
+	addrNetwork = "regeorg"
+)
+
+var (
+	DefaultTimeout        = 5 * time.Second
+	DefaultMaxRetry       = 3
+	DefaultRetryInterval  = 100 * time.Millisecond
+	DefaultInterval       = 100 * time.Millisecond
+	DefaultReadBufferSize = 32 * 1024
+	DefaultMaxInterval    = 2 * time.Second
+	DefaultJitter         = 0.2
+	DefaultCoalesceWindow = 5 * time.Millisecond
+	DefaultMaxForwardSize = 64 * 1024
+	DefaultWriteQueueSize = 8
+	// DefaultUnhealthyTimeout 为请求失败的节点被跳过的时长
+	DefaultUnhealthyTimeout = tunnel.DefaultUnhealthyTimeout
+)
+
+// RegeorgClient 实现了Client接口
+type RegeorgClient struct {
+	Proxy *url.URL
+	Conf  *RegeorgConf
+}
+
+// RegeorgConf 配置结构, 公共配置由 tunnel.Config 提供。
+// 每个连接使用独立的 cookie jar, CONNECT 响应中设置的会话 cookie 会在后续请求中自动带回
+type RegeorgConf struct {
+	tunnel.Config
+
+	Protocol string // http/https
+
+	// endpointOnce 保证并发的首次 Dial 只补全一次 Endpoints
+	endpointOnce sync.Once
+}
+
+var _ tunnel.Codec = (*RegeorgConf)(nil)
+
+// NewConf 返回默认配置
+func NewConf() *RegeorgConf {
+	conf := &RegeorgConf{Protocol: "http"}
+	conf.Dial = (&net.Dialer{}).DialContext
+	conf.Timeout = DefaultTimeout
+	conf.Method = http.MethodPost
+	conf.Headers = http.Header{"User-Agent": {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"}}
+	conf.MaxRetry = DefaultMaxRetry
+	conf.RetryInterval = DefaultRetryInterval
+	conf.ConnOptions = tunnel.ConnOptions{
+		ReadBufferSize: DefaultReadBufferSize,
+		Interval:       DefaultInterval,
+		MaxInterval:    DefaultMaxInterval,
+		Jitter:         DefaultJitter,
+		CoalesceWindow: DefaultCoalesceWindow,
+		MaxForwardSize: DefaultMaxForwardSize,
+		WriteQueueSize: DefaultWriteQueueSize,
+	}
+	conf.UnhealthyTimeout = DefaultUnhealthyTimeout
+	return conf
+}
+
+// NewConfFromURL 从URL中解析参数生成配置
+func NewConfFromURL(proxyURL *url.URL) (*RegeorgConf, error) {
+	scheme := "http"
+	switch strings.ToLower(proxyURL.Scheme) {
+	case "regeorg":
+		scheme = "http"
+	case "regeorgs":
+		scheme = "https"
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", proxyURL.Scheme)
+	}
+
+	query := proxyURL.Query()
+	tlsConfig, err := tunnel.TLSConfigFromQuery(proxyURL.Hostname(), query)
+	if err != nil {
+		return nil, err
+	}
+	conf := NewConf()
+	conf.Protocol = scheme
+	conf.TLSConfig = tlsConfig
+	conf.Endpoints = []string{fmt.Sprintf("%s://%s%s", scheme, proxyURL.Host, proxyURL.Path)}
+	if err := conf.Config.ParseQuery(query); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Encode 把命令写入 cmd 参数与 X-CMD 请求头, FORWARD 的数据作为请求体
+func (conf *RegeorgConf) Encode(req *http.Request, cmd *tunnel.Command) error {
+	query := req.URL.Query()
+	switch cmd.Op {
+	case tunnel.OpConnect:
+		host, port, err := net.SplitHostPort(cmd.Address)
+		if err != nil {
+			return err
+		}
+		query.Set("cmd", "connect")
+		query.Set("target", host)
+		query.Set("port", port)
+		req.Header.Set(headerCmd, cmdConnect)
+		req.Header.Set(headerTarget, host)
+		req.Header.Set(headerPort, port)
+	case tunnel.OpRead:
+		query.Set("cmd", "read")
+		req.Header.Set(headerCmd, cmdRead)
+	case tunnel.OpForward:
+		query.Set("cmd", "forward")
+		req.Header.Set(headerCmd, cmdForward)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		tunnel.SetBody(req, cmd.Data)
+	case tunnel.OpDisconnect:
+		query.Set("cmd", "disconnect")
+		req.Header.Set(headerCmd, cmdDisconnect)
+	}
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// Decode 检查 X-STATUS, READ 成功时响应体即为数据, 没有 X-ERROR 的 READ 失败表示远端连接已关闭
+func (conf *RegeorgConf) Decode(resp *http.Response, body []byte, cmd *tunnel.Command) ([]byte, error) {
+	if cmd.Op == tunnel.OpDisconnect {
+		return nil, nil
+	}
+	switch resp.Header.Get(headerStatus) {
+	case statusOK:
+		if cmd.Op == tunnel.OpRead {
+			return body, nil
+		}
+		return nil, nil
+	case "":
+		return nil, fmt.Errorf("regeorg %s: invalid response, status %d", cmd.Op, resp.StatusCode)
+	}
+	msg := resp.Header.Get(headerError)
+	if msg == "" {
+		if cmd.Op == tunnel.OpRead {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("regeorg %s failed", cmd.Op)
+	}
+	return nil, fmt.Errorf("regeorg %s: %s", cmd.Op, msg)
+}
+
+func (c *RegeorgClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext 建立经由 reGeorg 的连接, ctx 取消时中断 CONNECT 握手
+func (c *RegeorgClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c.Conf.endpointOnce.Do(func() {
+		// 未经 NewConfFromURL 创建的配置使用 Proxy 作为唯一的节点
+		if len(c.Conf.Endpoints) == 0 {
+			c.Conf.Endpoints = []string{fmt.Sprintf("%s://%s%s", c.Conf.Protocol, c.Proxy.Host, c.Proxy.Path)}
+		}
+	})
+	client := &tunnel.Client{Config: &c.Conf.Config, Codec: c.Conf, Network: addrNetwork}
+	return client.DialContext(ctx, network, address)
+}
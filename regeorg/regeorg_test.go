@@ -0,0 +1,145 @@
+package regeorg
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+// newTestServer 启动 Go 实现的 reGeorg 服务端和一个 TCP echo 服务, 返回客户端与 echo 地址
+func newTestServer(t *testing.T) (*RegeorgClient, string) {
+	echo := testutil.Echo(t)
+
+	handler := NewHandler()
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		handler.Close()
+	})
+
+	proxyURL, _ := url.Parse("regeorg://" + strings.TrimPrefix(server.URL, "http://") + "/tunnel.php?interval=5ms")
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RegeorgClient{Proxy: proxyURL, Conf: conf}, echo
+}
+
+func TestRegeorgClientDial(t *testing.T) {
+	client, target := newTestServer(t)
+
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr(); addr.Network() != "regeorg" || addr.String() != target {
+		t.Errorf("RemoteAddr = %s %s", addr.Network(), addr)
+	}
+
+	if _, err = conn.Write([]byte("georg")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "georg" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("Dial to closed port succeeded")
+	}
+}
+
+func TestRegeorgSessions(t *testing.T) {
+	client, target := newTestServer(t)
+	// 手动创建的配置没有 Endpoints, 由并发的首次 Dial 补全
+	client.Conf.Endpoints = nil
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := client.Dial("tcp", target)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			payload := bytes.Repeat([]byte{byte(i)}, 16*1024+i)
+			go conn.Write(payload)
+			received := make([]byte, len(payload))
+			if _, err := io.ReadFull(conn, received); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(received, payload) {
+				t.Errorf("conn %d: data mismatch", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRegeorgRemoteClose(t *testing.T) {
+	client, _ := newTestServer(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("bye"))
+		conn.Close()
+	}()
+
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("ReadAll = %q, %v", data, err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	conf := NewConf()
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/tunnel.jsp?k=v", nil)
+	if err := conf.Encode(req, &tunnel.Command{Op: tunnel.OpConnect, Address: "10.0.0.1:22"}); err != nil {
+		t.Fatal(err)
+	}
+	query := req.URL.Query()
+	if query.Get("cmd") != "connect" || query.Get("target") != "10.0.0.1" || query.Get("port") != "22" || query.Get("k") != "v" {
+		t.Errorf("query = %s", req.URL.RawQuery)
+	}
+	if req.Header.Get(headerCmd) != cmdConnect || req.Header.Get(headerTarget) != "10.0.0.1" || req.Header.Get(headerPort) != "22" {
+		t.Errorf("header = %v", req.Header)
+	}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	if _, err := conf.Decode(resp, []byte("<html>"), &tunnel.Command{Op: tunnel.OpConnect}); err == nil {
+		t.Error("response without X-STATUS accepted")
+	}
+	resp.Header.Set(headerStatus, statusFail)
+	resp.Header.Set(headerError, "Failed connecting to target")
+	if _, err := conf.Decode(resp, nil, &tunnel.Command{Op: tunnel.OpConnect}); err == nil || !strings.Contains(err.Error(), "Failed connecting") {
+		t.Errorf("Decode error = %v", err)
+	}
+}
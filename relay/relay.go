@@ -0,0 +1,162 @@
+package relay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const bufferSize = 32 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, bufferSize)
+		return &buffer
+	},
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Relay 在 local 与 remote 之间双向转发数据, 直到两个方向都结束后关闭两端连接。
+// 两端都支持 CloseWrite 时, 一个方向读到 EOF 只会半关闭对端写方向, 另一方向继续转发;
+// 否则任一方向结束即关闭两端。idleTimeout 大于 0 时, 两个方向都没有数据超过该时长即断开。
+// 返回 local->remote 与 remote->local 的字节数, 以及第一个非 EOF 错误。
+func Relay(local, remote net.Conn, idleTimeout time.Duration) (sent, received int64, err error) {
+	r := &relay{
+		idleTimeout: idleTimeout,
+		halfClose:   supportsCloseWrite(local) && supportsCloseWrite(remote),
+		conns:       []net.Conn{local, remote},
+	}
+	r.touch()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sent = r.pipe(remote, local)
+	}()
+	go func() {
+		defer wg.Done()
+		received = r.pipe(local, remote)
+	}()
+	wg.Wait()
+	r.close()
+	return sent, received, r.err
+}
+
+type relay struct {
+	idleTimeout time.Duration
+	halfClose   bool
+	conns       []net.Conn
+
+	lastActive int64
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func (r *relay) pipe(dst, src net.Conn) int64 {
+	n, err := r.copy(dst, src)
+	if err != nil {
+		r.fail(err)
+		return n
+	}
+	if r.halfClose {
+		if err := dst.(closeWriter).CloseWrite(); err != nil {
+			r.fail(err)
+		}
+		return n
+	}
+	r.close()
+	return n
+}
+
+func (r *relay) copy(dst, src net.Conn) (written int64, err error) {
+	if r.idleTimeout <= 0 && isTCP(dst) && isTCP(src) {
+		// 没有空闲超时时交给 io.Copy, TCPConn 之间会走 splice/sendfile
+		return io.Copy(dst, src)
+	}
+
+	buffer := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buffer)
+	for {
+		if r.idleTimeout > 0 {
+			_ = src.SetReadDeadline(time.Now().Add(r.idleTimeout))
+		}
+		nr, er := src.Read(*buffer)
+		if nr > 0 {
+			r.touch()
+			nw, ew := dst.Write((*buffer)[:nr])
+			written += int64(nw)
+			if ew != nil {
+				return written, ew
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if er == nil {
+			continue
+		}
+		if er == io.EOF {
+			return written, nil
+		}
+		if isTimeout(er) && !r.idle() {
+			// 另一个方向仍有数据流动, 连接并未空闲
+			continue
+		}
+		return written, er
+	}
+}
+
+func (r *relay) touch() {
+	atomic.StoreInt64(&r.lastActive, time.Now().UnixNano())
+}
+
+func (r *relay) idle() bool {
+	last := time.Unix(0, atomic.LoadInt64(&r.lastActive))
+	return time.Since(last) >= r.idleTimeout
+}
+
+func (r *relay) fail(err error) {
+	r.mu.Lock()
+	closed := r.closed
+	if r.err == nil && !(closed && errors.Is(err, net.ErrClosed)) {
+		r.err = err
+	}
+	r.mu.Unlock()
+	r.close()
+}
+
+func (r *relay) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+}
+
+func supportsCloseWrite(conn net.Conn) bool {
+	_, ok := conn.(closeWriter)
+	return ok
+}
+
+func isTCP(conn net.Conn) bool {
+	_, ok := conn.(*net.TCPConn)
+	return ok
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
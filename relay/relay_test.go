@@ -0,0 +1,105 @@
+package relay
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, <-accepted
+}
+
+func TestRelayHalfClose(t *testing.T) {
+	client, local := tcpPair(t)
+	remote, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	type result struct {
+		sent, received int64
+		err            error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sent, received, err := Relay(local, remote, time.Second)
+		done <- result{sent, received, err}
+	}()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	client.(*net.TCPConn).CloseWrite()
+
+	// 客户端半关闭后, 服务端仍然可以回写数据
+	request, err := ioutil.ReadAll(server)
+	if err != nil || string(request) != "ping" {
+		t.Fatalf("server got %q, %v", request, err)
+	}
+	if _, err := server.Write([]byte("pong!")); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	response, err := ioutil.ReadAll(client)
+	if err != nil || string(response) != "pong!" {
+		t.Fatalf("client got %q, %v", response, err)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("relay error: %v", r.err)
+	}
+	if r.sent != 4 || r.received != 5 {
+		t.Errorf("sent = %d, received = %d, want 4, 5", r.sent, r.received)
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	client, local := net.Pipe()
+	remote, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := Relay(local, remote, 100*time.Millisecond)
+		done <- err
+	}()
+
+	go io.Copy(ioutil.Discard, server)
+	for i := 0; i < 3; i++ {
+		if _, err := client.Write([]byte("keepalive")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		if !isTimeout(err) {
+			t.Fatalf("expected timeout error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not time out")
+	}
+	if _, err := client.Write([]byte("late")); err == nil {
+		t.Error("expected closed connection after idle timeout")
+	}
+}
@@ -0,0 +1,95 @@
+package proxyclient
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/chainreactors/proxyclient/acl"
+)
+
+// Router 按认证用户名或客户端来源网段选择上游代理链,
+// Route 方法可以直接赋值给 socksproxy.SOCKSConf.Route 与 httpproxy.Handler.Route。
+// 匹配顺序: 用户名 > 来源网段(按添加顺序) > Default, 都未命中时返回 nil, 由服务端使用自身的 Dial。
+// 只有服务端认证通过后 acl.Request.User 才非空, SOCKS4 的 userid 不参与选择, 按来源网段与 Default 处理。
+type Router struct {
+	Users   map[string]Dial
+	Sources []SourceRoute
+	Default Dial
+}
+
+type SourceRoute struct {
+	Network *net.IPNet
+	Dial    Dial
+}
+
+// NewRouter 由 用户名 -> 代理 URL 链 的配置构造 Router, 空链表示直连
+func NewRouter(users map[string][]string) (*Router, error) {
+	router := &Router{Users: make(map[string]Dial, len(users))}
+	for user, chain := range users {
+		if err := router.AddUser(user, chain); err != nil {
+			return nil, err
+		}
+	}
+	return router, nil
+}
+
+func (r *Router) AddUser(user string, chain []string) error {
+	dial, err := newChainFromStrings(chain)
+	if err != nil {
+		return err
+	}
+	if r.Users == nil {
+		r.Users = make(map[string]Dial)
+	}
+	r.Users[user] = dial
+	return nil
+}
+
+// AddSource 为来源网段添加上游代理链, cidr 也可以是单个 IP
+func (r *Router) AddSource(cidr string, chain []string) error {
+	if !strings.Contains(cidr, "/") {
+		if ip := net.ParseIP(cidr); ip != nil && ip.To4() == nil {
+			cidr += "/128"
+		} else {
+			cidr += "/32"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	dial, err := newChainFromStrings(chain)
+	if err != nil {
+		return err
+	}
+	r.Sources = append(r.Sources, SourceRoute{Network: network, Dial: dial})
+	return nil
+}
+
+func (r *Router) Route(req *acl.Request) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if req.User != "" {
+		if dial, ok := r.Users[req.User]; ok {
+			return dial
+		}
+	}
+	if ip := req.ClientIP(); ip != nil {
+		for _, source := range r.Sources {
+			if source.Network.Contains(ip) {
+				return source.Dial
+			}
+		}
+	}
+	if r.Default != nil {
+		return r.Default
+	}
+	return nil
+}
+
+func newChainFromStrings(chain []string) (Dial, error) {
+	proxies, err := ParseProxyURLs(chain)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientChain(proxies)
+}
@@ -0,0 +1,256 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chainreactors/proxyclient/acl"
+	httpProxy "github.com/chainreactors/proxyclient/http"
+	socksProxy "github.com/chainreactors/proxyclient/socks"
+)
+
+func TestRouterOrder(t *testing.T) {
+	mark := func(name string) Dial {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New(name)
+		}
+	}
+	router := &Router{Users: map[string]Dial{"alice": mark("alice")}}
+	_, office, _ := net.ParseCIDR("10.0.0.0/8")
+	router.Sources = []SourceRoute{{Network: office, Dial: mark("office")}}
+	// 单个 IP 按 /32 处理, 排在 10.0.0.0/8 之后不会被使用
+	if err := router.AddSource("10.1.2.3", nil); err != nil {
+		t.Fatal(err)
+	}
+	if network := router.Sources[1].Network.String(); network != "10.1.2.3/32" {
+		t.Errorf("AddSource network = %s", network)
+	}
+
+	route := func(user, client string) string {
+		dial := router.Route(&acl.Request{User: user, Client: acl.ParseAddr(client)})
+		if dial == nil {
+			return "<nil>"
+		}
+		_, err := dial(context.Background(), "tcp", "127.0.0.1:1")
+		return err.Error()
+	}
+	for _, c := range []struct{ user, client, want string }{
+		{"alice", "10.1.2.3:1000", "alice"},
+		{"bob", "10.1.2.3:1000", "office"},
+		{"", "10.1.2.3:1000", "office"},
+		{"", "192.168.1.1:1000", "<nil>"},
+	} {
+		if got := route(c.user, c.client); got != c.want {
+			t.Errorf("Route(%q, %s) = %s, want %s", c.user, c.client, got, c.want)
+		}
+	}
+	router.Default = mark("default")
+	if got := route("bob", "192.168.1.1:1000"); got != "default" {
+		t.Errorf("Route with Default = %s", got)
+	}
+}
+
+// newBannerServer 启动写入 name 后关闭连接的服务, 用来区分流量走了哪个上游
+func newBannerServer(t *testing.T, name string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(name))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newUpstream 启动把所有连接转发到 backend 的 socks5 服务
+func newUpstream(t *testing.T, backend string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go socksProxy.Serve(listener, &socksProxy.SOCKSConf{
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, backend)
+		},
+	})
+	return "socks5://" + listener.Addr().String()
+}
+
+func readBanner(t *testing.T, rawURL, target string) string {
+	proxy, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial, err := NewClient(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("%s: %v", rawURL, err)
+	}
+	defer conn.Close()
+	banner, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("%s: %v", rawURL, err)
+	}
+	return string(banner)
+}
+
+func TestRouterServers(t *testing.T) {
+	target := newBannerServer(t, "direct")
+	router, err := NewRouter(map[string][]string{
+		"alice": {newUpstream(t, newBannerServer(t, "team-a"))},
+		"bob":   {newUpstream(t, newBannerServer(t, "team-b"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAuth := func(username, password string) bool {
+		return password == "secret"
+	}
+
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	go socksProxy.Serve(socks, &socksProxy.SOCKSConf{Auth: checkAuth, Dial: DefaultDial, Route: router.Route})
+	http, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer http.Close()
+	go httpProxy.ServeHandler(http, httpProxy.Handler{Auth: checkAuth, Dial: net.Dial, Route: router.Route})
+
+	for _, c := range []struct{ url, want string }{
+		{"socks5://alice:secret@" + socks.Addr().String(), "team-a"},
+		{"socks5://bob:secret@" + socks.Addr().String(), "team-b"},
+		{"socks5://carol:secret@" + socks.Addr().String(), "direct"},
+		{"http://alice:secret@" + http.Addr().String(), "team-a"},
+		{"http://bob:secret@" + http.Addr().String(), "team-b"},
+	} {
+		if got := readBanner(t, c.url, target); got != c.want {
+			t.Errorf("%s: reached %q, want %q", c.url, got, c.want)
+		}
+	}
+
+	// SOCKS4 的 userid 没有经过认证, 不能选择 alice 的上游
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	go socksProxy.Serve(open, &socksProxy.SOCKSConf{Dial: DefaultDial, Route: router.Route})
+	conn, err := net.Dial("tcp", open.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	host, port, _ := net.SplitHostPort(target)
+	portNum, _ := net.LookupPort("tcp", port)
+	request := []byte{4, 1, byte(portNum >> 8), byte(portNum)}
+	request = append(request, net.ParseIP(host).To4()...)
+	request = append(request, "alice\x00"...)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 90 {
+		t.Fatalf("socks4 reply %v, %v", reply, err)
+	}
+	if banner, _ := io.ReadAll(conn); string(banner) != "direct" {
+		t.Errorf("socks4 userid alice reached %q, want direct", banner)
+	}
+}
+
+// TestRouterContext 路由选出的上游收到连接或请求的 context, 处理结束后被取消
+func TestRouterContext(t *testing.T) {
+	target := newBannerServer(t, "direct")
+	contexts := make(chan context.Context, 1)
+	route := func(*acl.Request) func(ctx context.Context, network, address string) (net.Conn, error) {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			contexts <- ctx
+			return DefaultDial(ctx, network, address)
+		}
+	}
+
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	go socksProxy.Serve(socks, &socksProxy.SOCKSConf{Dial: DefaultDial, Route: route})
+	http, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer http.Close()
+	go httpProxy.ServeHandler(http, httpProxy.Handler{Dial: net.Dial, Route: route})
+
+	for _, rawURL := range []string{"socks5://" + socks.Addr().String(), "http://" + http.Addr().String()} {
+		if got := readBanner(t, rawURL, target); got != "direct" {
+			t.Fatalf("%s: reached %q", rawURL, got)
+		}
+		ctx := <-contexts
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Errorf("%s: dial context not canceled after the connection ended", rawURL)
+		}
+	}
+}
+
+// TestRouterResolved 策略解析域名并检查过的 IP 就是路由上游拨号的地址, 不会再次解析
+func TestRouterResolved(t *testing.T) {
+	target := newBannerServer(t, "direct")
+	_, port, _ := net.SplitHostPort(target)
+	policy, err := acl.ParseRuleSet([]string{"allow cidr=127.0.0.0/8"}, acl.Deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := make(chan string, 1)
+	route := func(*acl.Request) func(ctx context.Context, network, address string) (net.Conn, error) {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			addresses <- address
+			return DefaultDial(ctx, network, address)
+		}
+	}
+
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	go socksProxy.Serve(socks, &socksProxy.SOCKSConf{Dial: DefaultDial, Route: route, Policy: policy.Allow})
+	http, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer http.Close()
+	go httpProxy.ServeHandler(http, httpProxy.Handler{Dial: net.Dial, Route: route, Policy: policy.Allow})
+
+	for _, rawURL := range []string{"socks5://" + socks.Addr().String(), "http://" + http.Addr().String()} {
+		if got := readBanner(t, rawURL, net.JoinHostPort("localhost", port)); got != "direct" {
+			t.Fatalf("%s: reached %q", rawURL, got)
+		}
+		if address := <-addresses; !strings.HasPrefix(address, "127.") {
+			t.Errorf("%s: dialed %s instead of the checked address", rawURL, address)
+		}
+	}
+}
@@ -16,8 +16,8 @@ func NewClient(proxy *url.URL, conf *SOCKSConf) (client Client, err error) {
 	switch strings.ToUpper(proxy.Scheme) {
 	case "SOCKS4", "SOCKS4A":
 		client = &Socks4Client{proxy, conf}
-	case "SOCKS5", "SOCKS5+TLS":
-		client = &Socks5Client{proxy, conf, conf.TLSConfig}
+	case "SOCKS5":
+		client = &Socks5Client{proxy, conf}
 	default:
 		err = fmt.Errorf("%s not supported", proxy.Scheme)
 	}
@@ -2,6 +2,7 @@ package socksproxy
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -11,9 +12,8 @@ import (
 )
 
 type Socks5Client struct {
-	proxy     *url.URL
-	conf      *SOCKSConf
-	tlsConfig *tls.Config
+	proxy *url.URL
+	conf  *SOCKSConf
 }
 
 func (c *Socks5Client) Dial(ctx context.Context, network, address string) (remoteConn net.Conn, err error) {
@@ -37,8 +37,8 @@ func (c *Socks5Client) Dial(ctx context.Context, network, address string) (remot
 		return
 	}
 	if c.isTLS() {
-		tlsConn := tls.Client(remoteConn, c.tlsConfig)
-		if err = tlsConn.Handshake(); err != nil {
+		tlsConn := tls.Client(remoteConn, c.conf.TLSConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
 			tlsConn.Close()
 			return
 		}
@@ -64,12 +64,14 @@ func (c *Socks5Client) handshake(conn net.Conn) (err error) {
 	if c.proxy.User != nil && c.proxy.User.Username() != "" {
 		method = socks5AuthMethodPassword
 	}
-	if c.isTLS() {
-		method += 0x80
+	methods := []byte{method}
+	if isTLSConn(conn) {
+		// 旧版服务端在 TLS 连接上只接受 0x80/0x82, 同时提供标准方法以兼容其他服务端
+		methods = []byte{method + 0x80, method}
 	}
 	request := &socks5InitialRequest{
 		version: socks5version,
-		methods: []byte{method},
+		methods: methods,
 	}
 
 	if _, err = conn.Write(request.ToPacket()); err != nil {
@@ -89,7 +91,8 @@ func (c *Socks5Client) handshake(conn net.Conn) (err error) {
 		return
 	}
 	switch {
-	case auth == 2 && method == socks5AuthMethodPassword:
+	case auth == socks5AuthMethodNoRequired || auth == socks5AuthMethodTLSNoRequired:
+	case (auth == socks5AuthMethodPassword || auth == socks5AuthMethodTLSPassword) && bytes.IndexByte(methods, auth) >= 0:
 		passed, err := c.passwordAuth(conn)
 		if err != nil {
 			return err
@@ -97,7 +100,7 @@ func (c *Socks5Client) handshake(conn net.Conn) (err error) {
 		if !passed {
 			err = errors.New("password authentication failed.")
 		}
-	case auth != 0 && method == socks5AuthMethodNoRequired:
+	default:
 		err = errors.New("socks method negotiation failed.")
 	}
 	return
@@ -180,6 +183,14 @@ func (c *Socks5Client) handleUDPAssociate(conn net.Conn) (err error) {
 	return
 }
 
+// isTLS 为 true 时在连接代理后进行 TLS 握手;
+// 通过 tls 承载层 (socks5+tls://) 连接时 TLSConfig 为空, 由承载层完成握手
 func (c *Socks5Client) isTLS() bool {
-	return strings.ToUpper(c.proxy.Scheme) == "SOCKS5+TLS"
+	return c.conf.TLSConfig != nil
+}
+
+// isTLSConn 判断到代理服务器的连接是否为 TLS, 决定是否提供 TLS 认证方法
+func isTLSConn(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
 }
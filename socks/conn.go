@@ -1,23 +1,44 @@
 package socksproxy
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"io"
 	"net"
+	"time"
+
+	"github.com/chainreactors/proxyclient/acl"
+	"github.com/chainreactors/proxyclient/auth"
+	"github.com/chainreactors/proxyclient/proxyproto"
 )
 
+type dialFunc = func(ctx context.Context, network, address string) (net.Conn, error)
+
 type SOCKSConf struct {
 	Auth        func(username, password string) bool
 	Dial        func(ctx context.Context, network, address string) (net.Conn, error)
 	HandleError func(error)
 	TLSConfig   *tls.Config
+	IdleTimeout time.Duration
+	// Policy 放行或拒绝请求, SOCKS4 的 userid 未经认证, 其 Request.User 始终为空,
+	// 因此 SOCKS4 客户端不会命中限定 user 的规则
+	Policy acl.Policy
+	// Route 按认证用户与客户端地址选择上游, 返回 nil 时使用 Dial
+	Route func(*acl.Request) func(ctx context.Context, network, address string) (net.Conn, error)
+	// Limiter 按客户端 IP 限制认证失败频率
+	Limiter *auth.Limiter
+	// ProxyProtocol 为 true 时要求每个连接以 PROXY 协议头 (v1/v2) 开始, ACL 与日志使用其中的真实客户端地址
+	ProxyProtocol bool
 }
 
 func Serve(listener net.Listener, conf *SOCKSConf) {
 	if conf.HandleError == nil {
 		conf.HandleError = func(_ error) {}
 	}
+	if conf.ProxyProtocol {
+		listener = proxyproto.NewListener(listener)
+	}
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -28,6 +49,44 @@ func Serve(listener net.Listener, conf *SOCKSConf) {
 	}
 }
 
+func (conf *SOCKSConf) checkAuth(client net.Addr, username, password string) bool {
+	if conf.Limiter == nil {
+		return conf.Auth(username, password)
+	}
+	if !conf.Limiter.Allow(client) {
+		return false
+	}
+	if !conf.Auth(username, password) {
+		conf.Limiter.Fail(client)
+		return false
+	}
+	conf.Limiter.Success(client)
+	return true
+}
+
+func (conf *SOCKSConf) allow(r *acl.Request) bool {
+	if conf.Policy == nil {
+		return true
+	}
+	return r != nil && conf.Policy(r)
+}
+
+// route 返回本次请求使用的 dial, Policy 解析并检查过目标域名时拨号检查过的 IP
+func (conf *SOCKSConf) route(r *acl.Request) dialFunc {
+	dial := conf.Dial
+	if conf.Route != nil && r != nil {
+		if routed := conf.Route(r); routed != nil {
+			dial = routed
+		}
+	}
+	if r == nil || r.Resolved == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dial(ctx, network, r.DialAddress(address))
+	}
+}
+
 func IsSOCKS(r io.Reader) bool {
 	header := make([]byte, 1)
 	if _, err := r.Read(header); err != nil {
@@ -43,18 +102,30 @@ func handleConn(conn net.Conn, conf *SOCKSConf) {
 		conf.HandleError(err)
 		return
 	}
+	if conf.TLSConfig != nil {
+		// TLS 握手在 SOCKS 协商之前, 放回已读取的字节后握手, 再读取 SOCKS 版本
+		if buffer[0] != tlsRecordHandshake {
+			conn.Close()
+			return
+		}
+		conn = tls.Server(&bufferedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(buffer), conn)}, conf.TLSConfig)
+		if _, err = io.ReadFull(conn, buffer); err != nil {
+			conf.HandleError(err)
+			return
+		}
+	}
+	// 路由选出的上游使用连接的 context, 连接处理结束时取消仍在进行的拨号
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	switch buffer[0] {
 	case socks4version:
 		if conf.Auth != nil || conf.TLSConfig != nil {
 			return
 		}
-		socksConn := &socks4Conn{conn, conf}
+		socksConn := &socks4Conn{ctx: ctx, localConn: conn, conf: conf}
 		err = socksConn.Serve()
 	case socks5version:
-		if conf.TLSConfig != nil {
-			conn = tls.Server(conn, conf.TLSConfig)
-		}
-		socksConn := &socks5Conn{conn, conf}
+		socksConn := &socks5Conn{ctx: ctx, localConn: conn, conf: conf}
 		err = socksConn.Serve()
 	}
 	if err != nil {
@@ -62,3 +133,13 @@ func handleConn(conn net.Conn, conf *SOCKSConf) {
 		return
 	}
 }
+
+// bufferedConn 把已经读取的数据放回连接
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
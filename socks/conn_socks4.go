@@ -2,11 +2,14 @@ package socksproxy
 
 import (
 	"context"
-	"io"
 	"net"
+
+	"github.com/chainreactors/proxyclient/acl"
+	"github.com/chainreactors/proxyclient/relay"
 )
 
 type socks4Conn struct {
+	ctx       context.Context
 	localConn net.Conn
 	conf      *SOCKSConf
 }
@@ -17,10 +20,15 @@ func (c *socks4Conn) Serve() (err error) {
 		c.sendReply(request, socks4StatusRejected)
 		return err
 	}
+	r := c.aclRequest(request)
+	if !c.conf.allow(r) {
+		c.sendReply(request, socks4StatusRejected)
+		return acl.ErrDenied
+	}
 	switch request.command {
 	case commandConnect:
 		c.sendReply(request, socks4StatusGranted)
-		err = c.handleConnect(request.Address())
+		err = c.handleConnect(request.Address(), c.conf.route(r))
 	default:
 		err = errCommandNotSupported
 	}
@@ -30,16 +38,24 @@ func (c *socks4Conn) Serve() (err error) {
 	return
 }
 
-func (c *socks4Conn) handleConnect(host string) (err error) {
-	remoteConn, err := c.conf.Dial(context.Background(), "tcp", host)
+func (c *socks4Conn) handleConnect(host string, dial dialFunc) (err error) {
+	remoteConn, err := dial(c.ctx, "tcp", host)
 	if err != nil {
 		return err
 	}
-	go io.Copy(c.localConn, remoteConn)
-	go io.Copy(remoteConn, c.localConn)
+	_, _, err = relay.Relay(c.localConn, remoteConn, c.conf.IdleTimeout)
 	return
 }
 
+// aclRequest 不使用 SOCKS4 的 userid: 它没有密码, 任何客户端都可以冒充其他用户
+func (c *socks4Conn) aclRequest(request *socks4Request) *acl.Request {
+	r, err := acl.NewRequest("", c.localConn.RemoteAddr(), acl.CommandConnect, request.Address())
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
 func (c *socks4Conn) sendReply(request *socks4Request, status byte) {
 	response := &socks4Response{
 		status: status,
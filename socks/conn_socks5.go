@@ -4,14 +4,18 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"io"
 	"net"
 	"syscall"
+
+	"github.com/chainreactors/proxyclient/acl"
+	"github.com/chainreactors/proxyclient/relay"
 )
 
 type socks5Conn struct {
+	ctx       context.Context
 	localConn net.Conn
 	conf      *SOCKSConf
+	user      string
 }
 
 func (c *socks5Conn) Serve() (err error) {
@@ -34,11 +38,16 @@ func (c *socks5Conn) Serve() (err error) {
 	if request.version != socks5version {
 		return errVersionError
 	}
+	r := c.aclRequest(request)
+	if !c.conf.allow(r) {
+		c.sendReply(request, socks5StatusNotAllowed)
+		return acl.ErrDenied
+	}
 	switch request.command {
 	case commandConnect:
-		err = c.handleConnect(request)
+		err = c.handleConnect(request, c.conf.route(r))
 	case commandUDPAssociate:
-		err = c.handleUDPAssociate(request)
+		err = c.handleUDPAssociate(request, c.conf.route(r))
 	default:
 		c.sendReply(request, socks5StatusCommandNotSupported)
 		return errCommandNotSupported
@@ -46,25 +55,23 @@ func (c *socks5Conn) Serve() (err error) {
 	return
 }
 
-func (c *socks5Conn) handleConnect(request *socks5Request) (err error) {
+func (c *socks5Conn) handleConnect(request *socks5Request, dial dialFunc) (err error) {
 	c.sendReply(request, socks5StatusSucceeded)
-	remoteConn, err := c.conf.Dial(context.Background(), "tcp", request.Address())
+	remoteConn, err := dial(c.ctx, "tcp", request.Address())
 	if c.sendReplyWithError(request, err) {
 		return
 	}
-	go io.Copy(c.localConn, remoteConn)
-	go io.Copy(remoteConn, c.localConn)
+	_, _, err = relay.Relay(c.localConn, remoteConn, c.conf.IdleTimeout)
 	return
 }
 
-func (c *socks5Conn) handleUDPAssociate(request *socks5Request) (err error) {
+func (c *socks5Conn) handleUDPAssociate(request *socks5Request, dial dialFunc) (err error) {
 	c.sendUDPReply(request)
-	remoteConn, err := c.conf.Dial(context.Background(), "udp", request.Address())
+	remoteConn, err := dial(c.ctx, "udp", request.Address())
 	if c.sendReplyWithError(request, err) {
 		return err
 	}
-	go io.Copy(c.localConn, remoteConn)
-	go io.Copy(remoteConn, c.localConn)
+	_, _, err = relay.Relay(c.localConn, remoteConn, c.conf.IdleTimeout)
 	return
 }
 
@@ -89,8 +96,9 @@ func (c *socks5Conn) handshake() (err error) {
 }
 
 func (c *socks5Conn) authBasedPassword(methods []byte) (err error) {
+	// TLS 连接同时接受标准的密码认证与本项目客户端使用的 TLS 密码认证
 	method := socks5AuthMethodPassword
-	if c.isTLS() {
+	if c.isTLS() && bytes.Contains(methods, []byte{socks5AuthMethodTLSPassword}) {
 		method = socks5AuthMethodTLSPassword
 	}
 	if !bytes.Contains(methods, []byte{method}) {
@@ -120,21 +128,41 @@ func (c *socks5Conn) authBasedPassword(methods []byte) (err error) {
 	if _, err = reader.Read(password); err != nil {
 		return
 	}
-	if c.conf.Auth(string(username), string(password)) {
+	if !c.conf.checkAuth(c.localConn.RemoteAddr(), string(username), string(password)) {
 		c.localConn.Write([]byte{0x01, 0x01})
-	} else {
-		c.localConn.Write([]byte{0x01, 0x00})
+		c.localConn.Close()
+		return errAuthFailed
 	}
+	c.user = string(username)
+	c.localConn.Write([]byte{0x01, 0x00})
 	return
 }
 
+func (c *socks5Conn) aclRequest(request *socks5Request) *acl.Request {
+	command := acl.CommandConnect
+	if request.command == commandUDPAssociate {
+		command = acl.CommandAssociate
+	}
+	r, err := acl.NewRequest(c.user, c.localConn.RemoteAddr(), command, request.Address())
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
 func (c *socks5Conn) sendReply(request *socks5Request, status byte) {
 	reply := []byte{socks5version, status, 0x00}
-	hostName, hostPort, _ := splitHostPort(c.localConn.LocalAddr().String())
+	// 隧道连接的地址不是 IP:port 时回复 0.0.0.0:0
+	hostName, hostPort, err := splitHostPort(c.localConn.LocalAddr().String())
 	ip := net.ParseIP(string(hostName))
-	addrType := socks5AddressTypeIPv4
-	if ip.To16() != nil {
-		addrType = socks5AddressTypeIPv6
+	if err != nil || ip == nil {
+		ip, hostPort = net.IPv4zero, []byte{0, 0}
+	}
+	addrType := socks5AddressTypeIPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		addrType, ip = socks5AddressTypeIPv4, ip4
+	} else {
+		ip = ip.To16()
 	}
 	reply = append(reply, addrType)
 	reply = append(reply, ip...)
@@ -18,6 +18,7 @@ const (
 
 	socks5StatusSucceeded               byte = 0
 	socks5StatusGeneral                 byte = 1
+	socks5StatusNotAllowed              byte = 2
 	socks5StatusHostUnreachable         byte = 4
 	socks5StatusConnectionRefused       byte = 5
 	socks5StatusCommandNotSupported     byte = 7
@@ -28,6 +29,9 @@ const (
 	socks5AuthMethodTLSNoRequired byte = 0x80
 	socks5AuthMethodTLSPassword   byte = 0x82
 	socks5AuthMethodNoAcceptable  byte = 0xFF
+
+	// tlsRecordHandshake 为 TLS ClientHello 记录的首字节
+	tlsRecordHandshake byte = 0x16
 )
 
 var (
@@ -35,4 +39,5 @@ var (
 	errCommandNotSupported     = errors.New("command not supported")
 	errAddressTypeNotSupported = errors.New("address type not supported")
 	errAuthMethodNotSupported  = errors.New("authentication method not supported")
+	errAuthFailed              = errors.New("authentication failed")
 )
@@ -71,18 +71,26 @@ func readSocks4Request(conn net.Conn) (request *socks4Request, err error) {
 	if _, err = reader.Read(request.ip); err != nil {
 		return
 	}
-	if request.userId, err = reader.ReadBytes(0); err != nil {
+	if request.userId, err = readNullTerminated(reader); err != nil {
 		return
 	}
 	if !request.IsSOCKS4A() {
 		return
 	}
-	if request.fqdn, err = reader.ReadBytes(0); err != nil {
+	if request.fqdn, err = readNullTerminated(reader); err != nil {
 		return
 	}
 	return
 }
 
+func readNullTerminated(reader *bufio.Reader) ([]byte, error) {
+	data, err := reader.ReadBytes(0)
+	if err != nil {
+		return nil, err
+	}
+	return data[:len(data)-1], nil
+}
+
 // endregion
 
 // region SOCKS5
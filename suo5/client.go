@@ -0,0 +1,253 @@
+package suo5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+	"github.com/zema1/suo5/netrans"
+	"github.com/zema1/suo5/suo5"
+)
+
+// checkTimeout 为探测连接模式时等待回显的时间, 超过该时间才收到回显说明响应被缓冲, 只能使用半双工
+var checkTimeout = 3 * time.Second
+
+// Init 创建 HTTP 客户端并探测服务端支持的连接模式, 已完成时直接返回。
+// 首次 DialContext 时自动调用, 因此在此之前可以替换 Dial 与 TLSConfig。
+// 所有请求经由 Dial 发出, 并共享同一个 keep-alive 连接池。ctx 取消时中断探测, 下次调用重新探测
+func (conf *Suo5Conf) Init(ctx context.Context) error {
+	conf.initMu.Lock()
+	defer conf.initMu.Unlock()
+	if conf.Suo5Client != nil {
+		return nil
+	}
+
+	config := conf.Suo5Config
+	if err := config.Parse(); err != nil {
+		return err
+	}
+	if config.DisableGzip {
+		config.Header.Set("Accept-Encoding", "identity")
+	}
+	if config.RedirectURL != "" {
+		if _, err := url.Parse(config.RedirectURL); err != nil {
+			return fmt.Errorf("failed to parse redirect url, %s", err)
+		}
+	}
+
+	// 全双工的响应是持续的数据流, 关闭透明解压, 避免数据被缓冲
+	transport := &http.Transport{
+		DialContext:         conf.dial,
+		DialTLSContext:      conf.dialTLS,
+		DisableCompression:  true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	var jar http.CookieJar
+	if config.EnableCookieJar {
+		jar, _ = cookiejar.New(nil)
+	} else {
+		// 与 suo5 一致, PHP 站点自动启用 cookiejar
+		jar = suo5.NewSwitchableCookieJar([]string{"PHPSESSID"})
+	}
+	client := &suo5.Suo5Client{
+		Config: config,
+		NormalClient: &http.Client{
+			Timeout:   time.Duration(config.Timeout) * time.Second,
+			Jar:       jar,
+			Transport: transport,
+		},
+		NoTimeoutClient: &http.Client{
+			Jar:       jar,
+			Transport: transport,
+		},
+	}
+
+	mode, offset, err := checkConnectMode(ctx, client)
+	if err != nil {
+		return err
+	}
+	if config.Mode == suo5.AutoDuplex {
+		config.Mode = mode
+	} else if mode == suo5.HalfDuplex && config.Mode == suo5.FullDuplex {
+		return fmt.Errorf("the target doesn't support full duplex, you should use half or auto mode")
+	}
+	config.Offset = offset
+
+	conf.Suo5Client = client
+	return nil
+}
+
+func (conf *Suo5Conf) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if conf.Dial != nil {
+		return conf.Dial(ctx, network, addr)
+	}
+	return (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, network, addr)
+}
+
+// dialTLS 经由 Dial 建立连接后进行 TLS 握手, 保留 suo5 随机化的 ClientHello 指纹, 证书校验遵循 TLSConfig
+func (conf *Suo5Conf) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := conf.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	tlsConfig := &utls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		Renegotiation:      utls.RenegotiateOnceAsClient,
+		MinVersion:         utls.VersionTLS10,
+	}
+	if conf.TLSConfig != nil {
+		if conf.TLSConfig.ServerName != "" {
+			tlsConfig.ServerName = conf.TLSConfig.ServerName
+		}
+		tlsConfig.InsecureSkipVerify = conf.TLSConfig.InsecureSkipVerify
+		tlsConfig.RootCAs = conf.TLSConfig.RootCAs
+	}
+	tlsConn := utls.UClient(conn, tlsConfig, utls.HelloRandomizedNoALPN)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// checkConnectMode 发送一段随机数据, 服务端原样返回。
+// 在 checkTimeout 内收到回显说明请求体可以流式传输, 支持全双工; 返回值 offset 为回显在响应中的偏移
+func checkConnectMode(ctx context.Context, client *suo5.Suo5Client) (suo5.ConnectionType, int, error) {
+	config := client.Config
+	randLen := rand.Intn(1024)
+	if randLen <= 32 {
+		randLen += 32
+	}
+	data := suo5.RandString(randLen)
+	ch := make(chan []byte, 1)
+	ch <- []byte(data)
+	req, err := http.NewRequestWithContext(ctx, config.Method, config.Target, netrans.NewChannelReader(ch))
+	if err != nil {
+		return suo5.Undefined, 0, err
+	}
+	req.Header = config.Header.Clone()
+	req.Header.Set(suo5.HeaderKey, suo5.HeaderValueChecking)
+
+	now := time.Now()
+	go func() {
+		select {
+		case <-time.After(checkTimeout):
+		case <-ctx.Done():
+		}
+		close(ch)
+	}()
+	resp, err := (&http.Client{Timeout: 5 * time.Second, Transport: client.NoTimeoutClient.Transport}).Do(req)
+	if err != nil {
+		return suo5.Undefined, 0, err
+	}
+	defer resp.Body.Close()
+
+	// 有时虽然读到 EOF 但数据是完整的, 因此不直接返回错误
+	body, _ := io.ReadAll(resp.Body)
+	offset := strings.Index(string(body), data[:32])
+	if offset == -1 {
+		return suo5.Undefined, 0, fmt.Errorf("got unexpected body, remote server test failed")
+	}
+	if time.Since(now) < checkTimeout {
+		return suo5.FullDuplex, offset, nil
+	}
+	return suo5.HalfDuplex, offset, nil
+}
+
+// open 发送 CREATE 请求并返回双向数据流, 请求的生命周期由 ctx 控制。
+// 与 suo5.Suo5Conn.Connect 相同, 但全双工也使用共享连接池的 net/http 客户端, 使 Dial 与 TLSConfig 生效
+func open(ctx context.Context, client *suo5.Suo5Client, address string) (io.ReadWriteCloser, error) {
+	config := client.Config
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	uport, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", port)
+	}
+	id := suo5.RandString(8)
+	dialData := suo5.BuildBody(suo5.NewActionCreate(id, host, uint16(uport), config.RedirectURL))
+	header := config.Header.Clone()
+
+	var req *http.Request
+	var ch chan []byte
+	var chWR io.WriteCloser
+	if config.Mode == suo5.FullDuplex {
+		ch, chWR = netrans.NewChannelWriteCloser(ctx)
+		body := netrans.MultiReadCloser(
+			io.NopCloser(bytes.NewReader(dialData)),
+			io.NopCloser(netrans.NewChannelReader(ch)),
+		)
+		req, err = http.NewRequestWithContext(ctx, config.Method, config.Target, body)
+		header.Set(suo5.HeaderKey, suo5.HeaderValueFull)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, config.Method, config.Target, bytes.NewReader(dialData))
+		header.Set(suo5.HeaderKey, suo5.HeaderValueHalf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := client.NoTimeoutClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", suo5.ErrHostUnreachable, err)
+	}
+	if err := readCreateResponse(resp.Body, config.Offset); err != nil {
+		resp.Body.Close()
+		if chWR != nil {
+			chWR.Close()
+		}
+		return nil, err
+	}
+
+	var rw io.ReadWriteCloser
+	if config.Mode == suo5.FullDuplex {
+		rw = suo5.NewFullChunkedReadWriter(id, chWR, resp.Body)
+	} else {
+		rw = suo5.NewHalfChunkedReadWriter(ctx, id, client.NormalClient, config.Method, config.Target,
+			resp.Body, header, config.RedirectURL)
+	}
+	if !config.DisableHeartbeat {
+		rw = suo5.NewHeartbeatRW(rw.(suo5.RawReadWriteCloser), id, config.RedirectURL)
+	}
+	return rw, nil
+}
+
+// readCreateResponse 跳过响应前缀并检查 CREATE 的结果
+func readCreateResponse(body io.Reader, offset int) error {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, body, int64(offset)); err != nil {
+			return fmt.Errorf("%w: %s", suo5.ErrDialFailed, err)
+		}
+	}
+	fr, err := netrans.ReadFrame(body)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read response frame, %s", suo5.ErrHostUnreachable, err)
+	}
+	m, err := suo5.Unmarshal(fr.Data)
+	if err != nil {
+		return fmt.Errorf("%w: %s", suo5.ErrHostUnreachable, err)
+	}
+	if status := m["s"]; len(status) != 1 || status[0] != 0x00 {
+		return fmt.Errorf("%w: failed to dial, status: %v", suo5.ErrHostUnreachable, status)
+	}
+	return nil
+}
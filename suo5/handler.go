@@ -0,0 +1,234 @@
+package suo5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zema1/suo5/netrans"
+	"github.com/zema1/suo5/suo5"
+)
+
+// DefaultDialTimeout 为服务端连接目标的超时时间
+var DefaultDialTimeout = 5 * time.Second
+
+// Handler 为 Go 实现的 suo5 服务端, 与 suo5.jsp 使用相同的帧格式与 Content-Type 约定:
+//   - application/plain 为探测请求, 原样返回读到的数据
+//   - application/octet-stream 为全双工, 一个请求体与响应体持续双向传输
+//   - application/x-binary 为半双工, CREATE 的响应持续下发数据, 上行数据由单独的请求发送
+//
+// 其他请求交给 NotFound 处理。
+type Handler struct {
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Redirect 用于把带有 r 字段的请求转发给下一层 suo5
+	Redirect *http.Client
+	NotFound http.Handler
+
+	mu       sync.Mutex
+	sessions map[string]net.Conn
+}
+
+// NewHandler 创建 suo5 服务端
+func NewHandler() *Handler {
+	return &Handler{
+		Dial:     (&net.Dialer{Timeout: DefaultDialTimeout}).DialContext,
+		Redirect: &http.Client{},
+		NotFound: http.NotFoundHandler(),
+		sessions: make(map[string]net.Conn),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 响应开始后仍需读取请求体
+	http.NewResponseController(w).EnableFullDuplex()
+	switch r.Header.Get(suo5.HeaderKey) {
+	case suo5.HeaderValueChecking:
+		h.check(w, r)
+	case suo5.HeaderValueFull, suo5.HeaderValueHalf:
+		h.tunnel(w, r)
+	default:
+		h.NotFound.ServeHTTP(w, r)
+	}
+}
+
+// Close 关闭所有目标连接
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, conn := range h.sessions {
+		conn.Close()
+		delete(h.sessions, id)
+	}
+	return nil
+}
+
+// check 只返回第一段数据而不等待请求体结束, 请求体被中间设备缓冲时客户端会在超时后才收到响应
+func (h *Handler) check(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 4096)
+	n, _ := io.ReadAtLeast(r.Body, buf, 32)
+	w.Write(buf[:n])
+}
+
+func (h *Handler) tunnel(w http.ResponseWriter, r *http.Request) {
+	fr, err := netrans.ReadFrame(r.Body)
+	if err != nil {
+		h.NotFound.ServeHTTP(w, r)
+		return
+	}
+	m, err := suo5.Unmarshal(fr.Data)
+	if err != nil || len(m["ac"]) != 1 {
+		h.NotFound.ServeHTTP(w, r)
+		return
+	}
+	if target := string(m["r"]); target != "" && h.Redirect != nil {
+		h.redirect(w, r, target, m)
+		return
+	}
+
+	id := string(m["id"])
+	switch m["ac"][0] {
+	case suo5.ActionCreate:
+		conn, err := h.Dial(r.Context(), "tcp", net.JoinHostPort(string(m["h"]), string(m["p"])))
+		if err != nil {
+			w.Write(suo5.BuildBody(map[string][]byte{"s": {0x01}}))
+			return
+		}
+		h.mu.Lock()
+		if h.sessions == nil {
+			h.sessions = make(map[string]net.Conn)
+		}
+		if old := h.sessions[id]; old != nil {
+			old.Close()
+		}
+		h.sessions[id] = conn
+		h.mu.Unlock()
+		defer h.remove(id)
+
+		w.Write(suo5.BuildBody(map[string][]byte{"s": {0x00}}))
+		w.(http.Flusher).Flush()
+		if r.Header.Get(suo5.HeaderKey) == suo5.HeaderValueFull {
+			go h.upstream(id, conn, r.Body)
+		}
+		h.downstream(id, conn, w)
+	case suo5.ActionData, suo5.ActionDelete, suo5.ActionHeartbeat:
+		// 半双工的上行请求, 一个请求体可以包含多个帧
+		conn := h.session(id)
+		if conn == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !h.handleFrame(id, conn, m) {
+			return
+		}
+		h.upstream(id, conn, r.Body)
+	default:
+		h.NotFound.ServeHTTP(w, r)
+	}
+}
+
+func (h *Handler) session(id string) net.Conn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+func (h *Handler) remove(id string) {
+	h.mu.Lock()
+	conn := h.sessions[id]
+	delete(h.sessions, id)
+	h.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// handleFrame 处理客户端发来的一个帧, 返回 false 表示会话已结束
+func (h *Handler) handleFrame(id string, conn net.Conn, m map[string][]byte) bool {
+	if len(m["ac"]) != 1 {
+		return true
+	}
+	switch m["ac"][0] {
+	case suo5.ActionData:
+		if _, err := conn.Write(m["dt"]); err != nil {
+			h.remove(id)
+			return false
+		}
+	case suo5.ActionDelete:
+		h.remove(id)
+		return false
+	}
+	return true
+}
+
+// upstream 把请求体中的帧写入目标连接, 直到请求体结束或会话关闭
+func (h *Handler) upstream(id string, conn net.Conn, body io.Reader) {
+	for {
+		fr, err := netrans.ReadFrame(body)
+		if err != nil {
+			return
+		}
+		m, err := suo5.Unmarshal(fr.Data)
+		if err != nil {
+			continue
+		}
+		if !h.handleFrame(id, conn, m) {
+			return
+		}
+	}
+}
+
+// downstream 把目标数据编码为帧写入响应, 目标关闭时发送 DELETE
+func (h *Handler) downstream(id string, conn net.Conn, w http.ResponseWriter) {
+	flusher := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(suo5.BuildBody(suo5.NewActionData(id, buf[:n], ""))); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			w.Write(suo5.BuildBody(suo5.NewDelete(id, "")))
+			return
+		}
+	}
+}
+
+// redirect 去掉 r 字段后把请求转发给下一层 suo5, 并持续返回其响应
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, target string, m map[string][]byte) {
+	delete(m, "r")
+	body := io.MultiReader(bytes.NewReader(suo5.BuildBody(m)), r.Body)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	req.Header = r.Header.Clone()
+	resp, err := h.Redirect.Do(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+	flusher := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -2,25 +2,62 @@ package suo5
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/chainreactors/proxyclient/tunnel"
 	"github.com/zema1/suo5/suo5"
+	"io"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// addrNetwork 为 LocalAddr/RemoteAddr 的 Network()
+const addrNetwork = "suo5"
+
 type Suo5Client struct {
 	Proxy *url.URL
 	Conf  *Suo5Conf
 }
 
+var (
+	DefaultMaxRetry       = 3
+	DefaultRetryInterval  = 100 * time.Millisecond
+	DefaultReadBufferSize = 64 * 1024
+	DefaultWriteQueueSize = 8
+)
+
+// errWriteTimeout 为数据在 Suo5Config.Timeout 内未能发出时返回的错误
+var errWriteTimeout = errors.New("suo5 write timeout")
+
+// queryParams 为支持的 URL 参数, 其他参数会被拒绝, 避免拼写错误时静默使用默认值
+var queryParams = map[string]bool{
+	"timeout": true, "retry": true, "interval": true, "buffer_size": true,
+	"mode": true, "method": true, "header": true, "ua": true, "cookie": true, "redirect": true,
+	"tls-domain": true, "tls-insecure-skip-verify": true, "tls-ca-file": true,
+}
+
 type Suo5Conf struct {
 	*suo5.Suo5Client
 	*suo5.Suo5Config
+
+	// TLSConfig 用于 suo5s, 替换 suo5 默认不校验证书的 TLS 配置
+	TLSConfig *tls.Config
+	// RetryPolicy 为 CREATE 失败时的重试策略
+	tunnel.RetryPolicy
+	// ConnOptions 控制连接的读缓冲与写入队列, suo5 是流式协议, 不需要轮询
+	tunnel.ConnOptions
+	// Dial 用于建立到 suo5 服务端的连接, 为空时直接连接, 可以替换为上游代理
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	initMu sync.Mutex
 }
 
-// NewConfFromURL 从URL中解析用户名密码生成配置
+// NewConfFromURL 从URL中解析参数生成配置, 连接模式在首次 Dial 时探测
 func NewConfFromURL(proxyURL *url.URL) (*Suo5Conf, error) {
 	scheme := "http"
 	switch strings.ToLower(proxyURL.Scheme) {
@@ -35,66 +72,220 @@ func NewConfFromURL(proxyURL *url.URL) (*Suo5Conf, error) {
 	// 使用这些值构建配置
 	config := suo5.DefaultSuo5Config()
 	config.Target = fmt.Sprintf("%s://%s%s", scheme, proxyURL.Host, proxyURL.Path)
-	client, err := config.Init()
-	if err != nil {
+	conf := &Suo5Conf{
+		Suo5Config: config,
+		RetryPolicy: tunnel.RetryPolicy{
+			MaxRetry:      DefaultMaxRetry,
+			RetryInterval: DefaultRetryInterval,
+		},
+		ConnOptions: tunnel.ConnOptions{
+			ReadBufferSize: DefaultReadBufferSize,
+			WriteQueueSize: DefaultWriteQueueSize,
+		},
+	}
+	if err := conf.parseQuery(proxyURL.Hostname(), proxyURL.Query()); err != nil {
 		return nil, err
 	}
+	return conf, nil
+}
 
-	suo5Conf := &Suo5Conf{
-		Suo5Config: config,
-		Suo5Client: client,
+func (conf *Suo5Conf) parseQuery(host string, query url.Values) error {
+	for key := range query {
+		if !queryParams[key] {
+			return fmt.Errorf("unknown suo5 parameter: %s", key)
+		}
 	}
+	config := conf.Suo5Config
 
-	return suo5Conf, nil
-}
-
-// Dial 实现了Client接口
-func (c *Suo5Client) Dial(network, address string) (net.Conn, error) {
-	// 创建一个新的 suo5Conn 连接
-	//conn, err := c.conf.Upstream(network, address)
-	//if err != nil {
-	//	return nil, err
-	//}
+	if v := query.Get("timeout"); v != "" {
+		d, err := parseSeconds(v)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %s", v)
+		}
+		config.Timeout = d
+	}
+	if v := query.Get("retry"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid retry: %s", v)
+		}
+		conf.MaxRetry = n
+	}
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %s", v)
+		}
+		conf.RetryInterval = d
+	}
+	if v := query.Get("buffer_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid buffer_size: %s", v)
+		}
+		config.BufferSize = n
+	}
+	if v := query.Get("mode"); v != "" {
+		switch mode := suo5.ConnectionType(strings.ToLower(v)); mode {
+		case suo5.AutoDuplex, suo5.FullDuplex, suo5.HalfDuplex:
+			config.Mode = mode
+		case "classic":
+			return fmt.Errorf("suo5 mode classic is not supported by this suo5 version, use full, half or auto")
+		default:
+			return fmt.Errorf("invalid suo5 mode: %s", v)
+		}
+	}
 
-	suo5Conn := &suo5Conn{
-		Suo5Conn: suo5.NewSuo5Conn(context.Background(), c.Conf.Suo5Client),
-		Suo5Conf: c.Conf,
+	// 请求定制与其他隧道协议相同, 解析后转换为 suo5 的 RawHeader
+	var opts tunnel.HTTPOptions
+	if err := tunnel.ParseHTTPOptions(&opts, query); err != nil {
+		return err
+	}
+	if opts.Method != "" {
+		config.Method = opts.Method
+	}
+	for name, values := range opts.Headers {
+		for _, v := range values {
+			config.RawHeader = append(config.RawHeader, name+": "+v)
+		}
+	}
+	if len(opts.Cookies) > 0 {
+		cookies := make([]string, len(opts.Cookies))
+		for i, cookie := range opts.Cookies {
+			cookies[i] = cookie.String()
+		}
+		config.RawHeader = append(config.RawHeader, "Cookie: "+strings.Join(cookies, "; "))
+	}
+	if v := query.Get("redirect"); v != "" {
+		if _, err := url.Parse(v); err != nil {
+			return fmt.Errorf("invalid redirect url: %s", err)
+		}
+		config.RedirectURL = v
 	}
 
-	// 发送连接请求
-	if err := suo5Conn.connect(address); err != nil {
-		return nil, err
+	tlsConfig, err := tunnel.TLSConfigFromQuery(host, query)
+	if err != nil {
+		return err
 	}
+	// 与 suo5 保持一致, 未指定 tls-insecure-skip-verify 与 tls-ca-file 时不校验证书
+	if query.Get("tls-insecure-skip-verify") == "" && query.Get("tls-ca-file") == "" {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	conf.TLSConfig = tlsConfig
+	return nil
+}
 
-	return suo5Conn, nil
+// parseSeconds 解析 "10" 或 "10s" 形式的超时时间, 返回秒数
+func parseSeconds(v string) (int, error) {
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return n, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", v)
+	}
+	if d > 0 && d < time.Second {
+		return 1, nil
+	}
+	return int(d / time.Second), nil
 }
 
-// suo5Conn 实现了net.Conn接口
-type suo5Conn struct {
-	*suo5.Suo5Conn
-	*Suo5Conf
+// Dial 实现了Client接口
+func (c *Suo5Client) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
 }
 
-func (conn *suo5Conn) connect(address string) error {
-	return conn.Suo5Conn.Connect(address)
+// DialContext 建立经由 suo5 的连接, ctx 取消时中断握手。
+// 连接建立后的生命周期由 Close 控制, 与 ctx 无关
+func (c *Suo5Client) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := c.Conf.Init(ctx); err != nil {
+		return nil, err
+	}
+
+	var session *streamSession
+	err := c.Conf.RetryPolicy.Do(ctx, func() error {
+		var err error
+		session, err = c.Conf.connect(ctx, address)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.NewConn(session, &c.Conf.ConnOptions,
+		tunnel.NewAddr(addrNetwork, c.Conf.Suo5Config.Target), tunnel.NewAddr(addrNetwork, address)), nil
 }
 
-func (conn *suo5Conn) LocalAddr() net.Addr {
-	return nil
+// connect 发送 CREATE 请求, ctx 只作用于握手阶段, 建立后的数据流由 session 的 Close 结束
+func (conf *Suo5Conf) connect(ctx context.Context, address string) (*streamSession, error) {
+	connCtx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		rw  io.ReadWriteCloser
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		rw, err := open(connCtx, conf.Suo5Client, address)
+		ch <- result{rw, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			cancel()
+			return nil, r.err
+		}
+		return &streamSession{
+			rw:      r.rw,
+			cancel:  cancel,
+			buf:     make([]byte, conf.Suo5Config.BufferSize),
+			timeout: time.Duration(conf.Suo5Config.Timeout) * time.Second,
+		}, nil
+	case <-ctx.Done():
+		cancel()
+		// 取消时连接可能已经建立, 在后台关闭
+		go func() {
+			if r := <-ch; r.rw != nil {
+				r.rw.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
 }
 
-func (conn *suo5Conn) RemoteAddr() net.Addr {
-	return nil
+// streamSession 把 suo5 的双向数据流适配为 tunnel.Session, Read 阻塞到有数据为止
+type streamSession struct {
+	rw     io.ReadWriteCloser
+	cancel context.CancelFunc
+	buf    []byte
+	// timeout 限制单次写入的耗时, 避免服务端停止读取时 Close 一直等待
+	timeout time.Duration
 }
 
-func (conn *suo5Conn) SetDeadline(t time.Time) error {
-	return nil
+func (s *streamSession) Read(ctx context.Context) ([]byte, error) {
+	n, err := s.rw.Read(s.buf)
+	return s.buf[:n], err
 }
 
-func (conn *suo5Conn) SetReadDeadline(t time.Time) error {
-	return nil
+func (s *streamSession) Write(ctx context.Context, data []byte) error {
+	if s.timeout <= 0 {
+		_, err := s.rw.Write(data)
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		_, err := s.rw.Write(data)
+		ch <- err
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(s.timeout):
+		s.rw.Close()
+		return errWriteTimeout
+	}
 }
 
-func (conn *suo5Conn) SetWriteDeadline(t time.Time) error {
-	return nil
+func (s *streamSession) Close() error {
+	err := s.rw.Close()
+	s.cancel()
+	return err
 }
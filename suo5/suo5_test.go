@@ -1,32 +1,236 @@
 package suo5
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/chainreactors/proxyclient"
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	socksproxy "github.com/chainreactors/proxyclient/socks"
+	"github.com/zema1/suo5/suo5"
 )
 
-func TestSuo5ClientDial(t *testing.T) {
-	// Create test client
-	proxyURL, _ := url.Parse("suo5://127.0.0.1:8089/suo5.jsp")
+// newTestServer 启动 Go 实现的 suo5 服务端和一个 TCP echo 服务, 返回服务端与 echo 地址
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	echo := testutil.Echo(t)
+
+	handler := NewHandler()
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		handler.Close()
+		server.Close()
+	})
+	return server, echo
+}
+
+func newTestClient(t *testing.T, rawURL string) *Suo5Client {
+	proxyURL, _ := url.Parse(rawURL)
 	conf, err := NewConfFromURL(proxyURL)
 	if err != nil {
 		t.Fatal(err)
 	}
+	return &Suo5Client{Proxy: proxyURL, Conf: conf}
+}
 
-	client := &Suo5Client{
-		Proxy: proxyURL,
-		Conf:  conf,
-	}
-
-	// Test connection
-	conn, err := client.Dial("tcp", "127.0.0.1:1234")
+// testEcho 经由 client 连接 echo 服务并校验回显
+func testEcho(t *testing.T, client *Suo5Client, target string, size int) {
+	conn, err := client.Dial("tcp", target)
 	if err != nil {
 		t.Fatalf("Dial failed: %v", err)
 	}
 	defer conn.Close()
 
-	_, err = conn.Write([]byte("restet"))
+	data := make([]byte, size)
+	rand.Read(data)
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("echo mismatch")
+	}
+}
+
+func TestSuo5ClientDial(t *testing.T) {
+	server, target := newTestServer(t)
+	for _, mode := range []string{"full", "half"} {
+		t.Run(mode, func(t *testing.T) {
+			client := newTestClient(t, "suo5"+strings.TrimPrefix(server.URL, "http")+"/suo5.jsp?mode="+mode)
+			testEcho(t, client, target, 200*1024)
+			if string(client.Conf.Mode) != mode {
+				t.Errorf("mode = %s, want %s", client.Conf.Mode, mode)
+			}
+		})
+	}
+	t.Run("auto", func(t *testing.T) {
+		client := newTestClient(t, "suo5"+strings.TrimPrefix(server.URL, "http")+"/suo5.jsp")
+		testEcho(t, client, target, 1024)
+		if client.Conf.Mode != suo5.FullDuplex {
+			t.Errorf("mode = %s, want full", client.Conf.Mode)
+		}
+	})
+	t.Run("refused", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		addr := listener.Addr().String()
+		listener.Close()
+		client := newTestClient(t, "suo5"+strings.TrimPrefix(server.URL, "http")+"/suo5.jsp?retry=1")
+		if _, err := client.Dial("tcp", addr); !errors.Is(err, suo5.ErrHostUnreachable) {
+			t.Fatalf("err = %v, want host unreachable", err)
+		}
+	})
+}
+
+func TestSuo5Redirect(t *testing.T) {
+	back, target := newTestServer(t)
+	// 第一层不能直接连接目标, 只能转发
+	handler := NewHandler()
+	handler.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("dial disabled")
+	}
+	front := httptest.NewServer(handler)
+	defer front.Close()
+	for _, mode := range []string{"full", "half"} {
+		t.Run(mode, func(t *testing.T) {
+			client := newTestClient(t, "suo5"+strings.TrimPrefix(front.URL, "http")+"/suo5.jsp?mode="+mode+"&redirect="+url.QueryEscape(back.URL+"/suo5.jsp"))
+			testEcho(t, client, target, 64*1024)
+		})
+	}
+}
+
+func TestHandlerNotFound(t *testing.T) {
+	server, _ := newTestServer(t)
+	resp, err := http.Get(server.URL)
 	if err != nil {
-		return
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	u, _ := url.Parse("suo5s://example.com/suo5.jsp?timeout=5s&retry=5&interval=1s&buffer_size=1024&mode=half&method=put" +
+		"&header=X-Token:abc&ua=test&cookie=a=1&cookie=b=2&redirect=http://10.0.0.2/suo5.jsp&tls-domain=foo.com")
+	conf := &Suo5Conf{Suo5Config: suo5.DefaultSuo5Config()}
+	if err := conf.parseQuery(u.Hostname(), u.Query()); err != nil {
+		t.Fatal(err)
+	}
+	config := conf.Suo5Config
+	if config.Timeout != 5 || conf.MaxRetry != 5 || conf.RetryInterval != time.Second || config.BufferSize != 1024 {
+		t.Fatalf("unexpected conf: timeout=%d retry=%d interval=%s buffer=%d", config.Timeout, conf.MaxRetry, conf.RetryInterval, config.BufferSize)
+	}
+	if config.Mode != suo5.HalfDuplex || config.Method != "PUT" || config.RedirectURL != "http://10.0.0.2/suo5.jsp" {
+		t.Fatalf("unexpected conf: mode=%s method=%s redirect=%s", config.Mode, config.Method, config.RedirectURL)
+	}
+	if err := config.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Header.Get("X-Token") != "abc" || config.Header.Get("User-Agent") != "test" || config.Header.Get("Cookie") != "a=1; b=2" {
+		t.Fatalf("unexpected header: %v", config.Header)
+	}
+	if conf.TLSConfig.ServerName != "foo.com" || !conf.TLSConfig.InsecureSkipVerify {
+		t.Fatalf("unexpected tls config: %+v", conf.TLSConfig)
+	}
+
+	for _, raw := range []string{"?retyr=3", "?mode=classic", "?mode=foo", "?timeout=abc", "?header=bad"} {
+		u, _ := url.Parse("suo5://example.com/suo5.jsp" + raw)
+		conf := &Suo5Conf{Suo5Config: suo5.DefaultSuo5Config()}
+		if err := conf.parseQuery(u.Hostname(), u.Query()); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
+
+func TestSuo5Upstream(t *testing.T) {
+	server, echo := newTestServer(t)
+
+	// suo5.test 只能由 SOCKS5 服务端解析, 直接连接会失败
+	var mu sync.Mutex
+	var dials []string
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go socksproxy.Serve(listener, &socksproxy.SOCKSConf{
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			mu.Lock()
+			dials = append(dials, address)
+			mu.Unlock()
+			if address == "suo5.test:80" {
+				address = server.Listener.Addr().String()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		},
+	})
+	socksURL, _ := url.Parse("socks5://" + listener.Addr().String())
+	upstream, err := proxyclient.NewClient(socksURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxyURL, _ := url.Parse("suo5://suo5.test/suo5.jsp")
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Dial = upstream
+	client := &Suo5Client{Proxy: proxyURL, Conf: conf}
+
+	for i := 0; i < 3; i++ {
+		testEcho(t, client, echo, 100*1024)
+	}
+
+	if conf.Mode != suo5.FullDuplex {
+		t.Errorf("mode = %s, want full", conf.Mode)
+	}
+	if conf.NormalClient.Transport != conf.NoTimeoutClient.Transport {
+		t.Error("clients should share one transport")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dials) == 0 {
+		t.Fatal("no request went through the upstream")
+	}
+	for _, addr := range dials {
+		if addr != "suo5.test:80" {
+			t.Errorf("unexpected upstream dial to %s", addr)
+		}
+	}
+}
+
+func TestSuo5InitContext(t *testing.T) {
+	// 服务端不回应模式探测, ctx 超时后 Dial 立即返回
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newTestClient(t, "suo5"+strings.TrimPrefix(server.URL, "http")+"/suo5.jsp")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.DialContext(ctx, "tcp", "127.0.0.1:80"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DialContext returned after %s", elapsed)
 	}
 }
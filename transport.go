@@ -0,0 +1,96 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/chainreactors/proxyclient/obfs"
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+// TransportFactory 创建承载层: 包装到代理服务器的 Dial, 参数取自代理 URL 的 query。
+// 承载层写在协议名之后, 例如 socks5+tls://、http+ws://、ss+obfs+tls://,
+// 越靠后越接近底层连接, 协议的 DialFactory 收到包装后的 Dial 与去掉承载层的 URL
+type TransportFactory func(*url.URL, Dial) (Dial, error)
+
+var transports = map[string]TransportFactory{}
+
+func init() {
+	RegisterTransport("TLS", newTLSTransport)
+	RegisterTransport("WS", newWebSocketTransport("ws"))
+	RegisterTransport("WSS", newWebSocketTransport("wss"))
+	RegisterTransport("OBFS", newObfsTransport)
+}
+
+func RegisterTransport(transportName string, factory TransportFactory) {
+	transports[strings.ToUpper(transportName)] = factory
+}
+
+func SupportedTransports() []string {
+	transportNames := make([]string, 0, len(transports))
+	for transportName := range transports {
+		transportNames = append(transportNames, transportName)
+	}
+	return transportNames
+}
+
+// wrapTransports 从后向前依次用 scheme 中的承载层包装 upstreamDial, 返回只保留协议名的 URL
+func wrapTransports(proxy *url.URL, upstreamDial Dial) (*url.URL, Dial, error) {
+	ss := strings.Split(proxy.Scheme, "+")
+	if len(ss) == 1 {
+		return proxy, upstreamDial, nil
+	}
+	dial := upstreamDial
+	for i := len(ss) - 1; i > 0; i-- {
+		factory, ok := transports[ss[i]]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported transport: %s", ss[i])
+		}
+		var err error
+		if dial, err = factory(proxy, dial); err != nil {
+			return nil, nil, err
+		}
+	}
+	inner := *proxy
+	inner.Scheme = ss[0]
+	return &inner, dial, nil
+}
+
+// newTLSTransport 在连接后进行 TLS 握手, 参数: tls-domain、tls-insecure-skip-verify、tls-ca-file
+func newTLSTransport(proxy *url.URL, upstreamDial Dial) (Dial, error) {
+	tlsConfig, err := tunnel.TLSConfigFromQuery(proxy.Hostname(), proxy.Query())
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := upstreamDial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}, nil
+}
+
+// newObfsTransport 使用 simple-obfs 的 http 模式伪装连接, 参数: obfs、obfs-host、obfs-uri、obfs-ua
+func newObfsTransport(proxy *url.URL, upstreamDial Dial) (Dial, error) {
+	conf, err := obfs.NewConfFromURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := upstreamDial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return obfs.NewConn(conn, conf), nil
+	}, nil
+}
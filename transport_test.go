@@ -0,0 +1,263 @@
+package proxyclient
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	httpProxy "github.com/chainreactors/proxyclient/http"
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/chainreactors/proxyclient/obfs"
+	socksProxy "github.com/chainreactors/proxyclient/socks"
+	"github.com/chainreactors/proxyclient/ws"
+	ss "github.com/shadowsocks/go-shadowsocks2/core"
+	ssSocks "github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// newTestTLSConfig 生成 127.0.0.1 的自签名证书
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "proxyclient test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func testDialEcho(t *testing.T, rawURL, target string) {
+	proxy, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial, err := NewClient(proxy)
+	if err != nil {
+		t.Fatalf("%s: %v", rawURL, err)
+	}
+	conn, err := dial.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("%s: %v", rawURL, err)
+	}
+	defer conn.Close()
+	payload := bytes.Repeat([]byte("carrier"), 1024)
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatalf("%s: %v", rawURL, err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatalf("%s: echoed data mismatch", rawURL)
+	}
+}
+
+func TestSocks5TLSTransport(t *testing.T) {
+	target := testutil.Echo(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go socksProxy.Serve(listener, &socksProxy.SOCKSConf{
+		Dial:      DefaultDial,
+		TLSConfig: newTestTLSConfig(t),
+		Auth: func(username, password string) bool {
+			return username == "user" && password == "pass"
+		},
+	})
+
+	testDialEcho(t, "socks5+tls://user:pass@"+listener.Addr().String()+"/?tls-insecure-skip-verify=true", target)
+
+	// 不使用 TLS 或密码错误时失败
+	for _, raw := range []string{
+		"socks5://user:pass@" + listener.Addr().String(),
+		"socks5+tls://user:wrong@" + listener.Addr().String() + "/?tls-insecure-skip-verify=true",
+		"socks5+tls://user:pass@" + listener.Addr().String(),
+	} {
+		proxy, _ := url.Parse(raw)
+		dial, err := NewClient(proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn, err := dial.Dial("tcp", target); err == nil {
+			conn.Close()
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
+
+// serveLegacySocks5TLS 模拟旧版服务端: TLS 连接上只接受 0x82 认证方法, 随后转发 CONNECT
+func serveLegacySocks5TLS(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	if !bytes.Contains(methods, []byte{0x82}) {
+		conn.Write([]byte{5, 0xff})
+		return
+	}
+	conn.Write([]byte{5, 0x82})
+	auth := make([]byte, 2)
+	if _, err := io.ReadFull(conn, auth); err != nil {
+		return
+	}
+	username := make([]byte, auth[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, auth[:1]); err != nil {
+		return
+	}
+	password := make([]byte, auth[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return
+	}
+	if string(username) != "user" || string(password) != "pass" {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	// 客户端总是以域名形式发送目标地址
+	request := make([]byte, 5)
+	if _, err := io.ReadFull(conn, request); err != nil || request[3] != 3 {
+		return
+	}
+	host := make([]byte, int(request[4])+2)
+	if _, err := io.ReadFull(conn, host); err != nil {
+		return
+	}
+	port := int(host[len(host)-2])<<8 | int(host[len(host)-1])
+	remote, err := net.Dial("tcp", net.JoinHostPort(string(host[:len(host)-2]), strconv.Itoa(port)))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer remote.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(remote, conn)
+	io.Copy(conn, remote)
+}
+
+func TestSocks5TLSLegacyServer(t *testing.T) {
+	target := testutil.Echo(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", newTestTLSConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveLegacySocks5TLS(conn)
+		}
+	}()
+
+	testDialEcho(t, "socks5+tls://user:pass@"+listener.Addr().String()+"/?tls-insecure-skip-verify=true", target)
+
+	proxy, _ := url.Parse("socks5+tls://user:wrong@" + listener.Addr().String() + "/?tls-insecure-skip-verify=true")
+	dial, err := NewClient(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := dial.Dial("tcp", target); err == nil {
+		conn.Close()
+		t.Error("wrong password accepted")
+	}
+}
+
+func TestHTTPWebSocketTransport(t *testing.T) {
+	target := testutil.Echo(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go httpProxy.Serve(listener, net.Dial)
+	handler := ws.NewHandler()
+	handler.Backend = listener.Addr().String()
+	hs := httptest.NewServer(handler)
+	defer hs.Close()
+
+	testDialEcho(t, "http+ws://"+strings.TrimPrefix(hs.URL, "http://")+"/proxy", target)
+}
+
+func TestShadowsocksObfsTLSTransport(t *testing.T) {
+	// 客户端与服务端在同一进程中共享 salt 过滤器, 关闭重放检测
+	os.Setenv("SHADOWSOCKS_SF_CAPACITY", "-1")
+	target := testutil.Echo(t)
+	cipher, err := ss.PickCipher("AEAD_CHACHA20_POLY1305", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	// 承载层从外到内依次为 tls、obfs
+	listener := &obfs.Listener{Listener: tls.NewListener(raw, newTestTLSConfig(t))}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn = cipher.StreamConn(conn)
+				addr, err := ssSocks.ReadAddr(conn)
+				if err != nil {
+					return
+				}
+				remote, err := net.Dial("tcp", addr.String())
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+
+	testDialEcho(t, "ss+obfs+tls://AEAD_CHACHA20_POLY1305:secret@"+raw.Addr().String()+
+		"/?tls-insecure-skip-verify=true&obfs=http&obfs-host=www.bing.com", target)
+}
+
+func TestUnsupportedTransport(t *testing.T) {
+	for _, raw := range []string{"socks5+foo://127.0.0.1:1080", "foo+tls://127.0.0.1:1080", "ss+obfs://a:b@127.0.0.1:1?obfs=tls"} {
+		proxy, _ := url.Parse(raw)
+		if _, err := NewClient(proxy); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package trojan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/relay"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// DefaultDialTimeout 为服务端连接目标的超时时间
+var DefaultDialTimeout = 5 * time.Second
+
+// Server 为 Go 实现的 trojan 服务端, 用于本地测试: 校验密码后转发 TCP 连接或 UDP-over-TCP 的包。
+// 密码错误时直接关闭连接, 不转发到伪装站点
+type Server struct {
+	TLSConfig *tls.Config
+	Dial      func(ctx context.Context, network, address string) (net.Conn, error)
+
+	hashes map[string]struct{}
+}
+
+// NewServer 创建接受 passwords 中任意密码的服务端
+func NewServer(tlsConfig *tls.Config, passwords ...string) *Server {
+	s := &Server{
+		TLSConfig: tlsConfig,
+		Dial:      (&net.Dialer{Timeout: DefaultDialTimeout}).DialContext,
+		hashes:    make(map[string]struct{}),
+	}
+	for _, password := range passwords {
+		s.hashes[Hash(password)] = struct{}{}
+	}
+	return s
+}
+
+// Serve 在 listener 上接受连接, 直到 listener 关闭
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(tls.Server(conn, s.TLSConfig))
+	}
+}
+
+// ServeConn 处理一个已经建立的 TLS 连接
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 56+2+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	if _, ok := s.hashes[string(header[:56])]; !ok || !bytes.Equal(header[56:58], crlf) {
+		return
+	}
+	command := header[58]
+	target, err := socks.ReadAddr(reader)
+	if err != nil {
+		return
+	}
+	if _, err := reader.Discard(2); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	switch command {
+	case commandConnect:
+		remote, err := s.Dial(context.Background(), "tcp", target.String())
+		if err != nil {
+			return
+		}
+		relay.Relay(&bufferedConn{Conn: conn, r: reader}, remote, 0)
+	case commandUDPAssociate:
+		s.serveUDP(conn, reader)
+	}
+}
+
+// serveUDP 把客户端的包发送到各自的目标地址, 并把收到的响应连同来源地址写回
+func (s *Server) serveUDP(conn net.Conn, reader io.Reader) {
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return
+	}
+	defer pc.Close()
+
+	var writeMu sync.Mutex
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			addr := socks.ParseAddr(from.String())
+			writeMu.Lock()
+			_, err = conn.Write(AppendPacket(nil, addr, buf[:n]))
+			writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		addr, payload, err := ReadPacket(reader, buf)
+		if err != nil {
+			return
+		}
+		udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+		if err != nil {
+			continue
+		}
+		if _, err := pc.WriteTo(payload, udpAddr); err != nil {
+			return
+		}
+	}
+}
+
+// bufferedConn 从 r 读取, 保留请求头之后已经读入缓冲区的数据
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
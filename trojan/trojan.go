@@ -0,0 +1,246 @@
+package trojan
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+const (
+	commandConnect      byte = 0x01
+	commandUDPAssociate byte = 0x03
+
+	// maxPacketSize 为 UDP 包的最大长度
+	maxPacketSize = 65535
+)
+
+var crlf = []byte{'\r', '\n'}
+
+var DefaultTimeout = 10 * time.Second
+
+var errInvalidAddress = errors.New("trojan: invalid address")
+
+// TrojanClient 实现了Client接口, 每次 Dial 建立一个到服务端的 TLS 连接
+type TrojanClient struct {
+	Proxy *url.URL
+	Conf  *TrojanConf
+}
+
+// TrojanConf 配置结构
+type TrojanConf struct {
+	// Dial 用于建立到服务端的连接, 可以替换为上游代理
+	Dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	TLSConfig *tls.Config
+	// Server 为服务端地址 host:port
+	Server   string
+	Password string
+	Timeout  time.Duration
+}
+
+// NewConfFromURL 从分享链接 trojan://password@host:port?sni=... 中解析配置:
+// sni (或 peer)、allowInsecure、alpn, 以及 tls-* 参数; 只支持 type=tcp
+func NewConfFromURL(proxyURL *url.URL) (*TrojanConf, error) {
+	if proxyURL.User == nil || proxyURL.User.Username() == "" {
+		return nil, errors.New("trojan: password is required")
+	}
+	password := proxyURL.User.Username()
+	if p, ok := proxyURL.User.Password(); ok {
+		password += ":" + p
+	}
+	query := proxyURL.Query()
+	if t := query.Get("type"); t != "" && !strings.EqualFold(t, "tcp") {
+		return nil, fmt.Errorf("trojan: unsupported transport type: %s", t)
+	}
+
+	sni := query.Get("sni")
+	if sni == "" {
+		sni = query.Get("peer")
+	}
+	if sni == "" {
+		sni = proxyURL.Hostname()
+	}
+	tlsConfig, err := tunnel.TLSConfigFromQuery(sni, query)
+	if err != nil {
+		return nil, err
+	}
+	// 分享链接使用 allowInsecure, 经过 proxyclient 时参数名已转为小写
+	allowInsecure := query.Get("allowInsecure")
+	if allowInsecure == "" {
+		allowInsecure = query.Get("allowinsecure")
+	}
+	if allowInsecure == "1" || strings.EqualFold(allowInsecure, "true") {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if v := query.Get("alpn"); v != "" {
+		tlsConfig.NextProtos = strings.Split(v, ",")
+	}
+
+	port := proxyURL.Port()
+	if port == "" {
+		port = "443"
+	}
+	conf := &TrojanConf{
+		Dial:      (&net.Dialer{}).DialContext,
+		TLSConfig: tlsConfig,
+		Server:    net.JoinHostPort(proxyURL.Hostname(), port),
+		Password:  password,
+		Timeout:   DefaultTimeout,
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", v)
+		}
+		conf.Timeout = d
+	}
+	return conf, nil
+}
+
+// Hash 返回 trojan 请求头中的密码: SHA224 的十六进制
+func Hash(password string) string {
+	h := sha256.Sum224([]byte(password))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *TrojanClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext 完成 TLS 握手后发送请求头, udp 时返回按 UDP-over-TCP 格式分帧的连接
+func (c *TrojanClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var command byte
+	switch strings.ToLower(network) {
+	case "tcp", "tcp4", "tcp6":
+		command = commandConnect
+	case "udp", "udp4", "udp6":
+		command = commandUDPAssociate
+	default:
+		return nil, fmt.Errorf("trojan: unsupported network: %s", network)
+	}
+	target := socks.ParseAddr(address)
+	if target == nil {
+		return nil, errInvalidAddress
+	}
+
+	if c.Conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Conf.Timeout)
+		defer cancel()
+	}
+	conn, err := c.Conf.Dial(ctx, "tcp", c.Conf.Server)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, c.Conf.TLSConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// 请求头: hex(SHA224(password)) CRLF CMD ATYP DST.ADDR DST.PORT CRLF
+	header := make([]byte, 0, 56+2+1+len(target)+2)
+	header = append(header, Hash(c.Conf.Password)...)
+	header = append(header, crlf...)
+	header = append(header, command)
+	header = append(header, target...)
+	header = append(header, crlf...)
+	if _, err := tlsConn.Write(header); err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	if command == commandUDPAssociate {
+		return newPacketConn(tlsConn, target, address), nil
+	}
+	return tlsConn, nil
+}
+
+// packetConn 把 UDP 包封装为 ATYP DST.ADDR DST.PORT Length CRLF Payload 在 TLS 连接上传输,
+// 每次 Write 发送一个包, 每次 Read 读取一个包, 缓冲区不足时与 UDP 一样截断
+type packetConn struct {
+	net.Conn
+	target socks.Addr
+	remote net.Addr
+
+	readMu  sync.Mutex
+	reader  *bufio.Reader
+	buf     []byte
+	writeMu sync.Mutex
+}
+
+func newPacketConn(conn net.Conn, target socks.Addr, address string) *packetConn {
+	return &packetConn{
+		Conn:   conn,
+		target: target,
+		remote: tunnel.NewAddr("udp", address),
+		reader: bufio.NewReader(conn),
+		buf:    make([]byte, maxPacketSize),
+	}
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	_, payload, err := ReadPacket(c.reader, c.buf)
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, payload), nil
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	if len(b) > maxPacketSize {
+		return 0, errors.New("trojan: packet too large")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(AppendPacket(nil, c.target, b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// AppendPacket 把一个 UDP 包按 trojan 格式追加到 dst
+func AppendPacket(dst []byte, addr socks.Addr, payload []byte) []byte {
+	dst = append(dst, addr...)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(payload)))
+	dst = append(dst, crlf...)
+	return append(dst, payload...)
+}
+
+// ReadPacket 读取一个 trojan 格式的 UDP 包, 负载读入 buf
+func ReadPacket(r io.Reader, buf []byte) (socks.Addr, []byte, error) {
+	addr, err := socks.ReadAddr(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(head[:2]))
+	if n > len(buf) {
+		return nil, nil, io.ErrShortBuffer
+	}
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return nil, nil, err
+	}
+	return addr, buf[:n], nil
+}
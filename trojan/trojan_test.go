@@ -0,0 +1,148 @@
+package trojan
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+)
+
+// newTestServer 启动 trojan 服务端, 返回其地址
+func newTestServer(t *testing.T, passwords ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "trojan test"},
+		DNSNames:     []string{"trojan.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, passwords...)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener)
+	return listener.Addr().String()
+}
+
+func newTestClient(t *testing.T, rawURL string) *TrojanClient {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TrojanClient{Proxy: proxyURL, Conf: conf}
+}
+
+func TestTrojanTCP(t *testing.T) {
+	echo := testutil.Echo(t)
+	server := newTestServer(t, "pass:word")
+
+	client := newTestClient(t, "trojan://pass:word@"+server+"?sni=trojan.example.com&allowInsecure=1")
+	conn, err := client.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := bytes.Repeat([]byte("trojan"), 32*1024)
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("echoed data mismatch")
+	}
+
+	// 密码错误时服务端关闭连接
+	client = newTestClient(t, "trojan://wrong@"+server+"?allowInsecure=1")
+	conn, err = client.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 5)); err == nil {
+		t.Error("read with wrong password succeeded")
+	}
+
+	// 校验证书时失败
+	client = newTestClient(t, "trojan://pass:word@"+server+"?sni=trojan.example.com")
+	if _, err := client.Dial("tcp", echo); err == nil {
+		t.Error("Dial with untrusted certificate succeeded")
+	}
+}
+
+func TestTrojanUDP(t *testing.T) {
+	echo := testutil.EchoPacket(t)
+	server := newTestServer(t, "password")
+
+	client := newTestClient(t, "trojan://password@"+server+"?allowInsecure=1")
+	conn, err := client.Dial("udp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr(); addr.Network() != "udp" || addr.String() != echo {
+		t.Errorf("RemoteAddr = %s %s", addr.Network(), addr)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, packet := range []string{"first", "second packet", "third"} {
+		if _, err := conn.Write([]byte(packet)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 2048)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != packet {
+			t.Errorf("received %q, want %q", buf[:n], packet)
+		}
+	}
+}
+
+func TestNewConfFromURL(t *testing.T) {
+	proxyURL, _ := url.Parse("trojan://secret@example.com?peer=cdn.example.com&alpn=h2,http/1.1&allowinsecure=true#node")
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Server != "example.com:443" || conf.Password != "secret" {
+		t.Errorf("server = %s, password = %s", conf.Server, conf.Password)
+	}
+	if conf.TLSConfig.ServerName != "cdn.example.com" || !conf.TLSConfig.InsecureSkipVerify || len(conf.TLSConfig.NextProtos) != 2 {
+		t.Errorf("tls config = %+v", conf.TLSConfig)
+	}
+
+	for _, raw := range []string{"trojan://example.com:443", "trojan://secret@example.com?type=ws"} {
+		proxyURL, _ := url.Parse(raw)
+		if _, err := NewConfFromURL(proxyURL); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
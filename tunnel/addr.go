@@ -0,0 +1,24 @@
+package tunnel
+
+import "net"
+
+// Addr 为隧道连接的合成地址。
+// Net 标识隧道协议 (如 "neoreg"), Address 为目标地址或 webshell URL
+type Addr struct {
+	Net     string
+	Address string
+}
+
+var _ net.Addr = (*Addr)(nil)
+
+func NewAddr(network, address string) *Addr {
+	return &Addr{Net: network, Address: address}
+}
+
+func (a *Addr) Network() string {
+	return a.Net
+}
+
+func (a *Addr) String() string {
+	return a.Address
+}
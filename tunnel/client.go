@@ -0,0 +1,237 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+)
+
+// Op 为隧道命令的类型
+type Op int
+
+const (
+	OpConnect Op = iota
+	OpRead
+	OpForward
+	OpDisconnect
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpConnect:
+		return "connect"
+	case OpRead:
+		return "read"
+	case OpForward:
+		return "forward"
+	case OpDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Command 为发送给服务端的一条隧道命令
+type Command struct {
+	Op Op
+	// ID 为连接标识, 同一条连接的所有命令相同
+	ID string
+	// Address 为 OpConnect 的目标地址
+	Address string
+	// Data 为 OpForward 发送的数据
+	Data []byte
+	// Seq 为 OpForward 的序号, 从 1 递增, 0 表示不带序号。
+	// OpConnect 时非 0 表示请求服务端按序号写入, 见 Codec.Decode
+	Seq uint64
+}
+
+// Codec 为 webshell 隧道协议的编解码, 新协议只需要实现 Codec, 由 Client 负责连接、重试与轮询
+type Codec interface {
+	// Encode 把命令编码到请求中, req 已经带有 HTTPOptions 中的方法、请求头与 cookie
+	Encode(req *http.Request, cmd *Command) error
+	// Decode 解析服务端响应, 返回 OpRead 读到的数据。
+	// 服务端返回的错误以 error 返回, OpRead 时目标已关闭返回 io.EOF。
+	// 带有 Seq 的 OpConnect 返回非空数据表示服务端确认按序号写入, 此后 FORWARD 可以并发发送;
+	// 不支持序号的协议忽略 Seq 即可
+	Decode(resp *http.Response, body []byte, cmd *Command) ([]byte, error)
+}
+
+// SetBody 设置请求体
+func SetBody(req *http.Request, body []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// NewID 返回随机的连接标识
+func NewID() string {
+	data := make([]byte, 4)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// Client 为基于 HTTP 请求/响应的隧道客户端
+type Client struct {
+	Config *Config
+	Codec  Codec
+	// Network 为连接 LocalAddr/RemoteAddr 的 Network()
+	Network string
+}
+
+// DialContext 发送 OpConnect 建立连接, 失败时按 RetryPolicy 换节点重试, ctx 只作用于握手阶段
+func (c *Client) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	jar, _ := cookiejar.New(nil)
+	s := &httpSession{
+		config: c.Config,
+		codec:  c.Codec,
+		id:     NewID(),
+		client: &http.Client{
+			Transport: c.Config.HTTPClient().Transport,
+			Timeout:   c.Config.Timeout,
+			Jar:       jar,
+		},
+	}
+	if err := s.connect(ctx, address); err != nil {
+		return nil, err
+	}
+	opts := c.Config.ConnOptions
+	// 每次 Read 都是一次 HTTP 请求, 不等待会不断请求服务端
+	if opts.Interval < MinPollInterval {
+		opts.Interval = MinPollInterval
+	}
+	if opts.FlushTimeout <= 0 {
+		opts.FlushTimeout = c.Config.Timeout
+	}
+	var session Session = s
+	if s.sequenced {
+		session = &sequencedSession{s}
+	}
+	return NewConn(session, &opts, NewAddr(c.Network, s.endpoint.url), NewAddr(c.Network, address)), nil
+}
+
+// httpSession 为每个命令发送一次 HTTP 请求的 Session,
+// 与其他连接共享 Transport, 但拥有独立的 cookie jar, 并固定在建立连接时的节点上
+type httpSession struct {
+	config   *Config
+	codec    Codec
+	id       string
+	endpoint *endpoint
+	client   *http.Client
+
+	affinityMu sync.Mutex
+	affinity   string
+	// sequenced 为 true 时服务端确认按序号写入
+	sequenced bool
+}
+
+func (s *httpSession) connect(ctx context.Context, address string) error {
+	cmd := &Command{Op: OpConnect, ID: s.id, Address: address}
+	if s.config.MaxInFlight > 1 {
+		cmd.Seq = 1
+	}
+	pool := s.config.endpointPool()
+	var reply []byte
+	var replyErr error
+	err := s.config.RetryPolicy.Do(ctx, func() error {
+		// 失败的节点被标记为不健康, 下一次重试换到其他节点
+		s.endpoint = pool.pick()
+		var err error
+		reply, replyErr, err = s.roundTrip(ctx, cmd)
+		if err != nil {
+			s.endpoint.markUnhealthy(s.config.UnhealthyTimeout)
+			return err
+		}
+		s.endpoint.markHealthy()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.sequenced = cmd.Seq > 0 && replyErr == nil && len(reply) > 0
+	return replyErr
+}
+
+func (s *httpSession) Read(ctx context.Context) ([]byte, error) {
+	data, replyErr, err := s.roundTrip(ctx, &Command{Op: OpRead, ID: s.id})
+	if err != nil {
+		if ctx.Err() == nil {
+			s.endpoint.markUnhealthy(s.config.UnhealthyTimeout)
+		}
+		return nil, err
+	}
+	return data, replyErr
+}
+
+func (s *httpSession) Write(ctx context.Context, data []byte) error {
+	_, replyErr, err := s.roundTrip(ctx, &Command{Op: OpForward, ID: s.id, Data: data})
+	if err != nil {
+		return err
+	}
+	return replyErr
+}
+
+// sequencedSession 为服务端确认按序号写入的 httpSession, FORWARD 可以并发发送
+type sequencedSession struct {
+	*httpSession
+}
+
+func (s *sequencedSession) WriteSeq(ctx context.Context, seq uint64, data []byte) error {
+	_, replyErr, err := s.roundTrip(ctx, &Command{Op: OpForward, ID: s.id, Data: data, Seq: seq})
+	if err != nil {
+		return err
+	}
+	return replyErr
+}
+
+// Close 发送 OpDisconnect, 不需要重试, 尝试一次即可
+func (s *httpSession) Close() error {
+	_, _, err := s.roundTrip(context.Background(), &Command{Op: OpDisconnect, ID: s.id})
+	return err
+}
+
+// roundTrip 发送一条命令, err 为请求失败, replyErr 为服务端返回的错误
+func (s *httpSession) roundTrip(ctx context.Context, cmd *Command) (data []byte, replyErr, err error) {
+	req, err := s.config.NewRequest(ctx, s.endpoint.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.codec.Encode(req, cmd); err != nil {
+		return nil, nil, err
+	}
+	if s.config.AffinityHeader != "" {
+		s.affinityMu.Lock()
+		if s.affinity != "" {
+			req.Header.Set(s.config.AffinityHeader, s.affinity)
+		}
+		s.affinityMu.Unlock()
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if s.config.AffinityHeader != "" {
+		if v := resp.Header.Get(s.config.AffinityHeader); v != "" {
+			s.affinityMu.Lock()
+			s.affinity = v
+			s.affinityMu.Unlock()
+		}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, replyErr = s.codec.Decode(resp, body, cmd)
+	return data, replyErr, nil
+}
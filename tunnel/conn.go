@@ -0,0 +1,456 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Session 为一条隧道连接的协议实现, 由 Conn 驱动读写
+type Session interface {
+	// Read 读取一次目标数据, 暂无数据时返回空, 目标关闭时返回 io.EOF。
+	// 轮询式协议每次调用发送一个请求, 流式协议可以阻塞到有数据为止
+	Read(ctx context.Context) ([]byte, error)
+	// Write 发送一段数据, 同一时刻只有一个 Write 在进行, 调用顺序即数据顺序
+	Write(ctx context.Context, data []byte) error
+	// Close 通知服务端关闭连接, 并中断阻塞中的 Read
+	Close() error
+}
+
+// SequencedSession 为服务端按序号写入目标的 Session, Conn 可以同时发送 MaxInFlight 个写请求,
+// 到达顺序不影响目标收到的数据顺序
+type SequencedSession interface {
+	Session
+	// WriteSeq 发送序号为 seq 的数据, seq 从 1 递增, 可以并发调用
+	WriteSeq(ctx context.Context, seq uint64, data []byte) error
+}
+
+// DefaultFlushTimeout 为 ConnOptions.FlushTimeout 为 0 时 Close 发送已排队数据的最长时间
+var DefaultFlushTimeout = 5 * time.Second
+
+// Conn 把 Session 适配为 net.Conn
+//
+// readLoop 不断调用 Session.Read, 把数据写入 readBuf; Read 从 readBuf 中取数据。
+// readBuf 达到 ReadBufferSize 后暂停轮询, 直到 Read 取走数据, 以此实现背压。
+// Write 把数据分片放入 writeQueue 后立即返回, writeLoop 合并小分片并按顺序调用 Session.Write,
+// SequencedSession 则带上序号最多同时发送 MaxInFlight 个, 发送失败的错误在下一次 Write 时返回。
+// 所有共享状态由 mu 保护, Close 可以重复调用并唤醒阻塞中的 Read 与 Write,
+// 已排队的数据在 FlushTimeout 内发出, 超时后取消进行中的请求。
+type Conn struct {
+	session Session
+	opts    *ConnOptions
+	local   net.Addr
+	remote  net.Addr
+
+	mu       sync.Mutex
+	readBuf  bytes.Buffer
+	readErr  error
+	writeErr error
+	closed   bool
+
+	dataReady  chan struct{} // 有新数据或 readErr 时通知 Read
+	spaceFree  chan struct{} // Read 取走数据后通知 readLoop
+	pollNow    chan struct{} // 发送数据后通知 readLoop 立即轮询
+	writeQueue chan []byte   // 等待发送的分片
+	writeDone  chan struct{} // writeLoop 退出时关闭
+	done       chan struct{} // Close 时关闭
+	closeOnce  sync.Once
+
+	// ctx 在 Close 时取消, 中断进行中的 Session 调用
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	readDeadline  *Deadline
+	writeDeadline *Deadline
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// NewConn 在已建立的 session 上启动读写循环, local 与 remote 为 LocalAddr/RemoteAddr 的返回值
+func NewConn(session Session, opts *ConnOptions, local, remote net.Addr) *Conn {
+	queueSize := opts.WriteQueueSize
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	c := &Conn{
+		session:       session,
+		opts:          opts,
+		local:         local,
+		remote:        remote,
+		dataReady:     make(chan struct{}, 1),
+		spaceFree:     make(chan struct{}, 1),
+		pollNow:       make(chan struct{}, 1),
+		writeQueue:    make(chan []byte, queueSize),
+		writeDone:     make(chan struct{}),
+		done:          make(chan struct{}),
+		readDeadline:  NewDeadline(),
+		writeDeadline: NewDeadline(),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	go c.readLoop()
+	go c.writeLoop()
+	return c
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) readLoop() {
+	idle := c.opts.Interval
+	for {
+		if !c.waitForSpace() {
+			return
+		}
+
+		data, err := c.session.Read(c.ctx)
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		if len(data) > 0 {
+			c.mu.Lock()
+			c.readBuf.Write(data)
+			c.mu.Unlock()
+			notify(c.dataReady)
+			idle = c.opts.Interval
+		}
+		if err != nil {
+			c.setReadErr(err)
+			return
+		}
+		if len(data) > 0 || c.opts.Interval <= 0 {
+			continue
+		}
+
+		select {
+		case <-time.After(jitter(idle, c.opts.Jitter)):
+			idle = backoff(idle, c.opts.MaxInterval)
+		case <-c.pollNow:
+			idle = c.opts.Interval
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// backoff 把空闲轮询间隔翻倍, 不超过 max
+func backoff(d, max time.Duration) time.Duration {
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	d *= 2
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+// jitter 在 d 上下随机浮动 d*ratio
+func jitter(d time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*ratio*float64(d))
+}
+
+// waitForSpace 在缓冲区满时阻塞, 连接关闭时返回 false
+func (c *Conn) waitForSpace() bool {
+	for {
+		c.mu.Lock()
+		full := c.opts.ReadBufferSize > 0 && c.readBuf.Len() >= c.opts.ReadBufferSize
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return false
+		}
+		if !full {
+			return true
+		}
+		select {
+		case <-c.spaceFree:
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+func (c *Conn) setReadErr(err error) {
+	c.mu.Lock()
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	c.mu.Unlock()
+	notify(c.dataReady)
+}
+
+func (c *Conn) Read(b []byte) (n int, err error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if c.readDeadline.Exceeded() {
+			c.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		if c.readBuf.Len() > 0 {
+			n, _ = c.readBuf.Read(b)
+			c.mu.Unlock()
+			notify(c.spaceFree)
+			return n, nil
+		}
+		if c.readErr != nil {
+			err = c.readErr
+			c.mu.Unlock()
+			return 0, err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.dataReady:
+		case <-c.done:
+		case <-c.readDeadline.Wait():
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (n int, err error) {
+	max := c.opts.MaxForwardSize
+	if max <= 0 {
+		max = len(b)
+	}
+	for n < len(b) {
+		c.mu.Lock()
+		closed, err := c.closed, c.writeErr
+		c.mu.Unlock()
+		if closed {
+			return n, net.ErrClosed
+		}
+		if err != nil {
+			return n, err
+		}
+		if c.writeDeadline.Exceeded() {
+			return n, os.ErrDeadlineExceeded
+		}
+
+		size := len(b) - n
+		if size > max {
+			size = max
+		}
+		chunk := make([]byte, size)
+		copy(chunk, b[n:n+size])
+		select {
+		case c.writeQueue <- chunk:
+			n += size
+		case <-c.done:
+			return n, net.ErrClosed
+		case <-c.writeDeadline.Wait():
+			return n, os.ErrDeadlineExceeded
+		}
+	}
+	return n, nil
+}
+
+// writeLoop 按顺序发送排队的分片。普通 Session 同一时刻只有一个写入在进行;
+// SequencedSession 按分片顺序分配序号, 由服务端恢复顺序
+func (c *Conn) writeLoop() {
+	defer close(c.writeDone)
+	send := c.forward
+	if s, ok := c.session.(SequencedSession); ok && c.opts.MaxInFlight > 1 {
+		p := &pipeline{conn: c, session: s, slots: make(chan struct{}, c.opts.MaxInFlight)}
+		defer p.wait()
+		send = p.send
+	}
+
+	var pending []byte
+	for {
+		if pending == nil {
+			select {
+			case pending = <-c.writeQueue:
+			case <-c.done:
+				// 关闭前发出已经排队的数据
+				for {
+					select {
+					case chunk := <-c.writeQueue:
+						if !send(chunk) {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+		}
+
+		data, next := c.coalesce(pending)
+		pending = next
+		if !send(data) {
+			return
+		}
+	}
+}
+
+// pipeline 为 SequencedSession 的并发写入, slots 限制同时在途的请求数
+type pipeline struct {
+	conn    *Conn
+	session SequencedSession
+	slots   chan struct{}
+	seq     uint64
+	wg      sync.WaitGroup
+}
+
+// send 等待空闲的 slot 后异步发送, 之前的写入失败或连接已取消时返回 false
+func (p *pipeline) send(data []byte) bool {
+	c := p.conn
+	select {
+	case p.slots <- struct{}{}:
+	case <-c.ctx.Done():
+		return false
+	}
+	c.mu.Lock()
+	failed := c.writeErr != nil
+	c.mu.Unlock()
+	if failed {
+		<-p.slots
+		return false
+	}
+	p.seq++
+	p.wg.Add(1)
+	go func(seq uint64) {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		if err := p.session.WriteSeq(c.ctx, seq, data); err != nil {
+			c.setWriteErr(err)
+			return
+		}
+		notify(c.pollNow)
+	}(p.seq)
+	return true
+}
+
+func (p *pipeline) wait() {
+	p.wg.Wait()
+}
+
+// coalesce 在 CoalesceWindow 内把后续分片合并到 data 中, 合并后不超过 MaxForwardSize,
+// 放不下的分片作为 next 留给下一次发送
+func (c *Conn) coalesce(data []byte) (merged, next []byte) {
+	max := c.opts.MaxForwardSize
+	if max > 0 && len(data) >= max {
+		return data, nil
+	}
+	var window <-chan time.Time
+	if c.opts.CoalesceWindow > 0 {
+		timer := time.NewTimer(c.opts.CoalesceWindow)
+		defer timer.Stop()
+		window = timer.C
+	}
+	for {
+		select {
+		case chunk := <-c.writeQueue:
+			if max > 0 && len(data)+len(chunk) > max {
+				return data, chunk
+			}
+			data = append(data, chunk...)
+			if max > 0 && len(data) == max {
+				return data, nil
+			}
+			continue
+		default:
+		}
+		if window == nil {
+			return data, nil
+		}
+		select {
+		case chunk := <-c.writeQueue:
+			if max > 0 && len(data)+len(chunk) > max {
+				return data, chunk
+			}
+			data = append(data, chunk...)
+		case <-window:
+			return data, nil
+		case <-c.done:
+			return data, nil
+		}
+	}
+}
+
+func (c *Conn) setWriteErr(err error) {
+	c.mu.Lock()
+	if c.writeErr == nil {
+		c.writeErr = err
+	}
+	c.mu.Unlock()
+}
+
+// forward 发送一段数据, 失败时记录 writeErr 并返回 false
+func (c *Conn) forward(data []byte) bool {
+	if err := c.session.Write(c.ctx, data); err != nil {
+		c.setWriteErr(err)
+		return false
+	}
+	// 写入通常会引起响应, 让 readLoop 立即轮询
+	notify(c.pollNow)
+	return true
+}
+
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		close(c.done)
+		// 已排队的数据最多发送 FlushTimeout, 超时后取消进行中的请求并丢弃剩余数据
+		timeout := c.opts.FlushTimeout
+		if timeout <= 0 {
+			timeout = DefaultFlushTimeout
+		}
+		timer := time.AfterFunc(timeout, c.cancel)
+		<-c.writeDone
+		timer.Stop()
+		c.cancel()
+		c.session.Close()
+	})
+	return nil
+}
+
+// LocalAddr 返回隧道服务端地址
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr 返回经由隧道连接的目标地址
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline 同时设置读写截止时间, 超时后 Read/Write 返回 os.ErrDeadlineExceeded。
+// Write 只在发送队列已满时阻塞, 因此写截止时间只约束排队等待
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
@@ -0,0 +1,350 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoSession 模拟服务端, 把写入的数据原样通过 Read 返回, 每次最多 64 字节
+type echoSession struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	forwards []int
+	closed   bool
+}
+
+func (s *echoSession) Read(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte{}, s.buf.Next(64)...), nil
+}
+
+func (s *echoSession) Write(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Write(data)
+	s.forwards = append(s.forwards, len(data))
+	return nil
+}
+
+func (s *echoSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func newEchoConn(bufferSize int) (*Conn, *echoSession) {
+	session := &echoSession{}
+	opts := &ConnOptions{
+		ReadBufferSize: bufferSize,
+		Interval:       time.Millisecond,
+		MaxInterval:    10 * time.Millisecond,
+		MaxForwardSize: 64 * 1024,
+		WriteQueueSize: 8,
+	}
+	return NewConn(session, opts, NewAddr("test", "local"), NewAddr("test", "remote")), session
+}
+
+func TestConnConcurrentReadWriteClose(t *testing.T) {
+	conn, session := newEchoConn(32 * 1024)
+
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < len(payload); i += 100 {
+			if _, err := conn.Write(payload[i : i+100]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	received := make([]byte, len(payload))
+	go func() {
+		defer wg.Done()
+		if _, err := io.ReadFull(conn, received); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+	if !bytes.Equal(received, payload) {
+		t.Fatal("echoed data mismatch")
+	}
+
+	pending := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		pending <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case err := <-pending:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("pending Read error = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock pending Read")
+	}
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write after Close error = %v, want net.ErrClosed", err)
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.closed {
+		t.Error("session not closed")
+	}
+}
+
+func TestConnBackpressure(t *testing.T) {
+	conn, session := newEchoConn(128)
+	defer conn.Close()
+
+	if _, err := conn.Write(make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	conn.mu.Lock()
+	buffered := conn.readBuf.Len()
+	conn.mu.Unlock()
+	session.mu.Lock()
+	remaining := session.buf.Len()
+	session.mu.Unlock()
+	if buffered > 128+64 || remaining == 0 {
+		t.Fatalf("polling not paused: buffered %d, remaining on server %d", buffered, remaining)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnBatchedWrites(t *testing.T) {
+	conn, session := newEchoConn(32 * 1024)
+	conn.opts.MaxForwardSize = 100
+	conn.opts.CoalesceWindow = 50 * time.Millisecond
+	defer conn.Close()
+
+	var payload []byte
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("msg-%05d;", i))
+		payload = append(payload, msg...)
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	large := bytes.Repeat([]byte("abcdefghij"), 50)
+	payload = append(payload, large...)
+	if _, err := conn.Write(large); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("data reordered or corrupted")
+	}
+
+	session.mu.Lock()
+	forwards := append([]int{}, session.forwards...)
+	session.mu.Unlock()
+	if len(forwards) >= 15 {
+		t.Errorf("writes not coalesced: %v", forwards)
+	}
+	for _, size := range forwards {
+		if size > 100 {
+			t.Errorf("forward of %d bytes exceeds MaxForwardSize: %v", size, forwards)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := 100 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		d = backoff(d, time.Second)
+	}
+	if d != time.Second {
+		t.Errorf("backoff = %v, want ceiling 1s", d)
+	}
+	for i := 0; i < 100; i++ {
+		if j := jitter(time.Second, 0.2); j < 800*time.Millisecond || j > 1200*time.Millisecond {
+			t.Fatalf("jitter out of range: %v", j)
+		}
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	conn, _ := newEchoConn(32 * 1024)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read error = %v, want deadline exceeded", err)
+	}
+
+	// 清除截止时间后可以继续读写
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Read after clearing deadline = %q, %v", buf, err)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{MaxRetry: 3, RetryInterval: time.Millisecond}
+	attempts := 0
+	err := policy.Do(context.Background(), func() error {
+		attempts++
+		return errors.New("fail")
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("attempts = %d, err = %v", attempts, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := policy.Do(ctx, func() error { return errors.New("fail") }); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+// seqSession 模拟按序号写入的服务端, 每个 WriteSeq 随机延迟, 按序号恢复数据顺序
+type seqSession struct {
+	echoSession
+	seqMu    sync.Mutex
+	pending  map[uint64][]byte
+	next     uint64
+	inflight int
+	peak     int
+}
+
+func (s *seqSession) WriteSeq(ctx context.Context, seq uint64, data []byte) error {
+	s.seqMu.Lock()
+	s.inflight++
+	if s.inflight > s.peak {
+		s.peak = s.inflight
+	}
+	s.seqMu.Unlock()
+	time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+	s.inflight--
+	s.pending[seq] = data
+	for {
+		data, ok := s.pending[s.next]
+		if !ok {
+			return nil
+		}
+		delete(s.pending, s.next)
+		s.next++
+		s.echoSession.Write(ctx, data)
+	}
+}
+
+func TestConnPipelinedWrites(t *testing.T) {
+	session := &seqSession{pending: make(map[uint64][]byte), next: 1}
+	opts := &ConnOptions{
+		ReadBufferSize: 64 * 1024,
+		Interval:       time.Millisecond,
+		MaxInterval:    10 * time.Millisecond,
+		MaxForwardSize: 100,
+		WriteQueueSize: 8,
+		MaxInFlight:    4,
+	}
+	conn := NewConn(session, opts, NewAddr("test", "local"), NewAddr("test", "remote"))
+	defer conn.Close()
+
+	payload := make([]byte, 8*1024)
+	for i := range payload {
+		payload[i] = byte(i / 100)
+	}
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("data reordered or corrupted")
+	}
+
+	session.seqMu.Lock()
+	defer session.seqMu.Unlock()
+	if session.peak < 2 || session.peak > 4 {
+		t.Errorf("peak in-flight writes = %d, want 2-4", session.peak)
+	}
+}
+
+// blockingSession 的 Write 一直阻塞到 ctx 取消
+type blockingSession struct {
+	echoSession
+}
+
+func (s *blockingSession) Write(ctx context.Context, data []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestConnCloseFlushTimeout(t *testing.T) {
+	opts := &ConnOptions{
+		Interval:       time.Millisecond,
+		MaxForwardSize: 10,
+		WriteQueueSize: 8,
+		FlushTimeout:   50 * time.Millisecond,
+	}
+	conn := NewConn(&blockingSession{}, opts, NewAddr("test", "local"), NewAddr("test", "remote"))
+	conn.Write(bytes.Repeat([]byte("x"), 80))
+
+	start := time.Now()
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v with a stuck session, want about FlushTimeout", elapsed)
+	}
+}
+
+func TestConfigParseQueryInterval(t *testing.T) {
+	for _, v := range []string{"0", "0s", "100us"} {
+		var config Config
+		if err := config.ParseQuery(url.Values{"interval": {v}}); err == nil {
+			t.Errorf("interval=%s accepted", v)
+		}
+	}
+	var config Config
+	if err := config.ParseQuery(url.Values{"interval": {"5ms"}, "inflight": {"4"}}); err != nil {
+		t.Fatal(err)
+	}
+	if config.Interval != 5*time.Millisecond || config.MaxInFlight != 4 {
+		t.Errorf("interval = %s, inflight = %d", config.Interval, config.MaxInFlight)
+	}
+}
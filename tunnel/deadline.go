@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+)
+
+// Deadline 把 net.Conn 的读写截止时间转换为可以 select 的 channel,
+// 截止时间到达时 Wait 返回的 channel 被关闭, 重新设置后换成新的 channel
+type Deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func NewDeadline() *Deadline {
+	return &Deadline{cancel: make(chan struct{})}
+}
+
+// Set 设置截止时间, 零值表示不超时, 过去的时间立即超时
+func (d *Deadline) Set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// timer 已经触发, 等待其关闭 cancel 后再替换
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// Wait 返回截止时间到达时关闭的 channel
+func (d *Deadline) Wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// Exceeded 返回截止时间是否已到
+func (d *Deadline) Exceeded() bool {
+	return isClosed(d.Wait())
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,36 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	d := NewDeadline()
+	if d.Exceeded() {
+		t.Fatal("zero deadline exceeded")
+	}
+
+	d.Set(time.Now().Add(20 * time.Millisecond))
+	select {
+	case <-d.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("deadline did not fire")
+	}
+
+	// 重新设置为将来的时间后恢复
+	d.Set(time.Now().Add(time.Hour))
+	if d.Exceeded() {
+		t.Fatal("deadline still exceeded after reset")
+	}
+
+	d.Set(time.Now().Add(-time.Second))
+	if !d.Exceeded() {
+		t.Fatal("past deadline not exceeded")
+	}
+
+	d.Set(time.Time{})
+	if d.Exceeded() {
+		t.Fatal("cleared deadline exceeded")
+	}
+}
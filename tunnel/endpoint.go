@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUnhealthyTimeout 为请求失败的节点被跳过的时长
+var DefaultUnhealthyTimeout = 30 * time.Second
+
+// endpoint 为负载均衡后的一个服务端节点
+type endpoint struct {
+	url string
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+}
+
+func (e *endpoint) until() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.unhealthyUntil
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return !now.Before(e.until())
+}
+
+func (e *endpoint) markUnhealthy(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unhealthyUntil = time.Now().Add(d)
+}
+
+func (e *endpoint) markHealthy() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unhealthyUntil = time.Time{}
+}
+
+// endpointPool 把新连接轮询分配到健康的节点上
+type endpointPool struct {
+	endpoints []*endpoint
+	next      uint32
+}
+
+func newEndpointPool(urls []string) *endpointPool {
+	pool := &endpointPool{}
+	for _, u := range urls {
+		pool.endpoints = append(pool.endpoints, &endpoint{url: u})
+	}
+	return pool
+}
+
+// pick 轮询返回下一个健康节点, 所有节点都不健康时返回最早恢复的节点
+func (p *endpointPool) pick() *endpoint {
+	if len(p.endpoints) == 0 {
+		return nil
+	}
+	now := time.Now()
+	start := int(atomic.AddUint32(&p.next, 1) % uint32(len(p.endpoints)))
+	for i := 0; i < len(p.endpoints); i++ {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		if e.healthy(now) {
+			return e
+		}
+	}
+	earliest := p.endpoints[0]
+	for _, e := range p.endpoints[1:] {
+		if e.until().Before(earliest.until()) {
+			earliest = e
+		}
+	}
+	return earliest
+}
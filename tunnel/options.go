@@ -0,0 +1,267 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPOptions 为隧道 HTTP 请求的公共配置
+type HTTPOptions struct {
+	// Dial 用于建立到服务端的连接, 可以替换为上游代理
+	Dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	TLSConfig *tls.Config
+	// Transport 不为空时直接使用, 忽略 Dial 与 TLSConfig
+	Transport http.RoundTripper
+	Timeout   time.Duration
+
+	// 请求定制, 让流量与目标站点的正常请求相似
+	Method  string
+	Headers http.Header
+	Cookies []*http.Cookie
+
+	clientOnce sync.Once
+	client     *http.Client
+}
+
+// HTTPClient 返回所有连接共享的 HTTP 客户端, 请求经由 Dial 发出并复用 keep-alive 连接。
+// 首次调用时才创建, 因此在此之前可以替换 Dial 与 TLSConfig。
+func (o *HTTPOptions) HTTPClient() *http.Client {
+	o.clientOnce.Do(func() {
+		if o.Transport != nil {
+			o.client = &http.Client{Timeout: o.Timeout, Transport: o.Transport}
+			return
+		}
+		dial := o.Dial
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		o.client = &http.Client{
+			Timeout: o.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dial,
+				TLSClientConfig:     o.TLSConfig,
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 32,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	})
+	return o.client
+}
+
+// NewRequest 创建带有自定义方法、请求头与 cookie 的请求, 请求体由 Codec 设置
+func (o *HTTPOptions) NewRequest(ctx context.Context, target string) (*http.Request, error) {
+	method := o.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if o.Headers != nil {
+		req.Header = o.Headers.Clone()
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	for _, cookie := range o.Cookies {
+		req.AddCookie(cookie)
+	}
+	return req, nil
+}
+
+// ParseHTTPOptions 解析请求定制参数: method, header (可重复, "Name: value"), ua, cookie ("a=1; b=2", 可重复)
+func ParseHTTPOptions(o *HTTPOptions, query url.Values) error {
+	if v := query.Get("method"); v != "" {
+		o.Method = strings.ToUpper(v)
+	}
+	if o.Headers == nil && (len(query["header"]) > 0 || query.Get("ua") != "") {
+		o.Headers = make(http.Header)
+	}
+	for _, header := range query["header"] {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid header: %s", header)
+		}
+		o.Headers.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if v := query.Get("ua"); v != "" {
+		o.Headers.Set("User-Agent", v)
+	}
+	for _, cookie := range query["cookie"] {
+		o.Cookies = append(o.Cookies, (&http.Request{Header: http.Header{"Cookie": {cookie}}}).Cookies()...)
+	}
+	return nil
+}
+
+// RetryPolicy 为建立连接时的重试策略, 第 n 次重试前等待 n*RetryInterval
+type RetryPolicy struct {
+	MaxRetry      int
+	RetryInterval time.Duration
+}
+
+// Do 执行 fn 直到成功, 最多尝试 MaxRetry 次 (至少一次), ctx 取消时立即返回
+func (p *RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	attempts := p.MaxRetry
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(time.Duration(i) * p.RetryInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// MinPollInterval 为 Client 建立的轮询式连接的最小轮询间隔
+var MinPollInterval = time.Millisecond
+
+// ConnOptions 控制 Conn 的轮询与写入
+type ConnOptions struct {
+	// ReadBufferSize 为读缓冲区上限, 达到后暂停轮询, 直到 Read 取走数据
+	ReadBufferSize int
+	// 轮询间隔在有数据时保持为 Interval, 空闲时指数退避到 MaxInterval,
+	// Jitter 为随机抖动比例 (0-1)。Interval 为 0 时不等待, 只用于 Session.Read 本身会阻塞的流式协议,
+	// Client 建立的轮询式连接至少使用 MinPollInterval
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Jitter      float64
+	// 小块写入在 CoalesceWindow 内合并, 大块写入按 MaxForwardSize 分片 (0 表示不分片),
+	// 最多 WriteQueueSize 个分片排队等待发送, 队列满时 Write 阻塞
+	CoalesceWindow time.Duration
+	MaxForwardSize int
+	WriteQueueSize int
+	// MaxInFlight 为同时在途的写请求数, 只对实现 SequencedSession 的会话生效,
+	// 其他会话逐个发送, 因为没有序号的 FORWARD 并发发送时服务端可能乱序写入目标
+	MaxInFlight int
+	// FlushTimeout 为 Close 时发送已排队数据的最长时间, 超时后丢弃剩余数据, 0 表示使用 DefaultFlushTimeout
+	FlushTimeout time.Duration
+}
+
+// ParseConnOptions 解析轮询与写入参数: buffer_size, interval, max_interval, jitter, coalesce, max_forward, write_queue, inflight。
+// 无效的值被忽略, 保留原有配置
+func ParseConnOptions(o *ConnOptions, query url.Values) {
+	if v := query.Get("buffer_size"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			o.ReadBufferSize = n
+		}
+	}
+	if v := query.Get("interval"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			o.Interval = d
+		}
+	}
+	if v := query.Get("max_interval"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			o.MaxInterval = d
+		}
+	}
+	if v := query.Get("jitter"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			o.Jitter = f
+		}
+	}
+	if v := query.Get("coalesce"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			o.CoalesceWindow = d
+		}
+	}
+	if v := query.Get("max_forward"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			o.MaxForwardSize = n
+		}
+	}
+	if v := query.Get("write_queue"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			o.WriteQueueSize = n
+		}
+	}
+	if v := query.Get("inflight"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			o.MaxInFlight = n
+		}
+	}
+}
+
+// Config 为基于 HTTP 请求/响应的隧道协议的公共配置, 由 Client 使用
+type Config struct {
+	HTTPOptions
+	RetryPolicy
+	ConnOptions
+
+	// Endpoints 为同一个隧道的多个服务端地址, 新连接轮询分配到健康的节点上,
+	// 每个连接固定在建立时的节点, 并使用独立的 cookie jar 保持负载均衡的会话粘性
+	Endpoints        []string
+	UnhealthyTimeout time.Duration
+	// AffinityHeader 不为空时, 记录服务端响应中该头的值并在同一连接的后续请求中带上
+	AffinityHeader string
+
+	poolOnce sync.Once
+	pool     *endpointPool
+}
+
+// ParseQuery 解析公共参数: timeout, retry, endpoints, unhealthy_timeout, affinity_header,
+// 以及 ParseHTTPOptions 与 ParseConnOptions 支持的参数, interval 不能小于 MinPollInterval
+func (c *Config) ParseQuery(query url.Values) error {
+	if v := query.Get("timeout"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			c.Timeout = d
+		}
+	}
+	if v := query.Get("retry"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.MaxRetry = n
+		}
+	}
+	if v := query.Get("endpoints"); v != "" {
+		for _, endpoint := range strings.Split(v, ",") {
+			u, err := url.Parse(endpoint)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("invalid endpoint: %s", endpoint)
+			}
+			c.Endpoints = append(c.Endpoints, endpoint)
+		}
+	}
+	if v := query.Get("unhealthy_timeout"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			c.UnhealthyTimeout = d
+		}
+	}
+	c.AffinityHeader = query.Get("affinity_header")
+	// 每次轮询都是一次 HTTP 请求, interval 为 0 会不断请求服务端
+	if v := query.Get("interval"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d < MinPollInterval {
+			return fmt.Errorf("invalid interval: %s, must be at least %s", v, MinPollInterval)
+		}
+	}
+	ParseConnOptions(&c.ConnOptions, query)
+	return ParseHTTPOptions(&c.HTTPOptions, query)
+}
+
+func (c *Config) endpointPool() *endpointPool {
+	c.poolOnce.Do(func() {
+		c.pool = newEndpointPool(c.Endpoints)
+	})
+	return c.pool
+}
@@ -0,0 +1,32 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// TLSConfigFromQuery 解析与其他协议一致的 tls-domain / tls-insecure-skip-verify / tls-ca-file 参数
+func TLSConfigFromQuery(host string, query url.Values) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         query.Get("tls-domain"),
+		InsecureSkipVerify: query.Get("tls-insecure-skip-verify") == "true",
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = host
+	}
+	if caFile := query.Get("tls-ca-file"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		conf.RootCAs = certPool
+	}
+	return conf, nil
+}
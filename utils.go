@@ -10,6 +10,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/chainreactors/proxyclient/proxyproto"
 )
 
 func ParseProxyURLs(proxyURL []string) ([]*url.URL, error) {
@@ -50,6 +52,22 @@ func DialWithTimeout(timeout time.Duration) Dial {
 	return dialer.DialContext
 }
 
+// WithProxyProtocol 在连接建立后、应用数据之前写入 PROXY 协议头,
+// 源地址与目的地址分别取自连接的 LocalAddr 与 RemoteAddr
+func WithProxyProtocol(dial Dial, version int) Dial {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if err = proxyproto.WriteHeader(conn, version, conn.LocalAddr(), conn.RemoteAddr()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
 func decodedBase64EncodedURL(proxy *url.URL) (*url.URL, error) {
 	if proxy.Scheme == "" && proxy.Host == "" {
 		return proxy, nil
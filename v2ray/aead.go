@@ -0,0 +1,275 @@
+package v2ray
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/sha3"
+)
+
+// VMess AEAD 的 KDF 路径
+const (
+	kdfSaltVMessAEADKDF           = "VMess AEAD KDF"
+	kdfSaltAuthIDEncryptionKey    = "AES Auth ID Encryption"
+	kdfSaltRespHeaderLenKey       = "AEAD Resp Header Len Key"
+	kdfSaltRespHeaderLenIV        = "AEAD Resp Header Len IV"
+	kdfSaltRespHeaderPayloadKey   = "AEAD Resp Header Key"
+	kdfSaltRespHeaderPayloadIV    = "AEAD Resp Header IV"
+	kdfSaltHeaderPayloadKey       = "VMess Header AEAD Key"
+	kdfSaltHeaderPayloadIV        = "VMess Header AEAD Nonce"
+	kdfSaltHeaderPayloadLengthKey = "VMess Header AEAD Key_Length"
+	kdfSaltHeaderPayloadLengthIV  = "VMess Header AEAD Nonce_Length"
+
+	// authIDMaxDelta 为服务端接受的 AuthID 时间偏差
+	authIDMaxDelta = 120 * time.Second
+)
+
+// kdf 为 VMess AEAD 的密钥派生: 以 "VMess AEAD KDF" 为最内层密钥、path 依次嵌套的 HMAC-SHA256
+func kdf(key []byte, path ...string) []byte {
+	creator := func() hash.Hash {
+		return hmac.New(sha256.New, []byte(kdfSaltVMessAEADKDF))
+	}
+	for _, p := range path {
+		parent, value := creator, []byte(p)
+		creator = func() hash.Hash {
+			return hmac.New(parent, value)
+		}
+	}
+	h := creator()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func kdf16(key []byte, path ...string) []byte {
+	return kdf(key, path...)[:16]
+}
+
+// cmdKey 为用户 ID 派生的命令密钥
+func cmdKey(id [16]byte) []byte {
+	h := md5.New()
+	h.Write(id[:])
+	h.Write([]byte("c48619fe-8f02-49e0-b9e9-edf763e17e21"))
+	return h.Sum(nil)
+}
+
+// createAuthID 生成 AuthID: AES(Timestamp(8) Rand(4) CRC32(4))
+func createAuthID(key []byte, now time.Time) [16]byte {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return newAuthID(key, now, random)
+}
+
+// newAuthID 使用给定的随机数生成 AuthID
+func newAuthID(key []byte, now time.Time, random []byte) [16]byte {
+	var plain, authID [16]byte
+	binary.BigEndian.PutUint64(plain[:8], uint64(now.Unix()))
+	copy(plain[8:12], random)
+	binary.BigEndian.PutUint32(plain[12:], crc32.ChecksumIEEE(plain[:12]))
+	block, _ := aes.NewCipher(kdf16(key, kdfSaltAuthIDEncryptionKey))
+	block.Encrypt(authID[:], plain[:])
+	return authID
+}
+
+// checkAuthID 校验 AuthID 的 CRC 与时间
+func checkAuthID(key []byte, authID []byte, now time.Time) bool {
+	var plain [16]byte
+	block, _ := aes.NewCipher(kdf16(key, kdfSaltAuthIDEncryptionKey))
+	block.Decrypt(plain[:], authID)
+	if binary.BigEndian.Uint32(plain[12:]) != crc32.ChecksumIEEE(plain[:12]) {
+		return false
+	}
+	delta := now.Sub(time.Unix(int64(binary.BigEndian.Uint64(plain[:8])), 0))
+	return delta < authIDMaxDelta && delta > -authIDMaxDelta
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// sealHeader 加密请求头: AuthID EncryptedLength(2+16) Nonce(8) EncryptedHeader
+func sealHeader(key []byte, header []byte) []byte {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	return sealHeaderWith(key, header, createAuthID(key, time.Now()), nonce)
+}
+
+// sealHeaderWith 使用给定的 AuthID 与 nonce 加密请求头
+func sealHeaderWith(key []byte, header []byte, authID [16]byte, nonce []byte) []byte {
+	length := binary.BigEndian.AppendUint16(nil, uint16(len(header)))
+	lengthKey := kdf16(key, kdfSaltHeaderPayloadLengthKey, string(authID[:]), string(nonce))
+	lengthIV := kdf(key, kdfSaltHeaderPayloadLengthIV, string(authID[:]), string(nonce))[:12]
+	payloadKey := kdf16(key, kdfSaltHeaderPayloadKey, string(authID[:]), string(nonce))
+	payloadIV := kdf(key, kdfSaltHeaderPayloadIV, string(authID[:]), string(nonce))[:12]
+
+	out := append([]byte{}, authID[:]...)
+	out = newGCM(lengthKey).Seal(out, lengthIV, length, authID[:])
+	out = append(out, nonce...)
+	return newGCM(payloadKey).Seal(out, payloadIV, header, authID[:])
+}
+
+// openHeader 读取并解密 sealHeader 生成的请求头, authID 已经读取并校验
+func openHeader(r io.Reader, key []byte, authID []byte) ([]byte, error) {
+	buf := make([]byte, 2+16+8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	nonce := buf[18:]
+	lengthKey := kdf16(key, kdfSaltHeaderPayloadLengthKey, string(authID), string(nonce))
+	lengthIV := kdf(key, kdfSaltHeaderPayloadLengthIV, string(authID), string(nonce))[:12]
+	length, err := newGCM(lengthKey).Open(nil, lengthIV, buf[:18], authID)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, int(binary.BigEndian.Uint16(length))+16)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	payloadKey := kdf16(key, kdfSaltHeaderPayloadKey, string(authID), string(nonce))
+	payloadIV := kdf(key, kdfSaltHeaderPayloadIV, string(authID), string(nonce))[:12]
+	return newGCM(payloadKey).Open(nil, payloadIV, payload, authID)
+}
+
+// sealResponseHeader 加密响应头: EncryptedLength(2+16) EncryptedHeader
+func sealResponseHeader(key, iv []byte, header []byte) []byte {
+	length := binary.BigEndian.AppendUint16(nil, uint16(len(header)))
+	out := newGCM(kdf16(key, kdfSaltRespHeaderLenKey)).Seal(nil, kdf(iv, kdfSaltRespHeaderLenIV)[:12], length, nil)
+	return newGCM(kdf16(key, kdfSaltRespHeaderPayloadKey)).Seal(out, kdf(iv, kdfSaltRespHeaderPayloadIV)[:12], header, nil)
+}
+
+// openResponseHeader 读取并解密响应头
+func openResponseHeader(r io.Reader, key, iv []byte) ([]byte, error) {
+	buf := make([]byte, 2+16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	length, err := newGCM(kdf16(key, kdfSaltRespHeaderLenKey)).Open(nil, kdf(iv, kdfSaltRespHeaderLenIV)[:12], buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, int(binary.BigEndian.Uint16(length))+16)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return newGCM(kdf16(key, kdfSaltRespHeaderPayloadKey)).Open(nil, kdf(iv, kdfSaltRespHeaderPayloadIV)[:12], payload, nil)
+}
+
+// responseKey 由请求的 key/iv 派生响应的 key/iv
+func responseKey(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:16]
+}
+
+// newBodyAEAD 返回数据块使用的 AEAD, SecurityNone 时返回 nil
+func newBodyAEAD(security byte, key []byte) cipher.AEAD {
+	switch security {
+	case SecurityAES128GCM:
+		return newGCM(key)
+	case SecurityChacha20Poly1305:
+		// 32 字节密钥为 MD5(key) 与 MD5(MD5(key))
+		k1 := md5.Sum(key)
+		k2 := md5.Sum(k1[:])
+		aead, _ := chacha20poly1305.New(append(k1[:], k2[:]...))
+		return aead
+	}
+	return nil
+}
+
+// chunkCodec 为一个方向的数据块编码: Length(2, SHAKE128 掩码) Payload(AEAD) Padding
+type chunkCodec struct {
+	aead    cipher.AEAD
+	iv      []byte
+	count   uint16
+	shake   sha3.ShakeHash
+	padding bool
+}
+
+func newChunkCodec(security byte, key, iv []byte, padding bool) *chunkCodec {
+	shake := sha3.NewShake128()
+	shake.Write(iv)
+	return &chunkCodec{aead: newBodyAEAD(security, key), iv: iv, shake: shake, padding: padding}
+}
+
+func (c *chunkCodec) next() uint16 {
+	var b [2]byte
+	c.shake.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+func (c *chunkCodec) nonce() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint16(nonce, c.count)
+	copy(nonce[2:], c.iv[2:12])
+	c.count++
+	return nonce
+}
+
+func (c *chunkCodec) overhead() int {
+	if c.aead == nil {
+		return 0
+	}
+	return c.aead.Overhead()
+}
+
+// seal 编码一个数据块, 空的 payload 表示数据结束
+func (c *chunkCodec) seal(dst, payload []byte) []byte {
+	var padding int
+	if c.padding {
+		padding = int(c.next() % 64)
+	}
+	size := len(payload) + c.overhead() + padding
+	dst = binary.BigEndian.AppendUint16(dst, uint16(size)^c.next())
+	if c.aead != nil {
+		dst = c.aead.Seal(dst, c.nonce(), payload, nil)
+	} else {
+		dst = append(dst, payload...)
+	}
+	if padding > 0 {
+		pad := make([]byte, padding)
+		rand.Read(pad)
+		dst = append(dst, pad...)
+	}
+	return dst
+}
+
+// open 读取一个数据块, 数据结束时返回 io.EOF
+func (c *chunkCodec) open(r io.Reader, buf []byte) ([]byte, error) {
+	var padding int
+	if c.padding {
+		padding = int(c.next() % 64)
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(head[:]) ^ c.next())
+	if size < c.overhead()+padding || size > len(buf) {
+		return nil, errors.New("vmess: invalid chunk size")
+	}
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		return nil, err
+	}
+	data := buf[:size-padding]
+	if c.aead != nil {
+		var err error
+		if data, err = c.aead.Open(data[:0], c.nonce(), data, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	return data, nil
+}
@@ -0,0 +1,282 @@
+package v2ray
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/relay"
+)
+
+// DefaultDialTimeout 为服务端连接目标的超时时间
+var DefaultDialTimeout = 5 * time.Second
+
+// Server 为 Go 实现的 VLESS 与 VMess (AEAD) 服务端, 用于本地测试:
+// 校验用户 ID 后转发 TCP 连接或 UDP 包, 认证失败时直接关闭连接。
+// 只处理原始 TCP 连接, tls 与 ws 由调用方在 listener 或 ws.Handler 中处理
+type Server struct {
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	vmess bool
+	users map[[16]byte]struct{}
+	keys  [][]byte
+}
+
+// NewVLESSServer 创建接受 ids 中任意用户的 VLESS 服务端
+func NewVLESSServer(ids ...[16]byte) *Server {
+	return newServer(false, ids)
+}
+
+// NewVMessServer 创建接受 ids 中任意用户的 VMess 服务端
+func NewVMessServer(ids ...[16]byte) *Server {
+	return newServer(true, ids)
+}
+
+func newServer(vmess bool, ids [][16]byte) *Server {
+	s := &Server{
+		Dial:  (&net.Dialer{Timeout: DefaultDialTimeout}).DialContext,
+		vmess: vmess,
+		users: make(map[[16]byte]struct{}),
+	}
+	for _, id := range ids {
+		s.users[id] = struct{}{}
+		s.keys = append(s.keys, cmdKey(id))
+	}
+	return s
+}
+
+// Serve 在 listener 上接受连接, 直到 listener 关闭
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn 处理一个客户端连接
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	reader := bufio.NewReader(conn)
+	if s.vmess {
+		s.serveVMess(conn, reader)
+	} else {
+		s.serveVLESS(conn, reader)
+	}
+}
+
+func (s *Server) serveVLESS(conn net.Conn, reader *bufio.Reader) {
+	head := make([]byte, 1+16+1)
+	if _, err := io.ReadFull(reader, head); err != nil || head[0] != vlessVersion {
+		return
+	}
+	var id [16]byte
+	copy(id[:], head[1:17])
+	if _, ok := s.users[id]; !ok {
+		return
+	}
+	if _, err := reader.Discard(int(head[17])); err != nil {
+		return
+	}
+	command, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	address, err := readAddress(reader)
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	response := []byte{vlessVersion, 0}
+	switch command {
+	case commandTCP:
+		remote, err := s.Dial(context.Background(), "tcp", address)
+		if err != nil {
+			return
+		}
+		defer remote.Close()
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+		relay.Relay(&bufferedConn{Conn: conn, r: reader}, remote, 0)
+	case commandUDP:
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+		s.serveUDP(address, func(buf []byte) ([]byte, error) {
+			var head [2]byte
+			if _, err := io.ReadFull(reader, head[:]); err != nil {
+				return nil, err
+			}
+			n := int(binary.BigEndian.Uint16(head[:]))
+			if _, err := io.ReadFull(reader, buf[:n]); err != nil {
+				return nil, err
+			}
+			return buf[:n], nil
+		}, func(payload []byte) error {
+			_, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(payload))), payload...))
+			return err
+		})
+	}
+}
+
+func (s *Server) serveVMess(conn net.Conn, reader *bufio.Reader) {
+	authID := make([]byte, 16)
+	if _, err := io.ReadFull(reader, authID); err != nil {
+		return
+	}
+	var key []byte
+	for _, k := range s.keys {
+		if checkAuthID(k, authID, time.Now()) {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return
+	}
+	header, err := openHeader(reader, key, authID)
+	if err != nil {
+		return
+	}
+	// Ver IV(16) Key(16) V Opt P|Sec Rsv Cmd, 之后为地址、填充与 FNV1a
+	if len(header) < 38+4 || header[0] != vmessVersion {
+		return
+	}
+	h := fnv.New32a()
+	h.Write(header[:len(header)-4])
+	if h.Sum32() != binary.BigEndian.Uint32(header[len(header)-4:]) {
+		return
+	}
+	iv, bodyKey, v, options := header[1:17], header[17:33], header[33], header[34]
+	security, command := header[35]&0x0f, header[37]
+	address, err := readAddress(bytes.NewReader(header[38 : len(header)-4]))
+	if err != nil {
+		return
+	}
+	if options&optionChunkStream == 0 || options&optionChunkMasking == 0 {
+		return
+	}
+	switch security {
+	case SecurityAES128GCM, SecurityChacha20Poly1305, SecurityNone:
+	default:
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	padding := options&optionGlobalPadding != 0
+	respKey, respIV := responseKey(bodyKey), responseKey(iv)
+	reqCodec := newChunkCodec(security, bodyKey, iv, padding)
+	respCodec := newChunkCodec(security, respKey, respIV, padding)
+	var writeMu sync.Mutex
+	writeChunk := func(payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := conn.Write(respCodec.seal(nil, payload))
+		return err
+	}
+	readChunk := func(buf []byte) ([]byte, error) {
+		return reqCodec.open(reader, buf)
+	}
+	response := sealResponseHeader(respKey, respIV, []byte{v, 0, 0, 0})
+
+	switch command {
+	case commandTCP:
+		remote, err := s.Dial(context.Background(), "tcp", address)
+		if err != nil {
+			return
+		}
+		defer remote.Close()
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+		go func() {
+			buf := make([]byte, maxPacketSize)
+			for {
+				data, err := readChunk(buf)
+				if err != nil {
+					remote.Close()
+					return
+				}
+				if _, err := remote.Write(data); err != nil {
+					return
+				}
+			}
+		}()
+		buf := make([]byte, maxChunkPayload)
+		for {
+			n, err := remote.Read(buf)
+			if n > 0 {
+				if err := writeChunk(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				writeChunk(nil)
+				return
+			}
+		}
+	case commandUDP:
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+		s.serveUDP(address, readChunk, writeChunk)
+	}
+}
+
+// serveUDP 把 readPacket 读到的包发送到 address, 并用 writePacket 写回响应
+func (s *Server) serveUDP(address string, readPacket func([]byte) ([]byte, error), writePacket func([]byte) error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return
+	}
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return
+	}
+	defer pc.Close()
+
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if err := writePacket(buf[:n]); err != nil {
+				pc.Close()
+				return
+			}
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		payload, err := readPacket(buf)
+		if err != nil {
+			return
+		}
+		if _, err := pc.WriteTo(payload, udpAddr); err != nil {
+			return
+		}
+	}
+}
+
+// bufferedConn 从 r 读取, 保留请求头之后已经读入缓冲区的数据
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,175 @@
+package v2ray
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	commandTCP byte = 0x01
+	commandUDP byte = 0x02
+
+	addrTypeIPv4   byte = 0x01
+	addrTypeDomain byte = 0x02
+	addrTypeIPv6   byte = 0x03
+)
+
+var DefaultTimeout = 10 * time.Second
+
+var errInvalidAddress = errors.New("v2ray: invalid address")
+
+// StreamSettings 为分享链接中的传输层参数, 由 proxyclient 转换为 ws 与 tls 承载层
+type StreamSettings struct {
+	// Network 为 tcp 或 ws
+	Network string
+	// Security 为 none 或 tls
+	Security string
+	// Host 为 WebSocket 请求的 Host 头, Path 为 WebSocket 路径
+	Host string
+	Path string
+	// SNI 为 TLS 的 SNI, 为空时使用 Host
+	SNI           string
+	AllowInsecure bool
+}
+
+// check 检查是否为支持的传输方式
+func (s *StreamSettings) check() error {
+	switch s.Network {
+	case "", "tcp":
+		s.Network = "tcp"
+	case "ws":
+	default:
+		return fmt.Errorf("v2ray: unsupported network: %s", s.Network)
+	}
+	switch s.Security {
+	case "", "none":
+		s.Security = "none"
+	case "tls":
+	default:
+		return fmt.Errorf("v2ray: unsupported security: %s", s.Security)
+	}
+	return nil
+}
+
+// parseStreamSettings 解析分享链接 query 中的 type、security、host、path、sni (或 peer)、allowInsecure、headerType
+func parseStreamSettings(query url.Values) (StreamSettings, error) {
+	settings := StreamSettings{
+		Network:  strings.ToLower(query.Get("type")),
+		Security: strings.ToLower(query.Get("security")),
+		Host:     query.Get("host"),
+		Path:     query.Get("path"),
+		SNI:      query.Get("sni"),
+	}
+	if settings.SNI == "" {
+		settings.SNI = query.Get("peer")
+	}
+	// 分享链接使用驼峰参数名, 经过 proxyclient 时参数名已转为小写
+	for _, name := range []string{"allowInsecure", "allowinsecure"} {
+		if v := query.Get(name); v == "1" || strings.EqualFold(v, "true") {
+			settings.AllowInsecure = true
+		}
+	}
+	for _, name := range []string{"headerType", "headertype"} {
+		if v := query.Get(name); v != "" && v != "none" {
+			return settings, fmt.Errorf("v2ray: unsupported header type: %s", v)
+		}
+	}
+	return settings, settings.check()
+}
+
+// ParseUUID 解析 xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx 形式的用户 ID
+func ParseUUID(s string) ([16]byte, error) {
+	var id [16]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return id, fmt.Errorf("v2ray: invalid uuid: %s", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// serverAddress 返回 host:port, 端口为空时使用 443
+func serverAddress(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// appendAddress 按 VLESS/VMess 的格式写入目标地址: Port(2) ATYP ADDR
+func appendAddress(dst []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errInvalidAddress
+	}
+	dst = binary.BigEndian.AppendUint16(dst, uint16(port))
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			dst = append(dst, addrTypeIPv4)
+			return append(dst, ip4...), nil
+		}
+		dst = append(dst, addrTypeIPv6)
+		return append(dst, ip.To16()...), nil
+	}
+	if len(host) == 0 || len(host) > 255 {
+		return nil, errInvalidAddress
+	}
+	dst = append(dst, addrTypeDomain, byte(len(host)))
+	return append(dst, host...), nil
+}
+
+// readAddress 读取 appendAddress 写入的目标地址
+func readAddress(r io.Reader) (string, error) {
+	var head [3]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return "", err
+	}
+	port := strconv.Itoa(int(binary.BigEndian.Uint16(head[:2])))
+	var host []byte
+	switch head[2] {
+	case addrTypeIPv4:
+		host = make([]byte, net.IPv4len)
+	case addrTypeIPv6:
+		host = make([]byte, net.IPv6len)
+	case addrTypeDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		host = make([]byte, n[0])
+	default:
+		return "", errInvalidAddress
+	}
+	if _, err := io.ReadFull(r, host); err != nil {
+		return "", err
+	}
+	if head[2] == addrTypeDomain {
+		return net.JoinHostPort(string(host), port), nil
+	}
+	return net.JoinHostPort(net.IP(host).String(), port), nil
+}
+
+// commandByNetwork 返回 network 对应的命令
+func commandByNetwork(network string) (byte, error) {
+	switch strings.ToLower(network) {
+	case "tcp", "tcp4", "tcp6":
+		return commandTCP, nil
+	case "udp", "udp4", "udp6":
+		return commandUDP, nil
+	default:
+		return 0, fmt.Errorf("v2ray: unsupported network: %s", network)
+	}
+}
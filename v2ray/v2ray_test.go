@@ -0,0 +1,173 @@
+package v2ray
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chainreactors/proxyclient/internal/testutil"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// newTestServer 启动 vless 或 vmess 服务端, 返回其地址
+func newTestServer(t *testing.T, server *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener)
+	return listener.Addr().String()
+}
+
+func testEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	payload := bytes.Repeat([]byte("v2ray"), 32*1024)
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("echoed data mismatch")
+	}
+}
+
+func testPacketEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, packet := range []string{"first", "second packet", "third"} {
+		if _, err := conn.Write([]byte(packet)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 2048)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != packet {
+			t.Errorf("received %q, want %q", buf[:n], packet)
+		}
+	}
+}
+
+func TestVLESS(t *testing.T) {
+	target, packetTarget := testutil.Echo(t), testutil.EchoPacket(t)
+	id, _ := ParseUUID(testUUID)
+	server := newTestServer(t, NewVLESSServer(id))
+
+	proxyURL, _ := url.Parse("vless://" + testUUID + "@" + server + "?encryption=none")
+	conf, err := NewVLESSConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &VLESSClient{Proxy: proxyURL, Conf: conf}
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, conn)
+	conn, err = client.Dial("udp", packetTarget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketEcho(t, conn)
+
+	// 用户 ID 错误时服务端关闭连接
+	conf.UUID[0] ^= 0xff
+	conn, err = client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 5)); err == nil {
+		t.Error("read with wrong uuid succeeded")
+	}
+}
+
+func TestVMess(t *testing.T) {
+	target, packetTarget := testutil.Echo(t), testutil.EchoPacket(t)
+	id, _ := ParseUUID(testUUID)
+	server := newTestServer(t, NewVMessServer(id))
+
+	for _, security := range []string{"aes-128-gcm", "chacha20-poly1305", "none"} {
+		proxyURL, _ := url.Parse("vmess://" + testUUID + "@" + server + "?encryption=" + security)
+		conf, err := NewVMessConfFromURL(proxyURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &VMessClient{Proxy: proxyURL, Conf: conf}
+		conn, err := client.Dial("tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testEcho(t, conn)
+		conn, err = client.Dial("udp", packetTarget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPacketEcho(t, conn)
+	}
+
+	proxyURL, _ := url.Parse("vmess://00000000-0000-0000-0000-000000000000@" + server)
+	conf, _ := NewVMessConfFromURL(proxyURL)
+	conn, err := (&VMessClient{Proxy: proxyURL, Conf: conf}).Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 5)); err == nil {
+		t.Error("read with wrong uuid succeeded")
+	}
+}
+
+func TestNewConfFromURL(t *testing.T) {
+	proxyURL, _ := url.Parse("vless://" + testUUID + "@example.com?encryption=none&security=tls&type=ws&host=cdn.example.com&path=%2Fws&allowInsecure=1#node")
+	conf, err := NewVLESSConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := StreamSettings{Network: "ws", Security: "tls", Host: "cdn.example.com", Path: "/ws", AllowInsecure: true}
+	if conf.Server != "example.com:443" || conf.Stream != stream {
+		t.Errorf("server = %s, stream = %+v", conf.Server, conf.Stream)
+	}
+
+	// v2rayN 格式, port 与 aid 为数字
+	link := `{"v":"2","ps":"node","add":"1.2.3.4","port":8443,"id":"` + testUUID + `","aid":0,"scy":"chacha20-poly1305","net":"ws","type":"none","host":"cdn.example.com","path":"/ray","tls":"tls","sni":"sni.example.com"}`
+	proxyURL, _ = url.Parse("vmess://" + base64.StdEncoding.EncodeToString([]byte(link)))
+	vmessConf, err := NewVMessConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream = StreamSettings{Network: "ws", Security: "tls", Host: "cdn.example.com", Path: "/ray", SNI: "sni.example.com"}
+	if vmessConf.Server != "1.2.3.4:8443" || vmessConf.Security != SecurityChacha20Poly1305 || vmessConf.Stream != stream {
+		t.Errorf("server = %s, security = %d, stream = %+v", vmessConf.Server, vmessConf.Security, vmessConf.Stream)
+	}
+
+	for _, raw := range []string{
+		"vless://example.com:443",
+		"vless://" + testUUID + "@example.com?flow=xtls-rprx-vision",
+		"vless://" + testUUID + "@example.com?type=grpc",
+		"vmess://" + testUUID + "@example.com?encryption=zero",
+		"vmess://" + testUUID + "@example.com?headerType=http",
+		"vmess://not-base64!",
+	} {
+		proxyURL, _ := url.Parse(raw)
+		var err error
+		if proxyURL.Scheme == "vless" {
+			_, err = NewVLESSConfFromURL(proxyURL)
+		} else {
+			_, err = NewVMessConfFromURL(proxyURL)
+		}
+		if err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
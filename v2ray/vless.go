@@ -0,0 +1,194 @@
+package v2ray
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+const vlessVersion byte = 0
+
+// maxPacketSize 为 UDP 包的最大长度
+const maxPacketSize = 65535
+
+// VLESSClient 实现了Client接口, 每次 Dial 建立一个到服务端的连接并发送 VLESS 请求头
+type VLESSClient struct {
+	Proxy *url.URL
+	Conf  *VLESSConf
+}
+
+// VLESSConf 配置结构
+type VLESSConf struct {
+	// Dial 用于建立到服务端的连接, proxyclient 会按 Stream 包装 ws 与 tls 承载层
+	Dial   func(ctx context.Context, network, address string) (net.Conn, error)
+	Server string
+	UUID   [16]byte
+	Stream StreamSettings
+
+	Timeout time.Duration
+}
+
+// NewVLESSConfFromURL 从分享链接 vless://uuid@host:port?encryption=none&security=tls&type=ws&... 中解析配置,
+// 只支持 encryption=none, 不支持 flow (xtls)
+func NewVLESSConfFromURL(proxyURL *url.URL) (*VLESSConf, error) {
+	if proxyURL.User == nil {
+		return nil, errors.New("vless: uuid is required")
+	}
+	id, err := ParseUUID(proxyURL.User.Username())
+	if err != nil {
+		return nil, err
+	}
+	query := proxyURL.Query()
+	if v := query.Get("encryption"); v != "" && v != "none" {
+		return nil, fmt.Errorf("vless: unsupported encryption: %s", v)
+	}
+	if v := query.Get("flow"); v != "" {
+		return nil, fmt.Errorf("vless: unsupported flow: %s", v)
+	}
+	stream, err := parseStreamSettings(query)
+	if err != nil {
+		return nil, err
+	}
+	conf := &VLESSConf{
+		Dial:    (&net.Dialer{}).DialContext,
+		Server:  serverAddress(proxyURL),
+		UUID:    id,
+		Stream:  stream,
+		Timeout: DefaultTimeout,
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", v)
+		}
+		conf.Timeout = d
+	}
+	return conf, nil
+}
+
+func (c *VLESSClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext 连接服务端后立即发送请求头, 响应头在第一次 Read 时读取
+func (c *VLESSClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	command, err := commandByNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	// 请求头: Version UUID AddonsLen Command Port ATYP ADDR
+	header := append([]byte{vlessVersion}, c.Conf.UUID[:]...)
+	header = append(header, 0, command)
+	if header, err = appendAddress(header, address); err != nil {
+		return nil, err
+	}
+
+	if c.Conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Conf.Timeout)
+		defer cancel()
+	}
+	conn, err := c.Conf.Dial(ctx, "tcp", c.Conf.Server)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	vc := &vlessConn{Conn: conn, reader: bufio.NewReader(conn), remote: tunnel.NewAddr(network, address)}
+	if command == commandUDP {
+		return &vlessPacketConn{vlessConn: vc}, nil
+	}
+	return vc, nil
+}
+
+// vlessConn 在第一次 Read 时跳过响应头: Version AddonsLen Addons
+type vlessConn struct {
+	net.Conn
+	remote net.Addr
+
+	readMu sync.Mutex
+	reader *bufio.Reader
+	ready  bool
+}
+
+func (c *vlessConn) readResponse() error {
+	if c.ready {
+		return nil
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return err
+	}
+	if head[0] != vlessVersion {
+		return fmt.Errorf("vless: unexpected response version %d", head[0])
+	}
+	if _, err := c.reader.Discard(int(head[1])); err != nil {
+		return err
+	}
+	c.ready = true
+	return nil
+}
+
+func (c *vlessConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if err := c.readResponse(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *vlessConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// vlessPacketConn 每个 UDP 包以 Length(2) 为前缀, 缓冲区不足时与 UDP 一样截断
+type vlessPacketConn struct {
+	*vlessConn
+	writeMu sync.Mutex
+}
+
+func (c *vlessPacketConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if err := c.readResponse(); err != nil {
+		return 0, err
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(head[:]))
+	if n <= len(b) {
+		return io.ReadFull(c.reader, b[:n])
+	}
+	if _, err := io.ReadFull(c.reader, b); err != nil {
+		return 0, err
+	}
+	_, err := c.reader.Discard(n - len(b))
+	return len(b), err
+}
+
+func (c *vlessPacketConn) Write(b []byte) (int, error) {
+	if len(b) > maxPacketSize {
+		return 0, errors.New("vless: packet too large")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	packet := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(b)), uint16(len(b)))
+	if _, err := c.Conn.Write(append(packet, b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
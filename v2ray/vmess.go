@@ -0,0 +1,332 @@
+package v2ray
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+// VMess 数据块的加密方式
+const (
+	SecurityAES128GCM        byte = 0x03
+	SecurityChacha20Poly1305 byte = 0x04
+	SecurityNone             byte = 0x05
+)
+
+const (
+	vmessVersion byte = 1
+
+	optionChunkStream   byte = 0x01
+	optionChunkMasking  byte = 0x04
+	optionGlobalPadding byte = 0x08
+
+	// maxChunkPayload 使每个数据块不超过 8K, 与 v2ray 的缓冲区大小一致
+	maxChunkPayload = 8192 - 2 - 16 - 64
+)
+
+// VMessClient 实现了Client接口, 使用 AEAD 请求头, 每次 Dial 建立一个到服务端的连接
+type VMessClient struct {
+	Proxy *url.URL
+	Conf  *VMessConf
+}
+
+// VMessConf 配置结构
+type VMessConf struct {
+	// Dial 用于建立到服务端的连接, proxyclient 会按 Stream 包装 ws 与 tls 承载层
+	Dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	Server   string
+	UUID     [16]byte
+	Security byte
+	Stream   StreamSettings
+
+	Timeout time.Duration
+}
+
+// vmessLink 为 v2rayN 格式分享链接中的 JSON, port 与 aid 可能是字符串或数字
+type vmessLink struct {
+	Add           string      `json:"add"`
+	Port          interface{} `json:"port"`
+	ID            string      `json:"id"`
+	Aid           interface{} `json:"aid"`
+	Scy           string      `json:"scy"`
+	Net           string      `json:"net"`
+	Type          string      `json:"type"`
+	Host          string      `json:"host"`
+	Path          string      `json:"path"`
+	TLS           string      `json:"tls"`
+	SNI           string      `json:"sni"`
+	AllowInsecure interface{} `json:"allowInsecure"`
+}
+
+// NewVMessConfFromURL 解析 vmess 分享链接, 支持 v2rayN 的 vmess://base64(json) 与
+// vmess://uuid@host:port?encryption=auto&security=tls&type=ws&... 两种格式。
+// 始终使用 AEAD 请求头, 与要求 alterId 为 0 的服务端兼容
+func NewVMessConfFromURL(proxyURL *url.URL) (*VMessConf, error) {
+	if proxyURL.User != nil {
+		return newVMessConfFromQuery(proxyURL)
+	}
+	// base64 中的 / 会被解析为路径
+	raw := strings.TrimRight(proxyURL.Host+proxyURL.Path, "=")
+	data, err := base64.RawStdEncoding.DecodeString(raw)
+	if err != nil {
+		if data, err = base64.RawURLEncoding.DecodeString(raw); err != nil {
+			return nil, errors.New("vmess: invalid share link")
+		}
+	}
+	var link vmessLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, fmt.Errorf("vmess: invalid share link: %v", err)
+	}
+
+	conf := &VMessConf{
+		Dial:    (&net.Dialer{}).DialContext,
+		Server:  net.JoinHostPort(link.Add, jsonString(link.Port)),
+		Timeout: DefaultTimeout,
+	}
+	if conf.UUID, err = ParseUUID(link.ID); err != nil {
+		return nil, err
+	}
+	if conf.Security, err = parseSecurity(link.Scy); err != nil {
+		return nil, err
+	}
+	if link.Type != "" && link.Type != "none" {
+		return nil, fmt.Errorf("vmess: unsupported header type: %s", link.Type)
+	}
+	conf.Stream = StreamSettings{
+		Network:  strings.ToLower(link.Net),
+		Security: strings.ToLower(link.TLS),
+		Host:     link.Host,
+		Path:     link.Path,
+		SNI:      link.SNI,
+	}
+	if v := jsonString(link.AllowInsecure); v == "1" || v == "true" {
+		conf.Stream.AllowInsecure = true
+	}
+	if err := conf.Stream.check(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func newVMessConfFromQuery(proxyURL *url.URL) (*VMessConf, error) {
+	id, err := ParseUUID(proxyURL.User.Username())
+	if err != nil {
+		return nil, err
+	}
+	query := proxyURL.Query()
+	security, err := parseSecurity(query.Get("encryption"))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := parseStreamSettings(query)
+	if err != nil {
+		return nil, err
+	}
+	conf := &VMessConf{
+		Dial:     (&net.Dialer{}).DialContext,
+		Server:   serverAddress(proxyURL),
+		UUID:     id,
+		Security: security,
+		Stream:   stream,
+		Timeout:  DefaultTimeout,
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", v)
+		}
+		conf.Timeout = d
+	}
+	return conf, nil
+}
+
+// parseSecurity 解析加密方式, auto 使用 aes-128-gcm
+func parseSecurity(s string) (byte, error) {
+	switch strings.ToLower(s) {
+	case "", "auto", "aes-128-gcm":
+		return SecurityAES128GCM, nil
+	case "chacha20-poly1305", "chacha20-ietf-poly1305":
+		return SecurityChacha20Poly1305, nil
+	case "none":
+		return SecurityNone, nil
+	default:
+		return 0, fmt.Errorf("vmess: unsupported security: %s", s)
+	}
+}
+
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func (c *VMessClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext 连接服务端后立即发送加密的请求头, 响应头在第一次 Read 时读取
+func (c *VMessClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	command, err := commandByNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	// 请求头: Ver IV(16) Key(16) V Opt P|Sec Rsv Cmd Port ATYP ADDR Padding(P) FNV1a(4)
+	secret := make([]byte, 34)
+	rand.Read(secret)
+	iv, key, v := secret[:16], secret[16:32], secret[32]
+	padding := int(secret[33] % 16)
+	// 与 v2ray 一致, 只有 AEAD 加密时启用随机填充
+	options := optionChunkStream | optionChunkMasking
+	if c.Conf.Security != SecurityNone {
+		options |= optionGlobalPadding
+	}
+
+	header := append([]byte{vmessVersion}, iv...)
+	header = append(header, key...)
+	header = append(header, v, options, byte(padding<<4)|c.Conf.Security, 0, command)
+	if header, err = appendAddress(header, address); err != nil {
+		return nil, err
+	}
+	pad := make([]byte, padding)
+	rand.Read(pad)
+	header = append(header, pad...)
+	h := fnv.New32a()
+	h.Write(header)
+	header = h.Sum(header)
+
+	if c.Conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Conf.Timeout)
+		defer cancel()
+	}
+	conn, err := c.Conf.Dial(ctx, "tcp", c.Conf.Server)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(sealHeader(cmdKey(c.Conf.UUID), header)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	respKey, respIV := responseKey(key), responseKey(iv)
+	return &vmessConn{
+		Conn:    conn,
+		remote:  tunnel.NewAddr(network, address),
+		packet:  command == commandUDP,
+		respV:   v,
+		respKey: respKey,
+		respIV:  respIV,
+		writer:  newChunkCodec(c.Conf.Security, key, iv, options&optionGlobalPadding != 0),
+		reader:  bufio.NewReader(conn),
+		rcodec:  newChunkCodec(c.Conf.Security, respKey, respIV, options&optionGlobalPadding != 0),
+		buf:     make([]byte, maxPacketSize),
+	}, nil
+}
+
+// vmessConn 把数据按块加密传输, udp 时每个数据块为一个 UDP 包
+type vmessConn struct {
+	net.Conn
+	remote net.Addr
+	packet bool
+
+	writeMu sync.Mutex
+	writer  *chunkCodec
+
+	readMu  sync.Mutex
+	reader  *bufio.Reader
+	rcodec  *chunkCodec
+	ready   bool
+	respV   byte
+	respKey []byte
+	respIV  []byte
+	buf     []byte
+	pending []byte
+
+	closeOnce sync.Once
+}
+
+func (c *vmessConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if !c.ready {
+		header, err := openResponseHeader(c.reader, c.respKey, c.respIV)
+		if err != nil {
+			return 0, err
+		}
+		if len(header) < 4 || header[0] != c.respV {
+			return 0, errors.New("vmess: unexpected response header")
+		}
+		c.ready = true
+	}
+	if len(c.pending) == 0 {
+		data, err := c.rcodec.open(c.reader, c.buf)
+		if err != nil {
+			return 0, err
+		}
+		if c.packet {
+			// 缓冲区不足时与 UDP 一样截断
+			return copy(b, data), nil
+		}
+		c.pending = data
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *vmessConn) Write(b []byte) (int, error) {
+	if c.packet && len(b) > maxChunkPayload {
+		return 0, errors.New("vmess: packet too large")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var out []byte
+	for p := b; len(p) > 0; {
+		n := len(p)
+		if n > maxChunkPayload {
+			n = maxChunkPayload
+		}
+		out = c.writer.seal(out, p[:n])
+		p = p[n:]
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 发送表示数据结束的空数据块后关闭连接
+func (c *vmessConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		// 截止时间使阻塞的 Write 返回, 避免 Close 一直等待
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeMu.Lock()
+		c.Conn.Write(c.writer.seal(nil, nil))
+		c.writeMu.Unlock()
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+func (c *vmessConn) RemoteAddr() net.Addr {
+	return c.remote
+}
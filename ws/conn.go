@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn 把 WebSocket 适配为 net.Conn, 数据以二进制消息发送, 读取时把连续的消息视为字节流
+type Conn struct {
+	ws *websocket.Conn
+
+	readMu  sync.Mutex
+	reader  io.Reader
+	writeMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// NewConn 包装已经完成握手的 WebSocket 连接
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws, done: make(chan struct{})}
+}
+
+// KeepAlive 每隔 interval 发送一次 ping, 避免中间设备因空闲断开连接, 连接关闭时停止
+func (c *Conn) KeepAlive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+					return
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		if c.reader == nil {
+			mt, r, err := c.ws.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if mt != websocket.BinaryMessage && mt != websocket.TextMessage {
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			// 当前消息读完, 下一次从新的消息读取
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 发送关闭消息后关闭底层连接, 可以重复调用
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = c.ws.Close()
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+// Dial 经由 dial 建立 WebSocket 连接, wss 时使用 tlsConfig 进行 TLS 握手。
+// 握手失败时返回的 error 包含服务端的响应状态
+func Dial(ctx context.Context, dial func(ctx context.Context, network, address string) (net.Conn, error),
+	target string, header http.Header, tlsConfig *tls.Config, subprotocols []string) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{
+		NetDialContext:  dial,
+		TLSClientConfig: tlsConfig,
+		Subprotocols:    subprotocols,
+	}
+	ws, resp, err := dialer.DialContext(ctx, target, header)
+	if err != nil {
+		if resp != nil {
+			return nil, &HandshakeError{Status: resp.Status, Err: err}
+		}
+		return nil, err
+	}
+	return ws, nil
+}
+
+// HandshakeError 为服务端拒绝 WebSocket 升级时返回的错误
+type HandshakeError struct {
+	Status string
+	Err    error
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket handshake failed: " + e.Status
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chainreactors/proxyclient/acl"
+	"github.com/chainreactors/proxyclient/relay"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultDialTimeout 为服务端连接目标的超时时间
+var DefaultDialTimeout = 5 * time.Second
+
+// Handler 为 ws/wss 传输的服务端, 把每个 WebSocket 连接转发到一个 TCP 目标。
+// 目标地址依次取自 Backend、PathPrefix 之后的路径与 TargetHeader 请求头;
+// 设置 Backend 时作为其他协议的承载层, 例如在 socks5 服务前接收 socks5+ws 的连接。
+// EarlyDataHeader 中的数据在连接目标后首先写入。非 WebSocket 请求交给 NotFound 处理。
+//
+// 注意: 目标地址取自路径或请求头时由客户端决定, Policy 为空的 Handler 是一个开放代理,
+// 任何能访问该地址的人都可以经由它连接内网。对外提供服务时应设置 Policy 或 Backend。
+type Handler struct {
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	Backend      string
+	PathPrefix   string
+	TargetHeader string
+	// Policy 返回 false 时拒绝连接该目标并回复 403, 为空时允许所有目标
+	Policy acl.Policy
+
+	EarlyDataHeader string
+	PingInterval    time.Duration
+	IdleTimeout     time.Duration
+	NotFound        http.Handler
+
+	upgrader websocket.Upgrader
+}
+
+// NewHandler 创建从请求头读取目标地址的服务端, 默认允许所有目标, 见 Handler 中关于 Policy 的说明
+func NewHandler() *Handler {
+	return &Handler{
+		Dial:            (&net.Dialer{Timeout: DefaultDialTimeout}).DialContext,
+		TargetHeader:    DefaultTargetHeader,
+		EarlyDataHeader: DefaultEarlyDataHeader,
+		NotFound:        http.NotFoundHandler(),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		h.NotFound.ServeHTTP(w, r)
+		return
+	}
+	address := h.target(r)
+	if address == "" {
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return
+	}
+	address, ok := h.allow(r, address)
+	if !ok {
+		http.Error(w, acl.ErrDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	var early []byte
+	var respHeader http.Header
+	if h.EarlyDataHeader != "" {
+		if v := r.Header.Get(h.EarlyDataHeader); v != "" {
+			data, err := base64.RawURLEncoding.DecodeString(v)
+			if err != nil {
+				http.Error(w, "invalid early data", http.StatusBadRequest)
+				return
+			}
+			early = data
+			if http.CanonicalHeaderKey(h.EarlyDataHeader) == "Sec-Websocket-Protocol" {
+				// 客户端校验响应中的子协议, 原样返回
+				respHeader = http.Header{"Sec-Websocket-Protocol": {v}}
+			}
+		}
+	}
+
+	target, err := h.Dial(r.Context(), "tcp", address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+	if len(early) > 0 {
+		if _, err := target.Write(early); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	wsConn, err := h.upgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		return
+	}
+	conn := NewConn(wsConn)
+	defer conn.Close()
+	conn.KeepAlive(h.PingInterval)
+	relay.Relay(conn, target, h.IdleTimeout)
+}
+
+// allow 返回放行时拨号使用的地址, 使用 Policy 检查过的 IP, 见 acl.Request.DialAddress
+func (h *Handler) allow(r *http.Request, address string) (string, bool) {
+	if h.Policy == nil {
+		return address, true
+	}
+	request, err := acl.NewRequest("", acl.ParseAddr(r.RemoteAddr), acl.CommandConnect, address)
+	if err != nil || !h.Policy(request) {
+		return "", false
+	}
+	return request.DialAddress(address), true
+}
+
+func (h *Handler) target(r *http.Request) string {
+	if h.Backend != "" {
+		return h.Backend
+	}
+	if h.PathPrefix != "" && strings.HasPrefix(r.URL.Path, h.PathPrefix) {
+		if address, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, h.PathPrefix)); err == nil && address != "" {
+			return address
+		}
+	}
+	if h.TargetHeader != "" {
+		return r.Header.Get(h.TargetHeader)
+	}
+	return ""
+}
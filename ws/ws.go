@@ -0,0 +1,314 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chainreactors/proxyclient/tunnel"
+)
+
+var (
+	DefaultTimeout = 10 * time.Second
+	// DefaultTargetHeader 为携带目标地址的请求头
+	DefaultTargetHeader = "X-Target"
+	// DefaultEarlyDataHeader 为携带 early data 的请求头, 与 v2ray/xray 一致
+	DefaultEarlyDataHeader = "Sec-WebSocket-Protocol"
+	// EarlyDataWait 为启用 early data 时, 先 Read 的连接等待第一次 Write 的时间,
+	// 超时后不携带数据直接握手, 避免服务端先发送数据的协议一直阻塞
+	EarlyDataWait = 200 * time.Millisecond
+)
+
+// targetPlaceholder 出现在路径中时被替换为目标地址, 否则目标地址放在 TargetHeader 中
+const targetPlaceholder = "{target}"
+
+// WSClient 实现了Client接口, 每次 Dial 建立一个 WebSocket 连接, 由服务端连接目标地址
+type WSClient struct {
+	Proxy *url.URL
+	Conf  *WSConf
+}
+
+// WSConf 配置结构
+type WSConf struct {
+	// Dial 用于建立到 WebSocket 服务端的连接, 可以替换为上游代理
+	Dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	TLSConfig *tls.Config
+	// URL 为 ws:// 或 wss:// 形式的服务端地址, 路径中可以包含 {target}
+	URL     *url.URL
+	Headers http.Header
+
+	TargetHeader string
+	// EarlyData 大于 0 时, 第一次 Write 的前 EarlyData 字节随握手请求放在 EarlyDataHeader 中发送, 节省一个往返
+	EarlyData       int
+	EarlyDataHeader string
+	// PingInterval 大于 0 时定时发送 ping 保活
+	PingInterval time.Duration
+	Timeout      time.Duration
+}
+
+// NewConfFromURL 从URL中解析参数生成配置:
+// host (Host 头), header (可重复, "Name: value"), ua, target_header, early_data, early_data_header, ping, timeout, 以及 tls-* 参数
+func NewConfFromURL(proxyURL *url.URL) (*WSConf, error) {
+	scheme := "ws"
+	switch strings.ToLower(proxyURL.Scheme) {
+	case "ws":
+		scheme = "ws"
+	case "wss":
+		scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", proxyURL.Scheme)
+	}
+
+	query := proxyURL.Query()
+	tlsConfig, err := tunnel.TLSConfigFromQuery(proxyURL.Hostname(), query)
+	if err != nil {
+		return nil, err
+	}
+	conf := &WSConf{
+		Dial:            (&net.Dialer{}).DialContext,
+		TLSConfig:       tlsConfig,
+		URL:             &url.URL{Scheme: scheme, Host: proxyURL.Host, Path: proxyURL.Path, RawPath: proxyURL.RawPath},
+		TargetHeader:    DefaultTargetHeader,
+		EarlyDataHeader: DefaultEarlyDataHeader,
+		Timeout:         DefaultTimeout,
+	}
+
+	var opts tunnel.HTTPOptions
+	if err := tunnel.ParseHTTPOptions(&opts, query); err != nil {
+		return nil, err
+	}
+	conf.Headers = opts.Headers
+	if conf.Headers == nil {
+		conf.Headers = make(http.Header)
+	}
+	if v := query.Get("host"); v != "" {
+		conf.Headers.Set("Host", v)
+	}
+	if v := query.Get("target_header"); v != "" {
+		conf.TargetHeader = v
+	}
+	if v := query.Get("early_data"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid early_data: %s", v)
+		}
+		conf.EarlyData = n
+	}
+	if v := query.Get("early_data_header"); v != "" {
+		conf.EarlyDataHeader = v
+	}
+	if v := query.Get("ping"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ping: %s", v)
+		}
+		conf.PingInterval = d
+	}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", v)
+		}
+		conf.Timeout = d
+	}
+	return conf, nil
+}
+
+func (c *WSClient) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext 建立到服务端的 WebSocket 连接并请求连接 address。
+// 启用 early data 时握手推迟到第一次 Write, ctx 只作用于立即进行的握手
+func (c *WSClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if c.Conf.EarlyData > 0 {
+		return newEarlyConn(c.Conf, address), nil
+	}
+	return c.Conf.handshake(ctx, address, nil)
+}
+
+// handshake 建立 WebSocket 连接, early 不为空时随握手请求发送
+func (conf *WSConf) handshake(ctx context.Context, address string, early []byte) (*Conn, error) {
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+	}
+
+	target := *conf.URL
+	header := conf.Headers.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if strings.Contains(target.Path, targetPlaceholder) {
+		target.Path = strings.Replace(target.Path, targetPlaceholder, address, 1)
+		target.RawPath = ""
+	} else if conf.TargetHeader != "" {
+		header.Set(conf.TargetHeader, address)
+	}
+	if len(early) > 0 {
+		header.Set(conf.EarlyDataHeader, base64.RawURLEncoding.EncodeToString(early))
+	}
+
+	wsConn, err := Dial(ctx, conf.Dial, target.String(), header, conf.TLSConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn := NewConn(wsConn)
+	conn.KeepAlive(conf.PingInterval)
+	return conn, nil
+}
+
+// earlyConn 推迟握手, 把第一次 Write 的数据随握手请求发送
+type earlyConn struct {
+	conf    *WSConf
+	address string
+
+	once  sync.Once
+	ready chan struct{} // 握手完成后关闭, 之后 conn 与 err 不再改变
+	conn  *Conn
+	err   error
+
+	// mu 保护握手前设置的截止时间与 closed, 握手结束时一并处理
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+}
+
+var _ net.Conn = (*earlyConn)(nil)
+
+func newEarlyConn(conf *WSConf, address string) *earlyConn {
+	return &earlyConn{conf: conf, address: address, ready: make(chan struct{})}
+}
+
+// connect 进行握手并等待完成, 返回 true 表示 early 已随本次握手发出
+func (c *earlyConn) connect(early []byte) (sent bool) {
+	c.once.Do(func() {
+		conn, err := c.conf.handshake(context.Background(), c.address, early)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err == nil && c.closed {
+			conn.Close()
+			err = net.ErrClosed
+		}
+		if err == nil {
+			conn.SetReadDeadline(c.readDeadline)
+			conn.SetWriteDeadline(c.writeDeadline)
+		}
+		c.conn, c.err = conn, err
+		sent = err == nil
+		close(c.ready)
+	})
+	<-c.ready
+	return sent
+}
+
+func (c *earlyConn) Write(b []byte) (int, error) {
+	n := len(b)
+	if n > c.conf.EarlyData {
+		n = c.conf.EarlyData
+	}
+	if c.connect(b[:n]) {
+		if n == len(b) {
+			return n, nil
+		}
+		m, err := c.conn.Write(b[n:])
+		return n + m, err
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Write(b)
+}
+
+func (c *earlyConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.ready:
+	case <-time.After(EarlyDataWait):
+		c.connect(nil)
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *earlyConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	select {
+	case <-c.ready:
+		if c.conn != nil {
+			return c.conn.Close()
+		}
+	default:
+	}
+	return nil
+}
+
+// LocalAddr 握手前返回服务端地址
+func (c *earlyConn) LocalAddr() net.Addr {
+	select {
+	case <-c.ready:
+		if c.conn != nil {
+			return c.conn.LocalAddr()
+		}
+	default:
+	}
+	return tunnel.NewAddr("ws", c.conf.URL.String())
+}
+
+// RemoteAddr 握手前返回目标地址
+func (c *earlyConn) RemoteAddr() net.Addr {
+	select {
+	case <-c.ready:
+		if c.conn != nil {
+			return c.conn.RemoteAddr()
+		}
+	default:
+	}
+	return tunnel.NewAddr("ws", c.address)
+}
+
+func (c *earlyConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *earlyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	select {
+	case <-c.ready:
+		if c.conn != nil {
+			return c.conn.SetReadDeadline(t)
+		}
+	default:
+	}
+	return nil
+}
+
+func (c *earlyConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	select {
+	case <-c.ready:
+		if c.conn != nil {
+			return c.conn.SetWriteDeadline(t)
+		}
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chainreactors/proxyclient/acl"
+	"github.com/chainreactors/proxyclient/internal/testutil"
+	"github.com/gorilla/websocket"
+)
+
+func newTestClient(t *testing.T, rawURL string) *WSClient {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewConfFromURL(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WSClient{Proxy: proxyURL, Conf: conf}
+}
+
+func testEcho(t *testing.T, conn net.Conn, payload []byte) {
+	go conn.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("echoed data mismatch")
+	}
+}
+
+func TestWSHeaderTarget(t *testing.T) {
+	target := testutil.Echo(t)
+	handler := NewHandler()
+	var host string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		handler.ServeHTTP(w, r)
+	}))
+	defer hs.Close()
+
+	client := newTestClient(t, "ws://"+strings.TrimPrefix(hs.URL, "http://")+"/tunnel?host=cdn.example.com")
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testEcho(t, conn, bytes.Repeat([]byte("ws"), 64*1024))
+	if host != "cdn.example.com" {
+		t.Errorf("Host = %s", host)
+	}
+
+	// 普通请求与缺少目标地址的升级请求被拒绝
+	if resp, err := http.Get(hs.URL); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("plain request status = %d", resp.StatusCode)
+		}
+	}
+	client.Conf.TargetHeader = ""
+	if _, err := client.Dial("tcp", target); err == nil {
+		t.Error("Dial without target succeeded")
+	}
+}
+
+func TestWSPathTarget(t *testing.T) {
+	target := testutil.Echo(t)
+	handler := NewHandler()
+	handler.PathPrefix = "/t/"
+	hs := httptest.NewTLSServer(handler)
+	defer hs.Close()
+
+	client := newTestClient(t, "wss://"+strings.TrimPrefix(hs.URL, "https://")+"/t/{target}?tls-insecure-skip-verify=true")
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testEcho(t, conn, []byte("path target"))
+
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("Dial to closed port succeeded")
+	} else if _, ok := err.(*HandshakeError); !ok {
+		t.Errorf("err = %v, want HandshakeError", err)
+	}
+}
+
+func TestWSEarlyData(t *testing.T) {
+	target := testutil.Echo(t)
+	for _, header := range []string{"", "X-Early-Data"} {
+		handler := NewHandler()
+		var early atomic.Value
+		hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			early.Store(r.Header.Get(handler.EarlyDataHeader))
+			handler.ServeHTTP(w, r)
+		}))
+
+		rawURL := "ws://" + strings.TrimPrefix(hs.URL, "http://") + "/?early_data=4"
+		if header != "" {
+			handler.EarlyDataHeader = header
+			rawURL += "&early_data_header=" + header
+		}
+		client := newTestClient(t, rawURL)
+		conn, err := client.Dial("tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testEcho(t, conn, []byte("early data"))
+		if v, _ := early.Load().(string); v != "ZWFybA" {
+			t.Errorf("%s: early data header = %q", header, v)
+		}
+		conn.Close()
+
+		// 先读的连接在等待后不带数据握手
+		conn, err = client.Dial("tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(EarlyDataWait * 2))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("%s: read without data succeeded", header)
+		}
+		if v, _ := early.Load().(string); v != "" {
+			t.Errorf("%s: unexpected early data %q", header, v)
+		}
+		conn.Close()
+		hs.Close()
+	}
+}
+
+func TestWSPing(t *testing.T) {
+	target := testutil.Echo(t)
+	pings := make(chan struct{}, 8)
+	upgrader := websocket.Upgrader{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer wsConn.Close()
+		wsConn.SetPingHandler(func(string) error {
+			pings <- struct{}{}
+			return nil
+		})
+		for {
+			if _, _, err := wsConn.NextReader(); err != nil {
+				return
+			}
+		}
+	}))
+	defer hs.Close()
+
+	client := newTestClient(t, "ws://"+strings.TrimPrefix(hs.URL, "http://")+"/?ping=20ms")
+	conn, err := client.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatal("no ping received")
+		}
+	}
+}
+
+func TestWSPolicy(t *testing.T) {
+	target := testutil.Echo(t)
+	handler := NewHandler()
+	handler.Policy = func(r *acl.Request) bool { return r.Address() == target }
+	handler.IdleTimeout = 50 * time.Millisecond
+	hs := httptest.NewServer(handler)
+	defer hs.Close()
+
+	client := newTestClient(t, "ws://"+strings.TrimPrefix(hs.URL, "http://")+"/tunnel")
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testEcho(t, conn, []byte("allowed"))
+	// 空闲超过 IdleTimeout 后服务端断开
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("idle connection not closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Error("idle connection not closed before deadline")
+	}
+
+	_, err = client.Dial("tcp", "127.0.0.1:22")
+	if hsErr, ok := err.(*HandshakeError); !ok || !strings.HasPrefix(hsErr.Status, "403") {
+		t.Errorf("err = %v, want 403 HandshakeError", err)
+	}
+}